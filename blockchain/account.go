@@ -18,16 +18,28 @@ type Account struct {
 	Email        string `json:"email" validate:"min=6,max=80"`
 	Phone        string `json:"phone" validate:"min=6,max=40"`
 	Address      string `json:"address" validate:"min=10,max=140"`
-	PublicKey    string `json:"publicKey" validate:"len=128"`
+	PublicKey    string `json:"publicKey" validate:"min=64,max=128"` // 128 hex for secp256k1, 64 hex for ed25519
+	KeyType      string `json:"keyType,omitempty"`                   // empty (secp256k1) or ed25519, see KeyTypeSecp256k1
 	Role         `json:"role"`
-	LastModified int64 `json:"lastModified"`
+	LastModified int64        `json:"lastModified"`
+	KeySince     int64        `json:"-"` // when the public key became effective, 0 since the registration
+	KeyHistory   []AccountKey `json:"-"` // the rotated keys, the oldest first
+}
+
+// AccountKey is a rotated key of an account and when it was effective, unix time in milliseconds
+type AccountKey struct {
+	PublicKey      string `json:"publicKey"`
+	KeyType        string `json:"keyType,omitempty"`
+	EffectiveFrom  int64  `json:"effectiveFrom"`
+	EffectiveUntil int64  `json:"effectiveUntil"`
 }
 
 // Role represents the rights of access to collections and API endpoints
 type Role struct {
-	Name                    string   `json:"name"`
-	CollectionsWrite        []string `json:"collectionsWrite"`
-	CollectionsReadOverride []string `json:"collectionsReadOverride"`
+	Name                    string              `json:"name"`
+	CollectionsWrite        []string            `json:"collectionsWrite"`
+	CollectionsReadOverride []string            `json:"collectionsReadOverride"`
+	SensitiveFieldsRead     map[string][]string `json:"sensitiveFieldsRead"` // collection -> sensitive fields, or * for all of them
 }
 
 // Serialize serializes the account
@@ -81,11 +93,19 @@ func (a Account) ToMap(isAdmin bool) map[string]interface{} {
 	accountMap["phone"] = a.Phone
 	accountMap["address"] = a.Address
 	accountMap["publicKey"] = a.PublicKey
+	if a.KeyType != KeyTypeSecp256k1 {
+		accountMap["keyType"] = a.KeyType
+	}
+	if len(a.KeyHistory) > 0 {
+		accountMap["keySince"] = a.KeySince
+		accountMap["keyHistory"] = a.KeyHistory
+	}
 
 	if isAdmin {
 		accountMap["roleName"] = a.Role.Name
 		accountMap["collectionsReadOverride"] = a.Role.CollectionsReadOverride
 		accountMap["collectionsWrite"] = a.Role.CollectionsWrite
+		accountMap["sensitiveFieldsRead"] = a.Role.SensitiveFieldsRead
 	}
 
 	return accountMap
@@ -92,8 +92,12 @@ func (b Block) Persist(db *bolt.DB, isTip bool) ([]byte, error) {
 	err = db.Update(func(dbtx *bolt.Tx) error {
 		bBucket := dbtx.Bucket([]byte(BlocksBucket))
 		txBucket := dbtx.Bucket([]byte(TransactionsBucket))
+		txIndexBucket, err := dbtx.CreateBucketIfNotExists([]byte(TxIndexBucket))
+		if err != nil {
+			log.Panic(err)
+		}
 
-		err := bBucket.Put(b.Hash, encodedBlock)
+		err = bBucket.Put(b.Hash, encodedBlock)
 
 		if err != nil {
 			log.Panic(err)
@@ -105,6 +109,12 @@ func (b Block) Persist(db *bolt.DB, isTip bool) ([]byte, error) {
 			if err != nil {
 				log.Panic(err)
 			}
+
+			// find the block of a transaction by its ID
+			err = txIndexBucket.Put(tx.ID, b.Hash)
+			if err != nil {
+				log.Panic(err)
+			}
 		}
 
 		if isTip { // only update tip and height if this is a tip block (local or peer)
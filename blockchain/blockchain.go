@@ -139,6 +139,11 @@ func NewBlockchain(dbFile string, dataDir string) *Blockchain {
 		return nil
 	})
 
+	err = BackfillTxIndex(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	blockchainSearch, err := NewSearch(db, dataDir)
 
 	if err != nil {
@@ -206,6 +211,11 @@ func CreateBlockchain(dbFile string, dataDir string) *Blockchain {
 		log.Panic(err)
 	}
 
+	err = BackfillTxIndex(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	blockchainSearch, err := NewSearch(db, dataDir)
 
 	if err != nil {
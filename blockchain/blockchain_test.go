@@ -0,0 +1,29 @@
+package blockchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// newTestBlockchain opens an empty blockchain database in a temporary directory. Returns the directory and the function to remove it
+func newTestBlockchain(t *testing.T) (*Blockchain, string, func()) {
+	dir, err := ioutil.TempDir("", "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "blockchain.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return &Blockchain{Db: db}, dir, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,181 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Canonicalize returns the JSON Canonicalization Scheme (RFC 8785) form of a JSON document: no whitespace, the object members sorted by the UTF-16 code units
+// of their names, the numbers serialized as in ECMAScript and the strings with the minimal escaping. Semantically identical documents have the same canonical form
+func Canonicalize(rawData []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(rawData))
+	decoder.UseNumber()
+
+	var canonical bytes.Buffer
+	if err := writeCanonicalValue(decoder, &canonical); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: data after the top-level value")
+	}
+
+	return canonical.Bytes(), nil
+}
+
+func writeCanonicalValue(decoder *json.Decoder, canonical *bytes.Buffer) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %s", err)
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '[' {
+			canonical.WriteByte('[')
+			for i := 0; decoder.More(); i++ {
+				if i > 0 {
+					canonical.WriteByte(',')
+				}
+				if err = writeCanonicalValue(decoder, canonical); err != nil {
+					return err
+				}
+			}
+			decoder.Token() // ]
+			canonical.WriteByte(']')
+			return nil
+		}
+
+		members := make(map[string][]byte)
+		var names []string
+		for decoder.More() {
+			nameToken, err := decoder.Token()
+			if err != nil {
+				return fmt.Errorf("invalid JSON: %s", err)
+			}
+			name := nameToken.(string)
+			if _, ok := members[name]; ok {
+				return fmt.Errorf("invalid JSON: duplicate member %s", name)
+			}
+
+			var member bytes.Buffer
+			if err = writeCanonicalValue(decoder, &member); err != nil {
+				return err
+			}
+			members[name] = member.Bytes()
+			names = append(names, name)
+		}
+		decoder.Token() // }
+
+		sort.Slice(names, func(i, j int) bool { return lessUTF16(names[i], names[j]) })
+		canonical.WriteByte('{')
+		for i, name := range names {
+			if i > 0 {
+				canonical.WriteByte(',')
+			}
+			writeCanonicalString(name, canonical)
+			canonical.WriteByte(':')
+			canonical.Write(members[name])
+		}
+		canonical.WriteByte('}')
+	case string:
+		writeCanonicalString(value, canonical)
+	case json.Number:
+		number, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			return fmt.Errorf("invalid JSON: the number %s is out of the IEEE 754 double range", value)
+		}
+		canonical.WriteString(canonicalNumber(number))
+	case bool:
+		canonical.WriteString(strconv.FormatBool(value))
+	case nil:
+		canonical.WriteString("null")
+	}
+
+	return nil
+}
+
+func lessUTF16(a, b string) bool {
+	unitsA, unitsB := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(unitsA) && i < len(unitsB); i++ {
+		if unitsA[i] != unitsB[i] {
+			return unitsA[i] < unitsB[i]
+		}
+	}
+
+	return len(unitsA) < len(unitsB)
+}
+
+func writeCanonicalString(value string, canonical *bytes.Buffer) {
+	canonical.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			canonical.WriteString(`\"`)
+		case '\\':
+			canonical.WriteString(`\\`)
+		case '\b':
+			canonical.WriteString(`\b`)
+		case '\f':
+			canonical.WriteString(`\f`)
+		case '\n':
+			canonical.WriteString(`\n`)
+		case '\r':
+			canonical.WriteString(`\r`)
+		case '\t':
+			canonical.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(canonical, `\u%04x`, r)
+			} else {
+				canonical.WriteRune(r)
+			}
+		}
+	}
+	canonical.WriteByte('"')
+}
+
+// canonicalNumber serializes a number as ECMAScript Number.prototype.toString does: the shortest digits that round trip,
+// in the plain notation if the decimal exponent is in [-6, 21), otherwise in the exponential notation
+func canonicalNumber(number float64) string {
+	if number == 0 || math.IsNaN(number) || math.IsInf(number, 0) { // -0 is 0; NaN and Infinity are not in JSON
+		return "0"
+	}
+
+	sign := ""
+	if number < 0 {
+		sign = "-"
+		number = -number
+	}
+
+	// d.ddddde±xx
+	exponential := strconv.FormatFloat(number, 'e', -1, 64)
+	mantissa, exponentPart := exponential[:strings.IndexByte(exponential, 'e')], exponential[strings.IndexByte(exponential, 'e')+1:]
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exponent, _ := strconv.Atoi(exponentPart)
+	point := exponent + 1 // the position of the decimal point in the digits
+
+	switch {
+	case len(digits) <= point && point <= 21:
+		return sign + digits + strings.Repeat("0", point-len(digits))
+	case 0 < point && point <= 21:
+		return sign + digits[:point] + "." + digits[point:]
+	case -6 < point && point <= 0:
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	}
+
+	exponentOfPoint := strconv.Itoa(point - 1)
+	if point-1 >= 0 {
+		exponentOfPoint = "+" + exponentOfPoint
+	}
+	if len(digits) == 1 {
+		return sign + digits + "e" + exponentOfPoint
+	}
+	return sign + digits[:1] + "." + digits[1:] + "e" + exponentOfPoint
+}
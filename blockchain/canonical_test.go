@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	// the examples of RFC 8785
+	cases := map[string]string{
+		`{
+			"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+			"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+			"literals": [null, true, false]
+		}`: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		`{
+			"\u20ac": "Euro Sign",
+			"\r": "Carriage Return",
+			"\ufb33": "Hebrew Letter Dalet With Dagesh",
+			"1": "One",
+			"\ud83d\ude00": "Emoji: Grinning Face",
+			"\u0080": "Control",
+			"\u00f6": "Latin Small Letter O With Diaeresis"
+		}`: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		`{"b": {"d": [], "c": {}}, "a": "<&>"}`: `{"a":"<&>","b":{"c":{},"d":[]}}`,
+	}
+	for input, expected := range cases {
+		canonical, err := Canonicalize([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(canonical) != expected {
+			t.Errorf("expected: %s, actual: %s", expected, canonical)
+		}
+	}
+
+	for _, invalid := range []string{`{"a": 1, "a": 2}`, `{"a": 1} {}`, `{"a": 1e400}`, `{"a": }`} {
+		if _, err := Canonicalize([]byte(invalid)); err == nil {
+			t.Errorf("expected %s rejected", invalid)
+		}
+	}
+}
+
+func TestCanonicalNumber(t *testing.T) {
+	cases := map[float64]string{
+		0:                       "0",
+		math.Copysign(0, -1):    "0",
+		1:                       "1",
+		-1.5:                    "-1.5",
+		1e20:                    "100000000000000000000",
+		1e21:                    "1e+21",
+		0.000001:                "0.000001",
+		1e-7:                    "1e-7",
+		123e-20:                 "1.23e-18",
+		5e-324:                  "5e-324",
+		1.7976931348623157e308:  "1.7976931348623157e+308",
+		9007199254740992:        "9007199254740992",
+		295147905179352830000:   "295147905179352830000",
+		-1.2345678901234568e-10: "-1.2345678901234568e-10",
+	}
+	for number, expected := range cases {
+		if actual := canonicalNumber(number); actual != expected {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package blockchain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/boltdb/bolt"
+	log "github.com/sirupsen/logrus"
+)
+
+// The states of a collection. A dropped collection is kept as a tombstone so that the peers don't create it again
+const (
+	CollectionActive   = ""
+	CollectionArchived = "archived"
+	CollectionDropped  = "dropped"
+)
+
+// ResolveCollection returns the collection an alias points to, or the name itself if it's not an alias
+func (s *Search) ResolveCollection(name string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	if collection, ok := s.aliases[name]; ok {
+		return collection
+	}
+	return name
+}
+
+// IsFieldMapped tells if the index of the collection maps a field. The indices created by older versions lack the system fields added since, e.g. _blockHeight
+func (s *Search) IsFieldMapped(collection string, field string) bool {
+	index := s.BlockchainIndices[collection]
+	if index == nil {
+		return false
+	}
+
+	indexMapping, ok := index.Mapping().(*mapping.IndexMappingImpl)
+	if !ok || indexMapping.TypeMapping[collection] == nil {
+		return false
+	}
+
+	return indexMapping.TypeMapping[collection].Properties[field] != nil
+}
+
+// GetArchivedCollections returns the names of the archived collections
+func (s *Search) GetArchivedCollections() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	var names []string
+	for name := range s.archivedIndices {
+		names = append(names, name)
+	}
+	return names
+}
+
+// GetAliases returns the aliases and the collections they point to
+func (s *Search) GetAliases() map[string]string {
+	s.Lock()
+	defer s.Unlock()
+
+	aliases := make(map[string]string)
+	for alias, collection := range s.aliases {
+		aliases[alias] = collection
+	}
+	return aliases
+}
+
+// DropCollection deletes the index of a collection or removes an alias. The documents stay on chain. Returns the tombstone to sync to the peers
+func (s *Search) DropCollection(name string) (*DocumentMapping, error) {
+	documentMapping, err := s.GetDocumentMapping(name)
+	if err != nil {
+		return nil, err
+	} else if documentMapping.State == CollectionDropped {
+		return nil, fmt.Errorf("collection %s doesn't exist", name)
+	}
+
+	documentMapping.State = CollectionDropped
+	documentMapping.LastModified = time.Now().UnixNano() / 1000000
+
+	return documentMapping, s.ApplyMapping(*documentMapping)
+}
+
+// ArchiveCollection makes a collection read-only and unsearchable. Returns the mapping to sync to the peers
+func (s *Search) ArchiveCollection(name string) (*DocumentMapping, error) {
+	return s.setCollectionState(name, CollectionActive, CollectionArchived)
+}
+
+// RestoreCollection makes an archived collection writable and searchable again. Returns the mapping to sync to the peers
+func (s *Search) RestoreCollection(name string) (*DocumentMapping, error) {
+	return s.setCollectionState(name, CollectionArchived, CollectionActive)
+}
+
+func (s *Search) setCollectionState(name string, fromState string, toState string) (*DocumentMapping, error) {
+	documentMapping, err := s.GetDocumentMapping(name)
+	if err != nil {
+		return nil, err
+	} else if len(documentMapping.AliasOf) > 0 {
+		return nil, fmt.Errorf("%s is an alias of collection %s", name, documentMapping.AliasOf)
+	} else if documentMapping.State != fromState {
+		return nil, fmt.Errorf("collection %s is not %s", name, collectionStateName(fromState))
+	}
+
+	documentMapping.State = toState
+	documentMapping.LastModified = time.Now().UnixNano() / 1000000
+
+	return documentMapping, s.ApplyMapping(*documentMapping)
+}
+
+// CreateAlias creates another name for a collection, which can be used to write and search it. Returns the alias to sync to the peers
+func (s *Search) CreateAlias(alias string, collection string) (*DocumentMapping, error) {
+	if existing, err := s.GetDocumentMapping(alias); err == nil && existing.State != CollectionDropped {
+		return nil, fmt.Errorf("the collection %s already exists", alias)
+	}
+
+	documentMapping, err := s.GetDocumentMapping(collection)
+	if err != nil || documentMapping.State == CollectionDropped {
+		return nil, fmt.Errorf("collection %s doesn't exist", collection)
+	} else if len(documentMapping.AliasOf) > 0 {
+		return nil, fmt.Errorf("%s is an alias of collection %s", collection, documentMapping.AliasOf)
+	}
+
+	aliasMapping := DocumentMapping{Collection: alias, AliasOf: collection, LastModified: time.Now().UnixNano() / 1000000}
+	return &aliasMapping, s.ApplyMapping(aliasMapping)
+}
+
+// ApplyMapping brings a local collection to the state of the mapping, which is created locally or synced from a peer
+func (s *Search) ApplyMapping(documentMapping DocumentMapping) error {
+	if documentMapping.Collection == indexDefault {
+		return fmt.Errorf("the collection %s cannot be modified", indexDefault)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	name := documentMapping.Collection
+	_, isAlias := s.aliases[name]
+	var droppedAliases []DocumentMapping
+
+	switch {
+	case documentMapping.State == CollectionDropped:
+		delete(s.aliases, name)
+		if !isAlias && len(documentMapping.AliasOf) == 0 {
+			if err := s.deleteIndex(name); err != nil {
+				return err
+			}
+
+			// the aliases of the collection are dropped with it, and so on the peers which apply the tombstone
+			for alias, collection := range s.aliases {
+				if collection == name {
+					delete(s.aliases, alias)
+					droppedAliases = append(droppedAliases, DocumentMapping{Collection: alias, AliasOf: name, State: CollectionDropped, LastModified: documentMapping.LastModified})
+				}
+			}
+		}
+	case len(documentMapping.AliasOf) > 0:
+		// an alias synced after the tombstone of its collection is dropped too
+		if collection, err := s.GetDocumentMapping(documentMapping.AliasOf); err == nil && collection.State == CollectionDropped {
+			delete(s.aliases, name)
+			documentMapping.State = CollectionDropped
+			if collection.LastModified > documentMapping.LastModified {
+				documentMapping.LastModified = collection.LastModified
+			}
+		} else {
+			s.aliases[name] = documentMapping.AliasOf
+		}
+	case documentMapping.State == CollectionArchived:
+		if s.BlockchainIndices[name] == nil && s.archivedIndices[name] == nil {
+			if _, err := s.CreateMapping(documentMapping); err != nil {
+				return err
+			}
+		}
+		if index := s.BlockchainIndices[name]; index != nil {
+			s.archivedIndices[name] = index
+			delete(s.BlockchainIndices, name)
+		}
+	default:
+		if index := s.archivedIndices[name]; index != nil {
+			s.BlockchainIndices[name] = index
+			delete(s.archivedIndices, name)
+		} else if s.BlockchainIndices[name] == nil {
+			if _, err := s.CreateMapping(documentMapping); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.saveMapping(append(droppedAliases, documentMapping)...)
+}
+
+// deleteIndex closes and removes the index of a collection from disk
+func (s *Search) deleteIndex(name string) error {
+	index := s.BlockchainIndices[name]
+	if index == nil {
+		index = s.archivedIndices[name]
+	}
+
+	if index != nil {
+		if err := index.Close(); err != nil {
+			log.WithFields(log.Fields{
+				"method": "deleteIndex()",
+			}).Error(err)
+			return err
+		}
+	}
+
+	delete(s.BlockchainIndices, name)
+	delete(s.archivedIndices, name)
+
+	return os.RemoveAll(s.indexDirRoot + filepath.Dir("/") + name)
+}
+
+// saveMapping persists mappings to the collections bucket at once. GetDocumentMapping decodes them again
+func (s *Search) saveMapping(documentMappings ...DocumentMapping) error {
+	s.mappingsLock.Lock()
+	defer s.mappingsLock.Unlock()
+	for _, documentMapping := range documentMappings {
+		delete(s.mappings, documentMapping.Collection)
+	}
+
+	err := s.db.Update(func(dbtx *bolt.Tx) error {
+		collectionBucket, err := dbtx.CreateBucketIfNotExists([]byte(CollectionsBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, documentMapping := range documentMappings {
+			if err = collectionBucket.Put([]byte(documentMapping.Collection), documentMapping.Serialize()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"method": "saveMapping()",
+		}).Error(err)
+	}
+
+	return err
+}
+
+// loadCollectionStates sets up the archived collections and the aliases from the collections bucket when the node starts
+func (s *Search) loadCollectionStates() error {
+	dropped := make(map[string]bool)
+	err := s.db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(CollectionsBucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			documentMapping := DeserializeDocumentMapping(v)
+			name := string(k)
+
+			switch {
+			case documentMapping.State == CollectionDropped:
+				dropped[name] = true
+				if index := s.BlockchainIndices[name]; index != nil {
+					index.Close()
+					delete(s.BlockchainIndices, name)
+				}
+				os.RemoveAll(s.indexDirRoot + filepath.Dir("/") + name) // in case the node stopped before removing it
+			case len(documentMapping.AliasOf) > 0:
+				s.aliases[name] = documentMapping.AliasOf
+			case documentMapping.State == CollectionArchived:
+				if index := s.BlockchainIndices[name]; index != nil {
+					s.archivedIndices[name] = index
+					delete(s.BlockchainIndices, name)
+				}
+			}
+
+			return nil
+		})
+	})
+
+	// the aliases left by the drops of their collections before the aliases were dropped with them
+	for alias, collection := range s.aliases {
+		if dropped[collection] {
+			delete(s.aliases, alias)
+		}
+	}
+
+	return err
+}
+
+// collectionExists tells if a name is taken by an active or archived collection, or an alias
+func (s *Search) collectionExists(name string) bool {
+	_, isAlias := s.aliases[name]
+	return s.BlockchainIndices[name] != nil || s.archivedIndices[name] != nil || isAlias
+}
+
+func collectionStateName(state string) string {
+	if state == CollectionActive {
+		return "active"
+	}
+	return state
+}
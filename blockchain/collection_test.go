@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+// newTestSearch opens the collections of a fresh blockchain with the collection orders. The returned function removes them
+func newTestSearch(t *testing.T) (*Search, func()) {
+	bc, dir, cleanup := newTestBlockchain(t)
+
+	s, err := NewSearch(bc.Db, dir)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if _, err = s.CreateMappingByJson([]byte(`{"collection": "orders", "fields": {"a": {"type": "text"}}}`)); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		for _, index := range s.BlockchainIndices {
+			index.Close()
+		}
+		for _, index := range s.archivedIndices {
+			index.Close()
+		}
+		cleanup()
+	}
+}
+
+func TestCollectionLifecycle(t *testing.T) {
+	s, stop := newTestSearch(t)
+	defer stop()
+
+	if _, err := s.CreateAlias("current", "orders"); err != nil || s.ResolveCollection("current") != "orders" {
+		t.Fatalf("expected the alias to resolve to orders: %v", err)
+	}
+	if _, err := s.CreateAlias("current", "orders"); err == nil {
+		t.Error("expected the existing alias rejected")
+	}
+	if _, err := s.CreateAlias("previous", "current"); err == nil {
+		t.Error("expected the alias of an alias rejected")
+	}
+
+	if _, err := s.ArchiveCollection("orders"); err != nil || s.BlockchainIndices["orders"] != nil || len(s.GetArchivedCollections()) != 1 {
+		t.Fatalf("expected orders archived: %v", err)
+	}
+	if _, err := s.ArchiveCollection("orders"); err == nil {
+		t.Error("expected the archived collection not archived again")
+	}
+	if _, err := s.ArchiveCollection("current"); err == nil {
+		t.Error("expected the alias not archived")
+	}
+	if _, err := s.RestoreCollection("orders"); err != nil || s.BlockchainIndices["orders"] == nil || len(s.GetArchivedCollections()) != 0 {
+		t.Fatalf("expected orders restored: %v", err)
+	}
+
+	tombstone, err := s.DropCollection("orders")
+	if err != nil || tombstone.State != CollectionDropped || s.BlockchainIndices["orders"] != nil {
+		t.Fatalf("expected orders dropped: %+v %v", tombstone, err)
+	}
+	if aliasMapping, _ := s.GetDocumentMapping("current"); len(s.GetAliases()) != 0 || s.ResolveCollection("current") != "current" ||
+		aliasMapping.State != CollectionDropped || aliasMapping.LastModified != tombstone.LastModified {
+		t.Errorf("expected the alias dropped with its collection, actual: %v %+v", s.GetAliases(), aliasMapping)
+	}
+	if _, err = s.DropCollection("orders"); err == nil {
+		t.Error("expected the dropped collection not dropped again")
+	}
+	if _, err = s.CreateAlias("previous", "orders"); err == nil {
+		t.Error("expected the alias of a dropped collection rejected")
+	}
+
+	// a dropped collection and its aliases can be created again
+	if _, err = s.CreateMappingByJson([]byte(`{"collection": "orders", "fields": {"a": {"type": "text"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CreateAlias("current", "orders"); err != nil || s.ResolveCollection("current") != "orders" {
+		t.Errorf("expected the alias created again: %v", err)
+	}
+}
+
+func TestApplyMappingTombstones(t *testing.T) {
+	s, stop := newTestSearch(t)
+	defer stop()
+
+	// the mappings synced from a peer
+	if err := s.ApplyMapping(DocumentMapping{Collection: "current", AliasOf: "orders", LastModified: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ApplyMapping(DocumentMapping{Collection: "orders", State: CollectionDropped, LastModified: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if aliasMapping, _ := s.GetDocumentMapping("current"); s.ResolveCollection("current") != "current" || aliasMapping.State != CollectionDropped || aliasMapping.LastModified != 200 {
+		t.Errorf("expected the alias dropped with the tombstone of its collection, actual: %+v", aliasMapping)
+	}
+
+	// an alias synced after the tombstone of its collection
+	if err := s.ApplyMapping(DocumentMapping{Collection: "late", AliasOf: "orders", LastModified: 150}); err != nil {
+		t.Fatal(err)
+	}
+	if aliasMapping, _ := s.GetDocumentMapping("late"); s.ResolveCollection("late") != "late" || aliasMapping.State != CollectionDropped || aliasMapping.LastModified != 200 {
+		t.Errorf("expected the alias synced late dropped like its collection, actual: %+v", aliasMapping)
+	}
+
+	// the tombstone of an alias doesn't drop the collection of the same name
+	if _, err := s.CreateMappingByJson([]byte(`{"collection": "orders", "fields": {"a": {"type": "text"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ApplyMapping(DocumentMapping{Collection: "late", AliasOf: "orders", State: CollectionDropped, LastModified: 300}); err != nil || s.BlockchainIndices["orders"] == nil {
+		t.Errorf("expected orders kept: %v", err)
+	}
+
+	// an alias left by a drop before the aliases were dropped with their collections
+	if err := s.saveMapping(DocumentMapping{Collection: "legacy", AliasOf: "gone", LastModified: 100}, DocumentMapping{Collection: "gone", State: CollectionDropped, LastModified: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.loadCollectionStates(); err != nil {
+		t.Fatal(err)
+	}
+	if s.ResolveCollection("legacy") != "legacy" {
+		t.Errorf("expected the alias of the dropped collection not loaded, actual: %v", s.GetAliases())
+	}
+}
@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The CSV columns carrying the signed envelope of a record instead of a document field
+const (
+	CSVSignatureColumn          = "_signature"
+	CSVSignatureSchemeColumn    = "_signatureScheme"
+	CSVNonceColumn              = "_nonce"
+	CSVExpiresAtColumn          = "_expiresAt"
+	CSVPermittedAddressesColumn = "_permittedAddresses" // separated by spaces
+)
+
+// IsCSVEnvelopeColumn tells if a CSV column carries the signed envelope of a record
+func IsCSVEnvelopeColumn(column string) bool {
+	return column == CSVSignatureColumn || column == CSVSignatureSchemeColumn || column == CSVNonceColumn || column == CSVExpiresAtColumn || column == CSVPermittedAddressesColumn
+}
+
+// CSVRecordToDocument maps a CSV record to a JSON document by the header, converting each value by the type of its field in the mapping.
+// Empty values are left out; a geopoint is "<lat>,<lon>"; a column not in the mapping is text. The signature of the record covers the canonical form (RFC 8785) of the document
+func (dm DocumentMapping) CSVRecordToDocument(header []string, record []string) ([]byte, error) {
+	if len(record) != len(header) {
+		return nil, fmt.Errorf("the record has %d columns while the header has %d", len(record), len(header))
+	}
+
+	document := make(map[string]interface{})
+	for i, column := range header {
+		if IsCSVEnvelopeColumn(column) || record[i] == "" {
+			continue
+		}
+
+		fieldType := ""
+		if fieldMapping, ok := dm.Fields[column].(map[string]interface{}); ok {
+			fieldType, _ = fieldMapping["type"].(string)
+		}
+
+		value, err := csvValue(fieldType, record[i])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %s", column, err)
+		}
+		document[column] = value
+	}
+
+	rawDocument, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return Canonicalize(rawDocument)
+}
+
+func csvValue(fieldType string, value string) (interface{}, error) {
+	switch fieldType {
+	case "number":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s as a number", value)
+		}
+		return number, nil
+	case "boolean":
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s as a boolean", value)
+		}
+		return boolean, nil
+	case "geopoint":
+		latLon := strings.Split(value, ",")
+		if len(latLon) != 2 {
+			return nil, fmt.Errorf("cannot parse %s as a geopoint of <lat>,<lon>", value)
+		}
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(latLon[0]), 64)
+		lon, errLon := strconv.ParseFloat(strings.TrimSpace(latLon[1]), 64)
+		if errLat != nil || errLon != nil {
+			return nil, fmt.Errorf("cannot parse %s as a geopoint of <lat>,<lon>", value)
+		}
+		return map[string]interface{}{"lat": lat, "lon": lon}, nil
+	default: // text, datetime and the columns not in the mapping
+		return value, nil
+	}
+}
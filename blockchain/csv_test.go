@@ -0,0 +1,46 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+func TestCSVRecordToDocument(t *testing.T) {
+	dm := DocumentMapping{Collection: "c", Fields: map[string]interface{}{
+		"name":     map[string]interface{}{"type": "text"},
+		"age":      map[string]interface{}{"type": "number"},
+		"active":   map[string]interface{}{"type": "boolean"},
+		"location": map[string]interface{}{"type": "geopoint"},
+	}}
+	header := []string{"name", "age", "active", "location", "note", CSVSignatureColumn, CSVNonceColumn}
+
+	rawDocument, err := dm.CSVRecordToDocument(header, []string{"Ivan", "42", "true", "45.5, -73.6", "", "8e0063b7", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"active":true,"age":42,"location":{"lat":45.5,"lon":-73.6},"name":"Ivan"}`
+	if string(rawDocument) != expected {
+		t.Errorf("expected: %s, actual: %s", expected, rawDocument)
+	}
+	if fieldErrors, err := dm.Validate(rawDocument); err != nil || fieldErrors != nil {
+		t.Errorf("expected the document valid, actual: %v %v", fieldErrors, err)
+	}
+
+	rawDocument, err = dm.CSVRecordToDocument(header, []string{"<Ivan & Co>", "1e3", "", "", "a\tb", "", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"age":1000,"name":"<Ivan & Co>","note":"a\tb"}`
+	if string(rawDocument) != expected {
+		t.Errorf("expected the canonical form: %s, actual: %s", expected, rawDocument)
+	}
+
+	if _, err = dm.CSVRecordToDocument(header, []string{"Ivan", "forty-two", "true", "", "", "", ""}); err == nil {
+		t.Error("expected the number not parsed")
+	}
+	if _, err = dm.CSVRecordToDocument(header, []string{"Ivan", "42", "true", "45.5", "", "", ""}); err == nil {
+		t.Error("expected the geopoint not parsed")
+	}
+	if _, err = dm.CSVRecordToDocument(header, []string{"Ivan"}); err == nil {
+		t.Error("expected the record with missing columns rejected")
+	}
+}
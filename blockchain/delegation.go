@@ -0,0 +1,242 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
+)
+
+// Delegation authorizes a delegate, such as the account of a backend service, to sign documents on behalf of the principal in the collections
+// from NotBefore until NotAfter. The principal signs the delegation with its key, so that the peers verify the documents of the delegate offline
+type Delegation struct {
+	Principal   string
+	Delegate    string
+	Collections []string
+	NotBefore   int64 // unix time in milliseconds
+	NotAfter    int64 // unix time in milliseconds
+	Signature   []byte
+
+	LastModified        int64  // the latest creation or revocation wins when the peers sync the delegations. NotBefore of a delegation, the signed time of a revocation
+	Revoked             bool   // kept so that the revocation reaches the peers
+	RevocationSignature []byte // of RevocationSigningMessage by the principal
+}
+
+// DelegationError tells why a document signed by a delegate is rejected
+type DelegationError struct {
+	Reason string
+}
+
+func (e *DelegationError) Error() string {
+	return e.Reason
+}
+
+// SigningMessage is the message the principal signs for the delegation. The addresses are lowercased and the collections sorted:
+//
+// blocace-delegation
+// principal:<address>
+// delegate:<address>
+// collections:<collection>,<collection>
+// notBefore:<notBefore>
+// notAfter:<notAfter>
+func (d Delegation) SigningMessage() []byte {
+	collections := append([]string{}, d.Collections...)
+	sort.Strings(collections)
+
+	return []byte(fmt.Sprintf("blocace-delegation\nprincipal:%s\ndelegate:%s\ncollections:%s\nnotBefore:%d\nnotAfter:%d\n", strings.ToLower(d.Principal), strings.ToLower(d.Delegate), strings.Join(collections, ","), d.NotBefore, d.NotAfter))
+}
+
+// RevocationSigningMessage is the message the principal signs to revoke the delegation at revokedAt, which must be after the last modification of the delegation:
+//
+// blocace-revocation
+// principal:<address>
+// delegate:<address>
+// revokedAt:<revokedAt>
+func (d Delegation) RevocationSigningMessage(revokedAt int64) []byte {
+	return []byte(fmt.Sprintf("blocace-revocation\nprincipal:%s\ndelegate:%s\nrevokedAt:%d\n", strings.ToLower(d.Principal), strings.ToLower(d.Delegate), revokedAt))
+}
+
+// Permits tells if the delegate may sign a document in the collection at the timestamp
+func (d Delegation) Permits(collection string, timestamp int64) bool {
+	return funk.ContainsString(d.Collections, collection) && d.NotBefore <= timestamp && timestamp < d.NotAfter
+}
+
+// Serialize serializes the delegation
+func (d Delegation) Serialize() []byte {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+	err := encoder.Encode(d)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return result.Bytes()
+}
+
+// DeserializeDelegation deserializes a delegation
+func DeserializeDelegation(d []byte) *Delegation {
+	var delegation Delegation
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&delegation)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return &delegation
+}
+
+// CheckDelegation rejects a delegation which doesn't let its delegate sign for the principal in the collection at the timestamp,
+// or which is not signed by the key of the principal at the timestamp. Rotating the key of the principal ends its delegations
+func (bc *Blockchain) CheckDelegation(d *Delegation, principal string, collection string, timestamp int64) error {
+	if !strings.EqualFold(d.Principal, principal) {
+		return &DelegationError{Reason: fmt.Sprintf("the delegation is not of %s", principal)}
+	} else if !d.Permits(collection, timestamp) {
+		return &DelegationError{Reason: fmt.Sprintf("the delegation doesn't permit collection %s at this time", collection)}
+	}
+
+	key, ok := bc.accountKeyAt(d.Principal, timestamp)
+	if !ok {
+		return &DelegationError{Reason: fmt.Sprintf("no key of %s at this time", d.Principal)}
+	}
+	publicKey, err := hex.DecodeString(key.PublicKey)
+	if err != nil || !IsValidSig(key.KeyType, d.SigningMessage(), publicKey, d.Signature) {
+		return &DelegationError{Reason: "the delegation is not signed by the principal"}
+	}
+
+	return nil
+}
+
+// CheckDelegationSignature rejects a delegation or revocation which is not signed by the key of the principal at its last modification, so that
+// no peer can create, revoke or reorder the delegations of another account
+func (bc *Blockchain) CheckDelegationSignature(d Delegation) error {
+	key, ok := bc.accountKeyAt(d.Principal, d.LastModified)
+	if !ok {
+		return &DelegationError{Reason: fmt.Sprintf("no key of %s at %d", d.Principal, d.LastModified)}
+	}
+	publicKey, err := hex.DecodeString(key.PublicKey)
+	if err != nil {
+		return &DelegationError{Reason: fmt.Sprintf("couldn't recognize the publicKey of %s", d.Principal)}
+	}
+
+	if d.Revoked {
+		if !IsValidSig(key.KeyType, d.RevocationSigningMessage(d.LastModified), publicKey, d.RevocationSignature) {
+			return &DelegationError{Reason: "the revocation is not signed by the principal"}
+		}
+	} else if d.LastModified != d.NotBefore || !IsValidSig(key.KeyType, d.SigningMessage(), publicKey, d.Signature) {
+		return &DelegationError{Reason: "the delegation is not signed by the principal"}
+	}
+
+	return nil
+}
+
+// PutDelegation saves a delegation, which replaces the one of the same principal and delegate
+func (bc *Blockchain) PutDelegation(d Delegation) error {
+	return bc.Db.Update(func(dbtx *bolt.Tx) error {
+		b, err := dbtx.CreateBucketIfNotExists([]byte(DelegationsBucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put(delegationKey(d.Principal, d.Delegate), d.Serialize())
+	})
+}
+
+// MergeDelegation saves a delegation or revocation from a peer if it's signed by the principal and newer than the local one. Returns true if it's saved
+func (bc *Blockchain) MergeDelegation(d Delegation) (bool, error) {
+	if err := bc.CheckDelegationSignature(d); err != nil {
+		return false, err
+	}
+
+	isNewer := false
+	err := bc.Db.Update(func(dbtx *bolt.Tx) error {
+		b, err := dbtx.CreateBucketIfNotExists([]byte(DelegationsBucket))
+		if err != nil {
+			return err
+		}
+
+		if encodedDelegation := b.Get(delegationKey(d.Principal, d.Delegate)); encodedDelegation != nil && DeserializeDelegation(encodedDelegation).LastModified >= d.LastModified {
+			return nil
+		}
+
+		isNewer = true
+		return b.Put(delegationKey(d.Principal, d.Delegate), d.Serialize())
+	})
+
+	return isNewer, err
+}
+
+// GetDelegation returns the delegation of the principal to the delegate, or nil if there is none or it's revoked
+func (bc *Blockchain) GetDelegation(principal string, delegate string) *Delegation {
+	var delegation *Delegation
+	bc.Db.View(func(dbtx *bolt.Tx) error {
+		if b := dbtx.Bucket([]byte(DelegationsBucket)); b != nil {
+			if encodedDelegation := b.Get(delegationKey(principal, delegate)); encodedDelegation != nil {
+				delegation = DeserializeDelegation(encodedDelegation)
+			}
+		}
+		return nil
+	})
+
+	if delegation != nil && delegation.Revoked {
+		return nil
+	}
+
+	return delegation
+}
+
+// GetDelegations returns all the delegations and revocations by key, see Delegation.Key
+func (bc *Blockchain) GetDelegations() map[string]Delegation {
+	delegations := make(map[string]Delegation)
+	bc.Db.View(func(dbtx *bolt.Tx) error {
+		if b := dbtx.Bucket([]byte(DelegationsBucket)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				delegations[fmt.Sprintf("%x", k)] = *DeserializeDelegation(v)
+				return nil
+			})
+		}
+		return nil
+	})
+
+	return delegations
+}
+
+// RevokeDelegation marks the delegation of the principal to the delegate revoked at revokedAt with the signature of the principal and returns
+// the revocation to send to the peers. The documents the delegate has signed stay valid
+func (bc *Blockchain) RevokeDelegation(principal string, delegate string, revokedAt int64, signature []byte) (*Delegation, error) {
+	revocation := bc.GetDelegation(principal, delegate)
+	if revocation == nil {
+		return nil, fmt.Errorf("no delegation of %s to %s", principal, delegate)
+	} else if revokedAt <= revocation.LastModified {
+		return nil, &DelegationError{Reason: fmt.Sprintf("revokedAt must be after the delegation at %d", revocation.LastModified)}
+	}
+
+	revocation.Revoked = true
+	revocation.LastModified = revokedAt
+	revocation.RevocationSignature = signature
+	if err := bc.CheckDelegationSignature(*revocation); err != nil {
+		return nil, err
+	}
+
+	if err := bc.PutDelegation(*revocation); err != nil {
+		return nil, err
+	}
+
+	return revocation, nil
+}
+
+// Key identifies the delegation of the principal to the delegate across the peers
+func (d Delegation) Key() string {
+	return fmt.Sprintf("%x", delegationKey(d.Principal, d.Delegate))
+}
+
+func delegationKey(principal string, delegate string) []byte {
+	return append(common.HexToAddress(principal).Bytes(), common.HexToAddress(delegate).Bytes()...)
+}
@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDelegation(t *testing.T) {
+	bc, _, cleanup := newTestBlockchain(t)
+	defer cleanup()
+	var err error
+
+	principalKey, _ := crypto.GenerateKey()
+	delegateKey, _ := crypto.GenerateKey()
+	principal, delegate := crypto.PubkeyToAddress(principalKey.PublicKey).String(), crypto.PubkeyToAddress(delegateKey.PublicKey).String()
+	principalAccount := Account{PublicKey: hex.EncodeToString(crypto.FromECDSAPub(&principalKey.PublicKey)), Role: Role{Name: "user"}}
+	if err = bc.RegisterAccount([]byte(principal), principalAccount); err != nil {
+		t.Fatal(err)
+	}
+
+	delegation := Delegation{Principal: principal, Delegate: delegate, Collections: []string{"orders", "default"}, NotBefore: 1000, NotAfter: 2000}
+	delegation.Signature = Sign(*principalKey, crypto.Keccak256(delegation.SigningMessage()))
+	if err = bc.CheckDelegation(&delegation, principal, "default", 1500); err != nil {
+		t.Errorf("the delegation should be valid: %s", err)
+	}
+	if err = bc.CheckDelegation(&delegation, principal, "payments", 1500); err == nil {
+		t.Error("the delegation should not permit another collection")
+	}
+	if err = bc.CheckDelegation(&delegation, principal, "default", 2000); err == nil {
+		t.Error("the delegation should expire at notAfter")
+	}
+	if err = bc.CheckDelegation(&delegation, delegate, "default", 1500); err == nil {
+		t.Error("the delegation should not be of another principal")
+	}
+
+	forged := delegation
+	forged.Signature = Sign(*delegateKey, crypto.Keccak256(forged.SigningMessage()))
+	if err = bc.CheckDelegation(&forged, principal, "default", 1500); err == nil {
+		t.Error("a delegation signed by the delegate should be rejected")
+	}
+
+	// the delegate signs a document for the principal
+	signedTx := func(privateKeyIndex int, acceptedTimestamp int64) *Transaction {
+		privateKey := delegateKey
+		if privateKeyIndex == 1 {
+			privateKey = principalKey
+		}
+		tx := NewTransaction([]byte("peer"), []byte(`{"id":1}`), "default", crypto.FromECDSAPub(&privateKey.PublicKey), nil, []string{delegate, principal})
+		tx.Nonce = 1
+		tx.Address = principal
+		tx.Delegation = &delegation
+		tx.AcceptedTimestamp = acceptedTimestamp
+		digest, _ := TransactionDigest(tx, principal)
+		tx.Signature = Sign(*privateKey, digest)
+		return tx
+	}
+
+	if tx := signedTx(0, 1500); !IsValidTransactionSig(tx) || !bc.IsAuthorKey(tx) {
+		t.Error("a document signed by the delegate should be valid")
+	}
+	if tx := signedTx(0, 2500); bc.IsAuthorKey(tx) {
+		t.Error("a document signed by the delegate after the delegation expired should be rejected")
+	}
+	if tx := signedTx(1, 1500); bc.IsAuthorKey(tx) {
+		t.Error("a delegated document should be signed by the key of the delegate")
+	}
+	if address, _ := TransactionAddress(signedTx(0, 1500)); address != principal {
+		t.Errorf("the author of a delegated document should be the principal: %s", address)
+	}
+
+	// rotating the key of the principal ends the delegations signed by the old key
+	newKey, _ := crypto.GenerateKey()
+	principalAccount.RotateKey(KeyTypeSecp256k1, hex.EncodeToString(crypto.FromECDSAPub(&newKey.PublicKey)), 1800)
+	bc.RegisterAccount([]byte(principal), principalAccount)
+	if !bc.IsAuthorKey(signedTx(0, 1500)) || bc.IsAuthorKey(signedTx(0, 1900)) {
+		t.Error("the delegation should only be valid while the key which signed it is effective")
+	}
+
+	if err = bc.PutDelegation(delegation); err != nil {
+		t.Fatal(err)
+	}
+	if stored := bc.GetDelegation(principal, delegate); stored == nil || stored.NotAfter != delegation.NotAfter || len(stored.Collections) != 2 {
+		t.Errorf("the delegation should be stored: %+v", stored)
+	}
+	if _, err = bc.RevokeDelegation(principal, delegate, 1700, Sign(*delegateKey, crypto.Keccak256(delegation.RevocationSigningMessage(1700)))); err == nil {
+		t.Error("a revocation not signed by the principal should be rejected")
+	}
+	revocation, err := bc.RevokeDelegation(principal, delegate, 1700, Sign(*principalKey, crypto.Keccak256(delegation.RevocationSigningMessage(1700))))
+	if err != nil || bc.GetDelegation(principal, delegate) != nil || !revocation.Revoked || revocation.LastModified != 1700 {
+		t.Errorf("the delegation should be revoked: %v", err)
+	}
+	if _, err = bc.RevokeDelegation(principal, delegate, 1750, Sign(*principalKey, crypto.Keccak256(delegation.RevocationSigningMessage(1750)))); err == nil {
+		t.Error("revoking a missing delegation should fail")
+	}
+
+	// the peers keep the latest delegation or revocation signed by the principal
+	delegation.LastModified = delegation.NotBefore
+	if isNewer, _ := bc.MergeDelegation(delegation); isNewer || bc.GetDelegation(principal, delegate) != nil {
+		t.Error("an older delegation from a peer should not undo the revocation")
+	}
+	postdated := delegation
+	postdated.LastModified = 1 << 62
+	if _, err = bc.MergeDelegation(postdated); err == nil || bc.GetDelegation(principal, delegate) != nil {
+		t.Error("a delegation with an unsigned last modification should be rejected")
+	}
+	renewed := Delegation{Principal: principal, Delegate: delegate, Collections: []string{"default"}, NotBefore: 1850, NotAfter: 3000, LastModified: 1850}
+	renewed.Signature = Sign(*newKey, crypto.Keccak256(renewed.SigningMessage()))
+	if isNewer, err := bc.MergeDelegation(renewed); !isNewer || err != nil || bc.GetDelegation(principal, delegate) == nil {
+		t.Errorf("a newer delegation from a peer should replace the revocation: %v", err)
+	}
+	if delegations := bc.GetDelegations(); len(delegations) != 1 || delegations[renewed.Key()].LastModified != renewed.LastModified {
+		t.Errorf("the delegations should be listed by key: %+v", delegations)
+	}
+
+	forgedRevocation := renewed
+	forgedRevocation.Revoked = true
+	forgedRevocation.LastModified = 1900
+	forgedRevocation.RevocationSignature = Sign(*delegateKey, crypto.Keccak256(renewed.RevocationSigningMessage(1900)))
+	if _, err = bc.MergeDelegation(forgedRevocation); err == nil || bc.GetDelegation(principal, delegate) == nil {
+		t.Error("a revocation from a peer not signed by the principal should be rejected")
+	}
+}
@@ -0,0 +1,286 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MaxEnvelopeLifetime is how far in the future a signed document may expire
+const MaxEnvelopeLifetime = time.Hour
+
+// Envelope is what the signature of a document commits to besides the document itself, so that the signed document cannot be replayed.
+// Either the nonce or the expiry binds the signature: the nonce must be greater than the last one of the account and the expiry in the future
+type Envelope struct {
+	Collection         string
+	Nonce              uint64
+	ExpiresAt          int64 // unix time in milliseconds
+	PermittedAddresses []string
+}
+
+// EnvelopeError tells why a signed document is rejected as a replay
+type EnvelopeError struct {
+	Reason   string
+	IsReplay bool // the signed document is already in a blockchain, as opposed to expired or malformed
+}
+
+func (e *EnvelopeError) Error() string {
+	return e.Reason
+}
+
+// EnvelopeOf returns the envelope of a transaction
+func EnvelopeOf(tx *Transaction) Envelope {
+	return Envelope{Collection: tx.Collection, Nonce: tx.Nonce, ExpiresAt: tx.ExpiresAt, PermittedAddresses: tx.PermittedAddresses}
+}
+
+// IsBound tells if the envelope has a nonce or an expiry. The signature of an unbound envelope covers the document only
+func (e Envelope) IsBound() bool {
+	return e.Nonce > 0 || e.ExpiresAt > 0
+}
+
+// SigningMessage is the message to sign for a document in the envelope. The permitted addresses are lowercased, sorted and include the author:
+//
+// blocace-document
+// collection:<collection>
+// nonce:<nonce>
+// expiresAt:<expiresAt>
+// permittedAddresses:<address>,<address>
+// <rawDocument>
+func (e Envelope) SigningMessage(rawData []byte, authorAddress string) []byte {
+	header := fmt.Sprintf("blocace-document\ncollection:%s\nnonce:%d\nexpiresAt:%d\npermittedAddresses:%s\n", e.Collection, e.Nonce, e.ExpiresAt, e.normalizedAddresses(authorAddress))
+	return append([]byte(header), rawData...)
+}
+
+// BatchSigningMessage is the message to sign for a batch of documents in the envelope. The merkle root is of the keccak256 hashes of the documents, see BatchLeaf:
+//
+// blocace-batch
+// collection:<collection>
+// nonce:<nonce>
+// expiresAt:<expiresAt>
+// permittedAddresses:<address>,<address>
+// merkleRoot:<merkleRoot in hex>
+func (e Envelope) BatchSigningMessage(merkleRoot []byte, authorAddress string) []byte {
+	return []byte(fmt.Sprintf("blocace-batch\ncollection:%s\nnonce:%d\nexpiresAt:%d\npermittedAddresses:%s\nmerkleRoot:%x\n", e.Collection, e.Nonce, e.ExpiresAt, e.normalizedAddresses(authorAddress), merkleRoot))
+}
+
+// BatchLeaf is the merkle tree leaf of a document in a signed batch
+func BatchLeaf(rawData []byte) []byte {
+	return crypto.Keccak256(rawData)
+}
+
+func (e Envelope) normalizedAddresses(authorAddress string) string {
+	addressSet := map[string]bool{strings.ToLower(authorAddress): true}
+	for _, address := range e.PermittedAddresses {
+		addressSet[strings.ToLower(address)] = true
+	}
+	var addresses []string
+	for address := range addressSet {
+		if address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	return strings.Join(addresses, ",")
+}
+
+func (e Envelope) checkExpiry(acceptedTimestamp int64) error {
+	if e.ExpiresAt > 0 && acceptedTimestamp >= e.ExpiresAt {
+		return &EnvelopeError{Reason: "the signature has expired"}
+	} else if e.ExpiresAt > acceptedTimestamp+int64(MaxEnvelopeLifetime/time.Millisecond) {
+		return &EnvelopeError{Reason: fmt.Sprintf("the signature may not expire later than %s", MaxEnvelopeLifetime)}
+	}
+
+	return nil
+}
+
+// envelopeDigest identifies the signed envelope of a transaction. A document of a batch is identified by the batch and the document
+func envelopeDigest(tx *Transaction, authorAddress string) []byte {
+	envelope := EnvelopeOf(tx)
+	if tx.BatchRoot != nil {
+		return crypto.Keccak256(envelope.BatchSigningMessage(tx.BatchRoot, authorAddress), BatchLeaf(tx.RawData))
+	}
+
+	return crypto.Keccak256(envelope.SigningMessage(tx.RawData, authorAddress))
+}
+
+// TransactionDigest returns the digest the signature of a transaction signs in its signature scheme: the keccak256 of the document if the envelope is unbound
+// and the scheme is keccak256, otherwise the digest of the envelope. The signature of a document in a batch is over the merkle root of the batch, which the document
+// must lead to through its batch path. The author address may be empty if it is in the permitted addresses of the transaction
+func TransactionDigest(tx *Transaction, authorAddress string) ([]byte, error) {
+	envelope := EnvelopeOf(tx)
+	if !envelope.IsBound() && tx.BatchRoot == nil && tx.SignatureScheme == SignatureSchemeKeccak256 {
+		return crypto.Keccak256(tx.RawData), nil
+	}
+
+	if tx.BatchRoot != nil {
+		if !bytes.Equal(tx.BatchPath[0], tx.BatchRoot) || !VerifyVerificationPath(BatchLeaf(tx.RawData), tx.BatchPath) {
+			return nil, fmt.Errorf("the document is not in the batch")
+		}
+		return envelope.BatchDigest(tx.SignatureScheme, tx.BatchRoot, authorAddress)
+	}
+
+	return envelope.DocumentDigest(tx.SignatureScheme, tx.RawData, authorAddress)
+}
+
+// IsValidTransactionSig verifies the signature of a transaction with its public key over TransactionDigest, by the key type of the transaction.
+// Whether the key was the one of the author at the time is up to Blockchain.IsAuthorKey
+func IsValidTransactionSig(tx *Transaction) bool {
+	authorAddress, err := TransactionAddress(tx)
+	if err != nil || len(tx.PubKey) == 0 {
+		return false
+	}
+
+	digest, err := TransactionDigest(tx, authorAddress)
+	return err == nil && IsValidKeySig(tx.KeyType, digest, tx.PubKey, tx.Signature)
+}
+
+// TransactionAddress returns the address of the author of a transaction as recorded, or from its public key, or from the public key recovered from its signature.
+// No account is needed to tell who signed a transaction. The address of an unsigned transaction such as the genesis one is empty, whatever address it records
+func TransactionAddress(tx *Transaction) (string, error) {
+	if len(tx.Signature) == 0 {
+		return "", nil
+	} else if tx.Address != "" {
+		return tx.Address, nil
+	} else if len(tx.PubKey) > 0 {
+		return KeyAddress(tx.KeyType, tx.PubKey)
+	} else if tx.KeyType != KeyTypeSecp256k1 {
+		return "", nil
+	}
+
+	digest, err := TransactionDigest(tx, "")
+	if err != nil {
+		return "", err
+	}
+	pubKey, err := RecoverPubKey(digest, tx.Signature)
+	if err != nil {
+		return "", err
+	}
+
+	return PublicKeyToAddress(pubKey)
+}
+
+// CheckEnvelope rejects a transaction if its signed envelope was used by another transaction. A transaction accepted locally must also expire in the future
+// and have a nonce greater than the last one of the author; a transaction from a peer must have been accepted before it expired
+func (bc *Blockchain) CheckEnvelope(tx *Transaction, isLocal bool) error {
+	envelope := EnvelopeOf(tx)
+	if !envelope.IsBound() {
+		return nil
+	}
+
+	acceptedTimestamp := tx.AcceptedTimestamp
+	if isLocal {
+		acceptedTimestamp = time.Now().UnixNano() / 1000000
+	}
+	if err := envelope.checkExpiry(acceptedTimestamp); err != nil {
+		return err
+	}
+
+	authorAddress, err := TransactionAddress(tx)
+	if err != nil {
+		return &EnvelopeError{Reason: "invalid public key"}
+	}
+	digest := envelopeDigest(tx, authorAddress)
+
+	return bc.Db.View(func(dbtx *bolt.Tx) error {
+		if b := dbtx.Bucket([]byte(EnvelopesBucket)); b != nil {
+			if usedBy := b.Get(digest); usedBy != nil && !bytes.Equal(usedBy, tx.ID) {
+				return &EnvelopeError{Reason: fmt.Sprintf("the signed document is a replay of transaction %x", usedBy), IsReplay: true}
+			}
+		}
+
+		// the nonce of a batch is checked once for all its documents by CheckBatchEnvelope
+		if isLocal && tx.BatchRoot == nil {
+			return checkNonce(dbtx, envelope, authorAddress)
+		}
+
+		return nil
+	})
+}
+
+// CheckBatchEnvelope rejects a batch to accept locally if it has expired or its nonce is not greater than the last one of the author
+func (bc *Blockchain) CheckBatchEnvelope(envelope Envelope, authorAddress string) error {
+	if !envelope.IsBound() {
+		return nil
+	}
+
+	if err := envelope.checkExpiry(time.Now().UnixNano() / 1000000); err != nil {
+		return err
+	}
+
+	return bc.Db.View(func(dbtx *bolt.Tx) error {
+		return checkNonce(dbtx, envelope, authorAddress)
+	})
+}
+
+func checkNonce(dbtx *bolt.Tx, envelope Envelope, authorAddress string) error {
+	if b := dbtx.Bucket([]byte(NoncesBucket)); b != nil && envelope.Nonce > 0 {
+		if lastNonce := b.Get([]byte(authorAddress)); lastNonce != nil && envelope.Nonce <= binary.BigEndian.Uint64(lastNonce) {
+			return &EnvelopeError{Reason: fmt.Sprintf("the nonce must be greater than %d", binary.BigEndian.Uint64(lastNonce))}
+		}
+	}
+
+	return nil
+}
+
+// RecordEnvelope marks the signed envelope of a transaction as used and moves the last nonce of the author forward
+func (bc *Blockchain) RecordEnvelope(tx *Transaction) error {
+	envelope := EnvelopeOf(tx)
+	if !envelope.IsBound() {
+		return nil
+	}
+
+	authorAddress, err := TransactionAddress(tx)
+	if err != nil {
+		return err
+	}
+	digest := envelopeDigest(tx, authorAddress)
+
+	return bc.Db.Update(func(dbtx *bolt.Tx) error {
+		envelopesBucket, err := dbtx.CreateBucketIfNotExists([]byte(EnvelopesBucket))
+		if err != nil {
+			return err
+		}
+		if err = envelopesBucket.Put(digest, tx.ID); err != nil {
+			return err
+		}
+
+		if envelope.Nonce == 0 {
+			return nil
+		}
+
+		noncesBucket, err := dbtx.CreateBucketIfNotExists([]byte(NoncesBucket))
+		if err != nil {
+			return err
+		}
+		if lastNonce := noncesBucket.Get([]byte(authorAddress)); lastNonce != nil && envelope.Nonce <= binary.BigEndian.Uint64(lastNonce) {
+			return nil
+		}
+
+		nonce := make([]byte, 8)
+		binary.BigEndian.PutUint64(nonce, envelope.Nonce)
+		return noncesBucket.Put([]byte(authorAddress), nonce)
+	})
+}
+
+// GetNonce returns the last nonce used by an account, 0 if none
+func (bc *Blockchain) GetNonce(address string) uint64 {
+	var nonce uint64
+	bc.Db.View(func(dbtx *bolt.Tx) error {
+		if b := dbtx.Bucket([]byte(NoncesBucket)); b != nil {
+			if lastNonce := b.Get([]byte(address)); lastNonce != nil {
+				nonce = binary.BigEndian.Uint64(lastNonce)
+			}
+		}
+
+		return nil
+	})
+
+	return nonce
+}
@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEnvelope(t *testing.T) {
+	bc, _, cleanup := newTestBlockchain(t)
+	defer cleanup()
+	var err error
+
+	privateKey, _ := crypto.GenerateKey()
+	publicKey := crypto.FromECDSAPub(&privateKey.PublicKey)
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).String()
+	rawData := []byte(`{"id": 1}`)
+
+	signedTx := func(envelope Envelope, authorIncluded bool) *Transaction {
+		signedEnvelope := envelope
+		if authorIncluded {
+			signedEnvelope.PermittedAddresses = append([]string{address}, envelope.PermittedAddresses...)
+		}
+		signature := Sign(*privateKey, crypto.Keccak256(envelope.SigningMessage(rawData, address)))
+		tx := NewTransaction([]byte("peer"), rawData, envelope.Collection, publicKey, signature, signedEnvelope.PermittedAddresses)
+		tx.Nonce = envelope.Nonce
+		tx.ExpiresAt = envelope.ExpiresAt
+		return tx
+	}
+
+	tx := signedTx(Envelope{Collection: "c", Nonce: 1, PermittedAddresses: []string{"0x07322C5A59047c09e87C284503F64f7FdDD17aBd"}}, true)
+	if !IsValidTransactionSig(tx) {
+		t.Error("expected the signature valid with the author permitted")
+	}
+
+	tampered := *tx
+	tampered.Collection = "other"
+	if IsValidTransactionSig(&tampered) {
+		t.Error("expected the signature invalid in another collection")
+	}
+	tampered = *tx
+	tampered.PermittedAddresses = append(tampered.PermittedAddresses, "0x931D387731bBbC988B312206c74F77D004D6B84b")
+	if IsValidTransactionSig(&tampered) {
+		t.Error("expected the signature invalid with another permitted address")
+	}
+	tampered = *tx
+	tampered.Nonce = 2
+	if IsValidTransactionSig(&tampered) {
+		t.Error("expected the signature invalid with another nonce")
+	}
+
+	if err = bc.CheckEnvelope(tx, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.RecordEnvelope(tx); err != nil {
+		t.Fatal(err)
+	}
+	if bc.GetNonce(address) != 1 {
+		t.Errorf("expected the nonce 1, actual: %d", bc.GetNonce(address))
+	}
+
+	replay := *tx
+	replay.SetID()
+	if err = bc.CheckEnvelope(&replay, true); err == nil {
+		t.Error("expected the replay rejected")
+	}
+	if err = bc.CheckEnvelope(&replay, false); err == nil {
+		t.Error("expected the replay in a peer block rejected")
+	}
+	if err = bc.CheckEnvelope(tx, false); err != nil {
+		t.Errorf("expected the same transaction from a peer accepted, actual: %s", err)
+	}
+
+	if err = bc.CheckEnvelope(signedTx(Envelope{Collection: "c", Nonce: 1, PermittedAddresses: []string{"0x931D387731bBbC988B312206c74F77D004D6B84b"}}, false), true); err == nil {
+		t.Error("expected the used nonce rejected")
+	}
+	if err = bc.CheckEnvelope(signedTx(Envelope{Collection: "c", Nonce: 1}, false), false); err != nil {
+		t.Errorf("expected a used nonce from a peer accepted, actual: %s", err)
+	}
+
+	now := time.Now().UnixNano() / 1000000
+	if err = bc.CheckEnvelope(signedTx(Envelope{Collection: "c", ExpiresAt: now - 1}, false), true); err == nil {
+		t.Error("expected the expired signature rejected")
+	}
+	if err = bc.CheckEnvelope(signedTx(Envelope{Collection: "c", ExpiresAt: now + int64(2*MaxEnvelopeLifetime/time.Millisecond)}, false), true); err == nil {
+		t.Error("expected the long-lived signature rejected")
+	}
+	expiring := signedTx(Envelope{Collection: "c", ExpiresAt: now + 60000}, false)
+	if !IsValidTransactionSig(expiring) || bc.CheckEnvelope(expiring, true) != nil {
+		t.Error("expected the expiring signature accepted")
+	}
+
+	legacy := NewTransaction([]byte("peer"), rawData, "c", publicKey, Sign(*privateKey, crypto.Keccak256(rawData)), nil)
+	if !IsValidTransactionSig(legacy) || bc.CheckEnvelope(legacy, true) != nil {
+		t.Error("expected the unbound signature over the document valid")
+	}
+}
+
+func TestBatchEnvelope(t *testing.T) {
+	bc, _, cleanup := newTestBlockchain(t)
+	defer cleanup()
+	var err error
+
+	privateKey, _ := crypto.GenerateKey()
+	publicKey := crypto.FromECDSAPub(&privateKey.PublicKey)
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).String()
+	rawDocuments := [][]byte{[]byte(`{"id": 1}`), []byte(`{"id": 2}`), []byte(`{"id": 3}`)}
+
+	leaves := make([][]byte, len(rawDocuments))
+	for i, rawData := range rawDocuments {
+		leaves[i] = BatchLeaf(rawData)
+	}
+	batchTree := NewMerkleTree(leaves)
+	merkleRoot := batchTree.RootNode.Data
+
+	envelope := Envelope{Collection: "c", Nonce: 5, PermittedAddresses: []string{address}}
+	signature := Sign(*privateKey, crypto.Keccak256(envelope.BatchSigningMessage(merkleRoot, address)))
+
+	var txs []*Transaction
+	for i, rawData := range rawDocuments {
+		tx := NewTransaction([]byte("peer"), rawData, "c", publicKey, signature, envelope.PermittedAddresses)
+		tx.Nonce = envelope.Nonce
+		tx.BatchRoot = merkleRoot
+		tx.BatchPath = batchTree.GetVerificationPath(BatchLeaf(rawData))
+		if !IsValidTransactionSig(tx) {
+			t.Fatalf("expected the signature of document %d in the batch valid", i)
+		}
+		txs = append(txs, tx)
+	}
+
+	tampered := *txs[0]
+	tampered.RawData = []byte(`{"id": 4}`)
+	if IsValidTransactionSig(&tampered) {
+		t.Error("expected the signature invalid for a document out of the batch")
+	}
+	tampered = *txs[0]
+	tampered.BatchPath = txs[1].BatchPath
+	if IsValidTransactionSig(&tampered) {
+		t.Error("expected the signature invalid with the path of another document")
+	}
+
+	if err = bc.CheckBatchEnvelope(envelope, address); err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range txs {
+		if err = bc.CheckEnvelope(tx, true); err != nil {
+			t.Fatal(err)
+		}
+		if err = bc.RecordEnvelope(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bc.GetNonce(address) != 5 {
+		t.Errorf("expected the nonce 5, actual: %d", bc.GetNonce(address))
+	}
+
+	replay := *txs[2]
+	replay.SetID()
+	if err = bc.CheckEnvelope(&replay, true); err == nil {
+		t.Error("expected the replay of a document in the batch rejected")
+	}
+	if err = bc.CheckBatchEnvelope(envelope, address); err == nil {
+		t.Error("expected the replay of the batch rejected")
+	}
+}
@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The types of the keys of the accounts
+const (
+	KeyTypeSecp256k1 = ""        // the uncompressed secp256k1 keys of Ethereum, prefixed with 04
+	KeyTypeEd25519   = "ed25519" // the 32-byte Ed25519 keys of IoT devices and SSH tooling
+)
+
+// CheckPublicKey rejects a public key which is not of the key type
+func CheckPublicKey(keyType string, publicKey []byte) error {
+	switch keyType {
+	case KeyTypeSecp256k1:
+		if _, err := crypto.UnmarshalPubkey(publicKey); err != nil {
+			return fmt.Errorf("invalid secp256k1 public key: %s", err)
+		}
+	case KeyTypeEd25519:
+		if len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ed25519 public key: %d bytes instead of %d", len(publicKey), ed25519.PublicKeySize)
+		}
+	default:
+		return fmt.Errorf("unsupported key type: %s. Must be empty (secp256k1) or %s", keyType, KeyTypeEd25519)
+	}
+
+	return nil
+}
+
+// KeyAddress derives the address of a public key of the key type. The address of an Ed25519 key has the format of the secp256k1 ones:
+// the last 20 bytes of the keccak256 of the key, so that the accounts of both types share the same routes
+func KeyAddress(keyType string, publicKey []byte) (string, error) {
+	if keyType == KeyTypeSecp256k1 {
+		return PublicKeyToAddress(publicKey)
+	}
+
+	if err := CheckPublicKey(keyType, publicKey); err != nil {
+		return "", err
+	}
+
+	return common.BytesToAddress(crypto.Keccak256(publicKey)[12:]).String(), nil
+}
+
+// IsValidKeySig verifies a signature over a digest with a public key of the key type. An Ed25519 signature signs the digest as its message;
+// a secp256k1 signature is of [R || S || V] format where V is ignored
+func IsValidKeySig(keyType string, digest []byte, pubKey []byte, signature []byte) bool {
+	switch keyType {
+	case KeyTypeSecp256k1:
+		return len(signature) >= 64 && crypto.VerifySignature(pubKey, digest, signature[:64])
+	case KeyTypeEd25519:
+		return len(pubKey) == ed25519.PublicKeySize && len(signature) == ed25519.SignatureSize && ed25519.Verify(pubKey, digest, signature)
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestKeyType(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	address, err := KeyAddress(KeyTypeEd25519, publicKey)
+	if err != nil || !IsValidAddress(address) {
+		t.Fatalf("an Ed25519 key should have an address: %s %v", address, err)
+	}
+
+	if _, err = KeyAddress(KeyTypeEd25519, publicKey[:16]); err == nil {
+		t.Error("a short Ed25519 key should be rejected")
+	}
+	if _, err = KeyAddress("rsa", publicKey); err == nil {
+		t.Error("an unsupported key type should be rejected")
+	}
+
+	secpKey, _ := crypto.GenerateKey()
+	secpAddress, err := KeyAddress(KeyTypeSecp256k1, crypto.FromECDSAPub(&secpKey.PublicKey))
+	if err != nil || secpAddress != crypto.PubkeyToAddress(secpKey.PublicKey).String() {
+		t.Errorf("a secp256k1 key should have its Ethereum address: %s %v", secpAddress, err)
+	}
+
+	// a transaction signed with an Ed25519 key
+	envelope := Envelope{Collection: "default", Nonce: 1, PermittedAddresses: []string{address}}
+	tx := NewTransaction([]byte("peer"), []byte(`{"id":1}`), envelope.Collection, publicKey, nil, envelope.PermittedAddresses)
+	tx.Nonce = envelope.Nonce
+	tx.KeyType = KeyTypeEd25519
+	digest, _ := TransactionDigest(tx, address)
+	tx.Signature = ed25519.Sign(privateKey, digest)
+	if !IsValidTransactionSig(tx) {
+		t.Error("the Ed25519 signature should be valid")
+	}
+	if transactionAddress, err := TransactionAddress(tx); err != nil || transactionAddress != address {
+		t.Errorf("the address of the transaction should be of the Ed25519 key: %s %v", transactionAddress, err)
+	}
+
+	tx.KeyType = KeyTypeSecp256k1
+	if IsValidTransactionSig(tx) {
+		t.Error("the Ed25519 signature should not be valid as secp256k1")
+	}
+
+	if !IsValidSig(KeyTypeEd25519, []byte("hello"), publicKey, ed25519.Sign(privateKey, crypto.Keccak256([]byte("hello")))) {
+		t.Error("IsValidSig should verify an Ed25519 signature of the keccak256 of the data")
+	}
+}
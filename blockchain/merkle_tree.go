@@ -96,6 +96,45 @@ func (mt MerkleTree) GetVerificationPath(txToVerify []byte) map[int][]byte {
 	return hashPath
 }
 
+// VerifyVerificationPath recomputes the root from a hash and its verification path. Returns if the root matches the one in the path
+func VerifyVerificationPath(hash []byte, hashPath map[int][]byte) bool {
+	root, ok := hashPath[0]
+	if !ok || len(hashPath) < 2 {
+		return false
+	}
+
+	// the deepest node in the path is the sibling of the hash
+	sibling := 0
+	for index := range hashPath {
+		if index > sibling {
+			sibling = index
+		}
+	}
+	cursor := sibling - 1
+	if sibling%2 != 0 {
+		cursor = sibling + 1
+	}
+
+	for cursor > 0 {
+		if cursor%2 != 0 { // left node
+			siblingHash, ok := hashPath[cursor+1]
+			if !ok {
+				return false
+			}
+			hash = crypto.Keccak256(append(append([]byte{}, hash...), siblingHash...))
+		} else {
+			siblingHash, ok := hashPath[cursor-1]
+			if !ok {
+				return false
+			}
+			hash = crypto.Keccak256(append(append([]byte{}, siblingHash...), hash...))
+		}
+		cursor = (cursor - 1) / 2
+	}
+
+	return bytes.Equal(hash, root)
+}
+
 // findNodeByData finds the tree node having the transation hash
 func (mt MerkleTree) findNodeByData(hash []byte) ([]*MerkleNode, int) {
 	if mt.RootNode != nil {
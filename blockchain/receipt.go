@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/perlin-network/noise"
+)
+
+// Receipt is the proof that a node accepted a transaction, signed with the P2P key of the node. The blockchainId is the public key to verify it
+type Receipt struct {
+	TransactionID     string `json:"transactionId"`
+	BlockchainId      string `json:"blockchainId"`
+	Collection        string `json:"collection"`
+	DocumentHash      string `json:"documentHash"` // sha256 of the raw document
+	AcceptedTimestamp int64  `json:"acceptedTimestamp"`
+	Signature         string `json:"signature"`
+}
+
+// digest is what the receipt signature covers
+func (r Receipt) digest() []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%d", r.TransactionID, r.BlockchainId, r.Collection, r.DocumentHash, r.AcceptedTimestamp)))
+	return digest[:]
+}
+
+// Verify checks the receipt is signed by the node of its blockchainId
+func (r Receipt) Verify() bool {
+	publicKeyBytes, err := hex.DecodeString(r.BlockchainId)
+	signatureBytes, sigErr := hex.DecodeString(r.Signature)
+	if err != nil || sigErr != nil || len(publicKeyBytes) != noise.SizePublicKey || len(signatureBytes) != noise.SizeSignature {
+		return false
+	}
+
+	var publicKey noise.PublicKey
+	var signature noise.Signature
+	copy(publicKey[:], publicKeyBytes)
+	copy(signature[:], signatureBytes)
+
+	return publicKey.Verify(r.digest(), signature)
+}
+
+// SignReceipt signs the receipt of a transaction accepted by the local node
+func (bc *Blockchain) SignReceipt(tx *Transaction) (*Receipt, error) {
+	var p2pPrivKey noise.PrivateKey
+	err := bc.Db.View(func(dbtx *bolt.Tx) error {
+		p2pPrivKeyBytes := dbtx.Bucket([]byte(BlocksBucket)).Get([]byte(P2PPrivateKeyKey))
+		if len(p2pPrivKeyBytes) != noise.SizePrivateKey {
+			return errors.New("cannot find the p2p private key")
+		}
+
+		copy(p2pPrivKey[:], p2pPrivKeyBytes)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	documentHash := sha256.Sum256(tx.RawData)
+	receipt := Receipt{TransactionID: fmt.Sprintf("%x", tx.ID), BlockchainId: fmt.Sprintf("%x", bc.PeerId), Collection: tx.Collection, DocumentHash: fmt.Sprintf("%x", documentHash), AcceptedTimestamp: tx.AcceptedTimestamp}
+	signature := p2pPrivKey.Sign(receipt.digest())
+	receipt.Signature = fmt.Sprintf("%x", signature[:])
+
+	return &receipt, nil
+}
+
+// FindTransaction looks up a transaction and its block by the transaction ID. Returns nil if the transaction is not in the blockchain
+func (bc *Blockchain) FindTransaction(txID []byte) (*Transaction, *Block, error) {
+	var tx *Transaction
+	var block *Block
+
+	err := bc.Db.View(func(dbtx *bolt.Tx) error {
+		txBucket := dbtx.Bucket([]byte(TransactionsBucket))
+		if txBucket == nil {
+			return nil
+		}
+
+		var encodedTx []byte
+		if txIndexBucket := dbtx.Bucket([]byte(TxIndexBucket)); txIndexBucket != nil {
+			if blockHash := txIndexBucket.Get(txID); blockHash != nil {
+				encodedTx = txBucket.Get(append(append(append([]byte{}, blockHash...), []byte("_")...), txID...))
+			}
+		}
+
+		if encodedTx == nil {
+			return nil
+		}
+		tx = DeserializeTransaction(encodedTx)
+
+		encodedBlock := dbtx.Bucket([]byte(BlocksBucket)).Get(tx.BlockHash)
+		if encodedBlock == nil {
+			return fmt.Errorf("cannot find block %x of transaction %x", tx.BlockHash, txID)
+		}
+		block = DeserializeBlock(encodedBlock)
+
+		// the transactions to build the merkle tree
+		c := txBucket.Cursor()
+		for k, v := c.Seek(block.Hash); k != nil && bytes.HasPrefix(k, block.Hash); k, v = c.Next() {
+			block.Transactions = append(block.Transactions, DeserializeTransaction(v))
+		}
+
+		return nil
+	})
+
+	if err != nil || tx == nil {
+		return nil, nil, err
+	}
+
+	return tx, block, nil
+}
+
+// BackfillTxIndex indexes the transactions persisted before the transaction index existed. It runs once per blockchain DB, the local or of a peer
+func BackfillTxIndex(db *bolt.DB) error {
+	return db.Update(func(dbtx *bolt.Tx) error {
+		bBucket := dbtx.Bucket([]byte(BlocksBucket))
+		if bBucket.Get([]byte(TxIndexBackfilledKey)) != nil {
+			return nil
+		}
+
+		txIndexBucket, err := dbtx.CreateBucketIfNotExists([]byte(TxIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		if txBucket := dbtx.Bucket([]byte(TransactionsBucket)); txBucket != nil {
+			c := txBucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				// key format: blockHash_transactionId
+				tx := DeserializeTransaction(v)
+				if len(k) <= len(tx.ID) {
+					continue
+				}
+				if err = txIndexBucket.Put(tx.ID, k[:len(k)-len(tx.ID)-1]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return bBucket.Put([]byte(TxIndexBackfilledKey), []byte{1})
+	})
+}
+
+// AddBlockAck records that a peer has replicated a local block
+func (bc *Blockchain) AddBlockAck(blockHash []byte, peerId []byte) error {
+	return bc.Db.Update(func(dbtx *bolt.Tx) error {
+		if dbtx.Bucket([]byte(BlocksBucket)).Get(blockHash) == nil {
+			return fmt.Errorf("block %x doesn't exist", blockHash)
+		}
+
+		b, err := dbtx.CreateBucketIfNotExists([]byte(BlockAcksBucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put(append(append([]byte{}, blockHash...), peerId...), IntToHex(time.Now().UnixNano()/1000000))
+	})
+}
+
+// GetBlockAcks returns the peers which have replicated a local block
+func (bc *Blockchain) GetBlockAcks(blockHash []byte) []string {
+	var peerIds []string
+	bc.Db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(BlockAcksBucket))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, _ := c.Seek(blockHash); k != nil && bytes.HasPrefix(k, blockHash); k, _ = c.Next() {
+			peerIds = append(peerIds, fmt.Sprintf("%x", k[len(blockHash):]))
+		}
+
+		return nil
+	})
+
+	return peerIds
+}
@@ -0,0 +1,78 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/perlin-network/noise"
+)
+
+func TestReceiptAndFindTransaction(t *testing.T) {
+	bc, _, cleanup := newTestBlockchain(t)
+	defer cleanup()
+	db := bc.Db
+
+	publicKey, privateKey, _ := noise.GenerateKeys(nil)
+	db.Update(func(dbtx *bolt.Tx) error {
+		bBucket, _ := dbtx.CreateBucket([]byte(BlocksBucket))
+		dbtx.CreateBucket([]byte(TransactionsBucket))
+		return bBucket.Put([]byte(P2PPrivateKeyKey), privateKey[:])
+	})
+	bc.PeerId = publicKey[:]
+
+	txs := []*Transaction{
+		NewTransaction(publicKey[:], []byte(`{"id": 1}`), "c", nil, nil, nil),
+		NewTransaction(publicKey[:], []byte(`{"id": 2}`), "c", nil, nil, nil),
+	}
+	receipt, err := bc.SignReceipt(txs[0])
+	if err != nil || !receipt.Verify() {
+		t.Fatalf("expected a valid receipt, actual: %v %v", receipt, err)
+	}
+
+	tampered := *receipt
+	tampered.DocumentHash = "00"
+	if tampered.Verify() {
+		t.Error("expected the tampered receipt invalid")
+	}
+
+	if tx, _, _ := bc.FindTransaction(txs[0].ID); tx != nil {
+		t.Error("expected the transaction not in a block yet")
+	}
+
+	block := NewBlock(txs, []byte{}, 1)
+	block.Persist(db, true)
+
+	tx, foundBlock, err := bc.FindTransaction(txs[1].ID)
+	if err != nil || tx == nil || string(tx.RawData) != `{"id": 2}` || foundBlock.Height != 1 || len(foundBlock.Transactions) != 2 {
+		t.Fatalf("expected the transaction in block 1, actual: %v %v %v", tx, foundBlock, err)
+	}
+	if path := foundBlock.GetMerkleTree().GetVerificationPath(txs[1].ID); len(path) == 0 {
+		t.Error("expected a verification path")
+	}
+
+	// a blockchain persisted before the transaction index existed
+	db.Update(func(dbtx *bolt.Tx) error {
+		return dbtx.DeleteBucket([]byte(TxIndexBucket))
+	})
+	if tx, _, _ := bc.FindTransaction(txs[1].ID); tx != nil {
+		t.Fatal("expected the transaction not indexed")
+	}
+	if err = BackfillTxIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if tx, foundBlock, _ := bc.FindTransaction(txs[1].ID); tx == nil || foundBlock.Height != 1 {
+		t.Errorf("expected the transaction backfilled in block 1, actual: %v %v", tx, foundBlock)
+	}
+
+	if err = bc.AddBlockAck(block.Hash, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	bc.AddBlockAck(block.Hash, []byte{2})
+	bc.AddBlockAck(block.Hash, []byte{1})
+	if acks := bc.GetBlockAcks(block.Hash); len(acks) != 2 || acks[0] != "01" {
+		t.Errorf("expected 2 peers acknowledged, actual: %v", acks)
+	}
+	if err = bc.AddBlockAck([]byte("unknown"), []byte{1}); err == nil {
+		t.Error("expected an error for the unknown block")
+	}
+}
@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// SensitiveFieldsReadAll allows a role to read all the sensitive fields of a collection
+const SensitiveFieldsReadAll = "*"
+
+// CanReadSensitiveField tells if the role may read a sensitive field of a collection
+func (r Role) CanReadSensitiveField(collection string, field string) bool {
+	if r.Name == "admin" {
+		return true
+	}
+
+	for _, readableField := range r.SensitiveFieldsRead[collection] {
+		if readableField == field || readableField == SensitiveFieldsReadAll {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SensitiveFields returns the sensitive fields of the mapping and their masks. An empty mask means the field is stripped
+func (dm DocumentMapping) SensitiveFields() map[string]string {
+	sensitiveFields := make(map[string]string)
+	for field, v := range dm.Fields {
+		fieldMapping, _ := v.(map[string]interface{})
+		if isSensitive, _ := fieldMapping["sensitive"].(bool); isSensitive {
+			mask, _ := fieldMapping["mask"].(string)
+			sensitiveFields[field] = mask
+		}
+	}
+
+	return sensitiveFields
+}
+
+// Redact strips or masks the sensitive fields of a document which the role may not read. Returns the raw data as is if nothing is redacted
+func (dm DocumentMapping) Redact(rawData []byte, role Role) ([]byte, bool) {
+	sensitiveFields := dm.SensitiveFields()
+	if len(sensitiveFields) == 0 {
+		return rawData, false
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(rawData, &document); err != nil {
+		return rawData, false
+	}
+
+	isRedacted := false
+	for field, mask := range sensitiveFields {
+		if _, ok := document[field]; !ok || role.CanReadSensitiveField(dm.Collection, field) {
+			continue
+		}
+
+		if len(mask) > 0 {
+			document[field] = mask
+		} else {
+			delete(document, field)
+		}
+		isRedacted = true
+	}
+
+	if !isRedacted {
+		return rawData, false
+	}
+
+	redactedData, err := json.Marshal(document)
+	if err != nil {
+		return rawData, false
+	}
+
+	return redactedData, true
+}
+
+// CheckSensitiveSearch rejects a search of the collection which queries, sorts on, returns or highlights a sensitive field the role may not read,
+// so that a masked value cannot be recovered by trying candidates or by its order. A query without field searches the composite field _all,
+// which holds the sensitive fields of the collections created before they were left out of it
+func (dm DocumentMapping) CheckSensitiveSearch(index bleve.Index, searchRequest *bleve.SearchRequest, role Role) error {
+	unreadableFields := make(map[string]bool)
+	for field := range dm.SensitiveFields() {
+		if !role.CanReadSensitiveField(dm.Collection, field) {
+			unreadableFields[field] = true
+		}
+	}
+	if len(unreadableFields) == 0 {
+		return nil
+	}
+
+	fields, err := queryFields(searchRequest.Query)
+	if err != nil {
+		return err
+	}
+	fields = append(fields, searchRequest.Fields...)
+	for _, sort := range searchRequest.Sort {
+		switch sortField := sort.(type) {
+		case *search.SortField:
+			fields = append(fields, sortField.Field)
+		case *search.SortGeoDistance:
+			fields = append(fields, sortField.Field)
+		}
+	}
+	if searchRequest.Highlight != nil {
+		fields = append(fields, searchRequest.Highlight.Fields...)
+	}
+
+	for _, field := range fields {
+		if unreadableFields[field] {
+			return fmt.Errorf("insufficient permission to search on sensitive field: %s", field)
+		} else if (field == "" || field == "_all") && includesInAll(index, dm.Collection, unreadableFields) {
+			return fmt.Errorf("a query without field may match the sensitive fields of collection %s. Query the fields instead", dm.Collection)
+		}
+	}
+
+	return nil
+}
+
+// queryFields returns the fields a query searches, empty for the default field. A query string is parsed to find its fields
+func queryFields(q query.Query) ([]string, error) {
+	var fields []string
+	var subqueries []query.Query
+
+	switch typedQuery := q.(type) {
+	case *query.QueryStringQuery:
+		parsedQuery, err := typedQuery.Parse()
+		if err != nil {
+			return nil, err
+		}
+		subqueries = append(subqueries, parsedQuery)
+	case *query.BooleanQuery:
+		subqueries = append(subqueries, typedQuery.Must, typedQuery.Should, typedQuery.MustNot)
+	case *query.ConjunctionQuery:
+		subqueries = append(subqueries, typedQuery.Conjuncts...)
+	case *query.DisjunctionQuery:
+		subqueries = append(subqueries, typedQuery.Disjuncts...)
+	case query.FieldableQuery:
+		fields = append(fields, typedQuery.Field())
+	}
+
+	for _, subquery := range subqueries {
+		if subquery == nil {
+			continue
+		}
+		subqueryFields, err := queryFields(subquery)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, subqueryFields...)
+	}
+
+	return fields, nil
+}
+
+// includesInAll tells if any of the fields of the collection is indexed in the composite field _all
+func includesInAll(index bleve.Index, collection string, fields map[string]bool) bool {
+	indexMapping, ok := index.Mapping().(*mapping.IndexMappingImpl)
+	if !ok || indexMapping.TypeMapping[collection] == nil {
+		return true
+	}
+
+	for field := range fields {
+		if fieldMapping := indexMapping.TypeMapping[collection].Properties[field]; fieldMapping != nil {
+			for _, f := range fieldMapping.Fields {
+				if f.IncludeInAll {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
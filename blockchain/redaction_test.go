@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+func TestRedact(t *testing.T) {
+	documentMapping := DocumentMapping{Collection: "patients", Fields: map[string]interface{}{
+		"name":      map[string]interface{}{"type": "text"},
+		"ssn":       map[string]interface{}{"type": "text", "sensitive": true, "mask": "***"},
+		"diagnosis": map[string]interface{}{"type": "text", "sensitive": true},
+	}}
+	rawData := []byte(`{"name": "Jane", "ssn": "123-45-6789", "diagnosis": "flu"}`)
+
+	redactedData, isRedacted := documentMapping.Redact(rawData, Role{Name: "user"})
+	var document map[string]interface{}
+	json.Unmarshal(redactedData, &document)
+	if !isRedacted || document["name"] != "Jane" || document["ssn"] != "***" || document["diagnosis"] != nil {
+		t.Errorf("Redact expected the ssn masked and the diagnosis stripped, actual: %s", redactedData)
+	}
+
+	redactedData, isRedacted = documentMapping.Redact(rawData, Role{Name: "doctor", SensitiveFieldsRead: map[string][]string{"patients": {"diagnosis"}}})
+	document = nil
+	json.Unmarshal(redactedData, &document)
+	if !isRedacted || document["ssn"] != "***" || document["diagnosis"] != "flu" {
+		t.Errorf("Redact expected only the ssn masked, actual: %s", redactedData)
+	}
+
+	for _, role := range []Role{{Name: "admin"}, {Name: "auditor", SensitiveFieldsRead: map[string][]string{"patients": {SensitiveFieldsReadAll}}}} {
+		if redactedData, isRedacted = documentMapping.Redact(rawData, role); isRedacted || string(redactedData) != string(rawData) {
+			t.Errorf("Redact expected the original for role %s, actual: %s", role.Name, redactedData)
+		}
+	}
+}
+
+func TestCheckSensitiveConstraints(t *testing.T) {
+	invalidFields := []map[string]interface{}{
+		{"ssn": map[string]interface{}{"type": "text", "sensitive": "yes"}},
+		{"ssn": map[string]interface{}{"type": "text", "mask": "***"}},
+		{"ssn": map[string]interface{}{"type": "text", "sensitive": true, "mask": 0.0}},
+	}
+
+	for _, fields := range invalidFields {
+		if err := (DocumentMapping{Collection: "c", Fields: fields}).checkConstraints(); err == nil {
+			t.Errorf("checkConstraints expected an error for %v", fields)
+		}
+	}
+}
+
+func TestCheckSensitiveSearch(t *testing.T) {
+	bc, dir, cleanup := newTestBlockchain(t)
+	defer cleanup()
+
+	s, err := NewSearch(bc.Db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documentMapping := DocumentMapping{Collection: "patients", State: CollectionActive, Fields: map[string]interface{}{
+		"name": map[string]interface{}{"type": "text"},
+		"ssn":  map[string]interface{}{"type": "text", "sensitive": true, "mask": "***"},
+	}}
+	index, err := s.CreateMapping(documentMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Index("1", map[string]interface{}{"_type": "patients", "name": "Jane", "ssn": "123-45-6789"})
+
+	ssnQuery := query.NewTermQuery("123-45-6789")
+	ssnQuery.SetField("ssn")
+	rejectedRequests := []*bleve.SearchRequest{
+		bleve.NewSearchRequest(bleve.NewQueryStringQuery("ssn:123-45-6789")),
+		bleve.NewSearchRequest(bleve.NewQueryStringQuery("name:Jane -ssn:000")),
+		bleve.NewSearchRequest(query.NewConjunctionQuery([]query.Query{query.NewMatchAllQuery(), query.NewDisjunctionQuery([]query.Query{ssnQuery})})),
+	}
+	sortedRequest := bleve.NewSearchRequest(bleve.NewQueryStringQuery("name:Jane"))
+	sortedRequest.SortBy([]string{"-ssn"})
+	fieldsRequest := bleve.NewSearchRequest(bleve.NewQueryStringQuery("name:Jane"))
+	fieldsRequest.Fields = []string{"ssn"}
+	rejectedRequests = append(rejectedRequests, sortedRequest, fieldsRequest)
+
+	for _, searchRequest := range rejectedRequests {
+		if err = documentMapping.CheckSensitiveSearch(index, searchRequest, Role{Name: "user"}); err == nil {
+			t.Errorf("CheckSensitiveSearch expected an error for %v %v %v", searchRequest.Query, searchRequest.Sort, searchRequest.Fields)
+		}
+	}
+
+	// the sensitive field is not in _all, so that a query without field doesn't match it
+	if err = documentMapping.CheckSensitiveSearch(index, bleve.NewSearchRequest(bleve.NewQueryStringQuery("Jane")), Role{Name: "user"}); err != nil {
+		t.Errorf("CheckSensitiveSearch expected a query without field to be allowed, actual: %s", err)
+	}
+	if searchResponse, _ := index.Search(bleve.NewSearchRequest(bleve.NewQueryStringQuery("123-45-6789"))); searchResponse.Total != 0 {
+		t.Errorf("a query without field expected not to match the sensitive field, actual: %d hits", searchResponse.Total)
+	}
+
+	doctor := Role{Name: "doctor", SensitiveFieldsRead: map[string][]string{"patients": {"ssn"}}}
+	for _, searchRequest := range rejectedRequests[:2] {
+		if err = documentMapping.CheckSensitiveSearch(index, searchRequest, doctor); err != nil {
+			t.Errorf("CheckSensitiveSearch expected the granted role to search on the sensitive field, actual: %s", err)
+		}
+	}
+
+	// a collection created before the sensitive fields were left out of _all
+	legacyMapping := bleve.NewIndexMapping()
+	legacyDocumentMapping := bleve.NewDocumentMapping()
+	legacyDocumentMapping.AddFieldMappingsAt("ssn", bleve.NewTextFieldMapping())
+	legacyMapping.AddDocumentMapping("patients", legacyDocumentMapping)
+	legacyIndex, err := bleve.NewMemOnly(legacyMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacyIndex.Close()
+	for _, q := range []query.Query{bleve.NewQueryStringQuery("123-45-6789"), query.NewConjunctionQuery([]query.Query{query.NewMatchAllQuery(), query.NewTermQuery("123-45-6789")})} {
+		if err = documentMapping.CheckSensitiveSearch(legacyIndex, bleve.NewSearchRequest(q), Role{Name: "user"}); err == nil {
+			t.Errorf("CheckSensitiveSearch expected a query without field to be rejected when _all holds the sensitive field: %v", q)
+		}
+	}
+}
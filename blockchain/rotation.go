@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// KeyRotationMessage is the message the current key of an account signs to rotate to a new key. The rotation is one more than the rotated keys,
+// so that a rotation cannot be replayed:
+//
+// blocace-key-rotation
+// address:<address>
+// rotation:<rotation>
+// keyType:<keyType>
+// publicKey:<publicKey in hex as registered, without the 04 prefix of secp256k1>
+func (a Account) KeyRotationMessage(address string, keyType string, publicKey string) []byte {
+	return []byte(fmt.Sprintf("blocace-key-rotation\naddress:%s\nrotation:%d\nkeyType:%s\npublicKey:%s\n", strings.ToLower(address), len(a.KeyHistory)+1, keyType, strings.ToLower(publicKey)))
+}
+
+// RotateKey moves the current key to the key history and makes the new key effective from the timestamp. The address and the role stay the same
+func (a *Account) RotateKey(keyType string, publicKey string, timestamp int64) {
+	a.KeyHistory = append(a.KeyHistory, AccountKey{PublicKey: a.PublicKey, KeyType: a.KeyType, EffectiveFrom: a.KeySince, EffectiveUntil: timestamp})
+	a.PublicKey = publicKey
+	a.KeyType = keyType
+	a.KeySince = timestamp
+}
+
+// KeyAt returns the key of the account effective at the timestamp
+func (a Account) KeyAt(timestamp int64) (AccountKey, bool) {
+	if timestamp >= a.KeySince {
+		return AccountKey{PublicKey: a.PublicKey, KeyType: a.KeyType, EffectiveFrom: a.KeySince}, true
+	}
+
+	for _, key := range a.KeyHistory {
+		if key.EffectiveFrom <= timestamp && timestamp < key.EffectiveUntil {
+			return key, true
+		}
+	}
+
+	return AccountKey{}, false
+}
+
+// IsAuthorKey tells if the public key of a transaction was the key of its signer when the transaction was accepted, so that a rotated key cannot sign
+// for the address any more. The signer is the author, or the delegate of a delegation the author signed. The transaction of an account not known locally
+// must be signed with the key its address derives from
+func (bc *Blockchain) IsAuthorKey(tx *Transaction) bool {
+	address, err := TransactionAddress(tx)
+	if err != nil {
+		return false
+	}
+
+	if tx.Delegation != nil {
+		if bc.CheckDelegation(tx.Delegation, address, tx.Collection, tx.AcceptedTimestamp) != nil {
+			return false
+		}
+		address = tx.Delegation.Delegate
+	}
+
+	if bc.getAccount(address) == nil {
+		keyAddress, err := KeyAddress(tx.KeyType, tx.PubKey)
+		return err == nil && keyAddress == address
+	}
+
+	key, ok := bc.accountKeyAt(address, tx.AcceptedTimestamp)
+	return ok && key.KeyType == tx.KeyType && strings.EqualFold(key.PublicKey, hex.EncodeToString(tx.PubKey))
+}
+
+// accountKeyAt returns the key of the account of the address effective at the timestamp. False if the account is not known locally
+func (bc *Blockchain) accountKeyAt(address string, timestamp int64) (AccountKey, bool) {
+	account := bc.getAccount(address)
+	if account == nil {
+		return AccountKey{}, false
+	}
+
+	return account.KeyAt(timestamp)
+}
+
+// getAccount returns the account of the address, or nil
+func (bc *Blockchain) getAccount(address string) *Account {
+	var account *Account
+	bc.Db.View(func(dbtx *bolt.Tx) error {
+		if b := dbtx.Bucket([]byte(AccountsBucket)); b != nil {
+			if encodedAccount := b.Get([]byte(address)); encodedAccount != nil {
+				account = DeserializeAccount(encodedAccount)
+			}
+		}
+		return nil
+	})
+
+	return account
+}
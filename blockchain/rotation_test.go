@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestKeyRotation(t *testing.T) {
+	bc, _, cleanup := newTestBlockchain(t)
+	defer cleanup()
+	var err error
+
+	oldKey, _ := crypto.GenerateKey()
+	newKey, _ := crypto.GenerateKey()
+	oldPublicKey, newPublicKey := crypto.FromECDSAPub(&oldKey.PublicKey), crypto.FromECDSAPub(&newKey.PublicKey)
+	address := crypto.PubkeyToAddress(oldKey.PublicKey).String()
+
+	account := Account{PublicKey: hex.EncodeToString(oldPublicKey), Role: Role{Name: "user"}}
+	firstMessage := account.KeyRotationMessage(address, KeyTypeSecp256k1, hex.EncodeToString(newPublicKey[1:]))
+	account.RotateKey(KeyTypeSecp256k1, hex.EncodeToString(newPublicKey), 1000)
+	if bytes.Equal(firstMessage, account.KeyRotationMessage(address, KeyTypeSecp256k1, hex.EncodeToString(newPublicKey[1:]))) {
+		t.Error("the message of the next rotation should differ so that a rotation cannot be replayed")
+	}
+
+	if key, ok := account.KeyAt(999); !ok || key.PublicKey != hex.EncodeToString(oldPublicKey) || key.EffectiveUntil != 1000 {
+		t.Errorf("the old key should be effective before the rotation: %+v", key)
+	}
+	if key, ok := account.KeyAt(1000); !ok || key.PublicKey != hex.EncodeToString(newPublicKey) {
+		t.Errorf("the new key should be effective from the rotation: %+v", key)
+	}
+
+	signedTx := func(privateKeyIndex int, acceptedTimestamp int64) *Transaction {
+		privateKey, publicKey := oldKey, oldPublicKey
+		if privateKeyIndex == 1 {
+			privateKey, publicKey = newKey, newPublicKey
+		}
+		tx := NewTransaction([]byte("peer"), []byte(`{"id":1}`), "default", publicKey, nil, []string{address})
+		tx.Nonce = 1
+		tx.Address = address
+		tx.AcceptedTimestamp = acceptedTimestamp
+		digest, _ := TransactionDigest(tx, address)
+		tx.Signature = Sign(*privateKey, digest)
+		return tx
+	}
+
+	// an account not known locally may only sign with the key of its address
+	if !bc.IsAuthorKey(signedTx(0, 2000)) || bc.IsAuthorKey(signedTx(1, 2000)) {
+		t.Error("the key of an unknown account should derive its address")
+	}
+
+	if err = bc.RegisterAccount([]byte(address), account); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx := signedTx(0, 500); !IsValidTransactionSig(tx) || !bc.IsAuthorKey(tx) {
+		t.Error("a document signed with the old key before the rotation should be valid")
+	}
+	if tx := signedTx(0, 2000); !IsValidTransactionSig(tx) || bc.IsAuthorKey(tx) {
+		t.Error("the old key should not sign after the rotation")
+	}
+	if tx := signedTx(1, 2000); !IsValidTransactionSig(tx) || !bc.IsAuthorKey(tx) {
+		t.Error("a document signed with the new key after the rotation should be valid")
+	}
+	if transactionAddress, _ := TransactionAddress(signedTx(1, 2000)); transactionAddress != address {
+		t.Errorf("the address should stay the same after the rotation: %s", transactionAddress)
+	}
+}
@@ -0,0 +1,623 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/geo"
+)
+
+// the maximum depth of the nested schemas and $ref to follow
+const maxSchemaDepth = 64
+
+// the maximum number of the schema nodes to evaluate a document against. anyOf and oneOf through a recursive $ref multiply the work at each level
+const maxSchemaSteps = 100000
+
+var schemaTypes = map[string]bool{"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true}
+
+// compiledSchema is a parsed schema with its patterns compiled
+type compiledSchema struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp // of pattern and patternProperties
+}
+
+// cachedSchema is the compiled schema of a version of a mapping
+type cachedSchema struct {
+	lastModified int64
+	schemaJSON   []byte
+	schema       *compiledSchema
+}
+
+// compiledSchemas caches the compiled schema of each collection, so that a schema is compiled once and not for each document
+var compiledSchemas = struct {
+	sync.Mutex
+	schemas map[string]cachedSchema
+}{schemas: make(map[string]cachedSchema)}
+
+// parseSchema parses a JSON Schema (draft 2020-12 subset), verifies it's well-formed and compiles its patterns
+func parseSchema(schemaJSON []byte) (*compiledSchema, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		return nil, fmt.Errorf("schema is not a valid JSON object: %s", err)
+	}
+
+	patterns := make(map[string]*regexp.Regexp)
+	if err := checkSchema(schema, schema, "#", 0, patterns); err != nil {
+		return nil, err
+	}
+
+	return &compiledSchema{root: schema, patterns: patterns}, nil
+}
+
+// getCompiledSchema returns the compiled schema of the mapping, compiling it once for each modification of the mapping
+func (dm DocumentMapping) getCompiledSchema() (*compiledSchema, error) {
+	compiledSchemas.Lock()
+	cached, ok := compiledSchemas.schemas[dm.Collection]
+	compiledSchemas.Unlock()
+	if ok && cached.lastModified == dm.LastModified && bytes.Equal(cached.schemaJSON, dm.Schema) {
+		return cached.schema, nil
+	}
+
+	schema, err := parseSchema(dm.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledSchemas.Lock()
+	compiledSchemas.schemas[dm.Collection] = cachedSchema{lastModified: dm.LastModified, schemaJSON: dm.Schema, schema: schema}
+	compiledSchemas.Unlock()
+
+	return schema, nil
+}
+
+// checkSchema walks the schema to verify the supported keywords are well-formed. The patterns are compiled into patterns
+func checkSchema(root map[string]interface{}, schema interface{}, path string, depth int, patterns map[string]*regexp.Regexp) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("schema is nested too deeply at %s", path)
+	}
+
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+
+	schemaObject, ok := schema.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("schema at %s should be an object or a boolean", path)
+	}
+
+	for keyword, value := range schemaObject {
+		keywordPath := path + "/" + keyword
+
+		switch keyword {
+		case "type":
+			types, ok := value.([]interface{})
+			if !ok {
+				types = []interface{}{value}
+			}
+			for _, t := range types {
+				if typeName, ok := t.(string); !ok || !schemaTypes[typeName] {
+					return fmt.Errorf("%s is not a valid type at %s", t, keywordPath)
+				}
+			}
+		case "enum":
+			if _, ok := value.([]interface{}); !ok {
+				return fmt.Errorf("%s should be an array", keywordPath)
+			}
+		case "required":
+			required, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s should be an array", keywordPath)
+			}
+			for _, property := range required {
+				if _, ok := property.(string); !ok {
+					return fmt.Errorf("%s should be an array of strings", keywordPath)
+				}
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+			"minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			number, ok := value.(float64)
+			if !ok {
+				return fmt.Errorf("%s should be a number", keywordPath)
+			}
+			if keyword == "multipleOf" && number <= 0 {
+				return fmt.Errorf("%s should be greater than 0", keywordPath)
+			}
+		case "uniqueItems":
+			if _, ok := value.(bool); !ok {
+				return fmt.Errorf("%s should be a boolean", keywordPath)
+			}
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s should be a string", keywordPath)
+			}
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s is not a valid pattern: %s", keywordPath, err)
+			}
+			patterns[pattern] = compiled
+		case "$ref":
+			ref, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s should be a string", keywordPath)
+			}
+			if _, err := resolveRef(root, ref); err != nil {
+				return err
+			}
+		case "properties", "patternProperties", "$defs", "definitions":
+			subschemas, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s should be an object", keywordPath)
+			}
+			for name, subschema := range subschemas {
+				if keyword == "patternProperties" {
+					compiled, err := regexp.Compile(name)
+					if err != nil {
+						return fmt.Errorf("%s is not a valid pattern at %s: %s", name, keywordPath, err)
+					}
+					patterns[name] = compiled
+				}
+				if err := checkSchema(root, subschema, keywordPath+"/"+name, depth+1, patterns); err != nil {
+					return err
+				}
+			}
+		case "allOf", "anyOf", "oneOf", "prefixItems":
+			subschemas, ok := value.([]interface{})
+			if !ok || len(subschemas) == 0 {
+				return fmt.Errorf("%s should be a non-empty array", keywordPath)
+			}
+			for i, subschema := range subschemas {
+				if err := checkSchema(root, subschema, keywordPath+"/"+strconv.Itoa(i), depth+1, patterns); err != nil {
+					return err
+				}
+			}
+		case "additionalProperties", "items", "contains", "not":
+			if err := checkSchema(root, value, keywordPath, depth+1, patterns); err != nil {
+				return err
+			}
+		}
+		// the other keywords are annotations, e.g. $schema, $id, title, description, format, default
+	}
+
+	return nil
+}
+
+// resolveRef resolves a local reference, e.g. #/$defs/address
+func resolveRef(root map[string]interface{}, ref string) (interface{}, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only the local $ref is supported: %s", ref)
+	}
+
+	var node interface{} = root
+	for _, token := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if token == "" {
+			continue
+		}
+
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve $ref: %s", ref)
+		}
+
+		node, ok = object[strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)]
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve $ref: %s", ref)
+		}
+	}
+
+	return node, nil
+}
+
+// deriveFields derives the field mappings from the top level properties of the schema. The properties which cannot be indexed are skipped
+func deriveFields(schema map[string]interface{}) (map[string]interface{}, error) {
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok || len(properties) == 0 {
+		return nil, fmt.Errorf("schema should define the properties of the documents")
+	}
+
+	fields := make(map[string]interface{})
+	for property, propertySchema := range properties {
+		if fieldType := deriveFieldType(schema, propertySchema, 0); fieldType != "" {
+			fieldMapping := map[string]interface{}{"type": fieldType}
+
+			// the redaction extensions, e.g. {"type": "string", "x-sensitive": true, "x-mask": "***"}
+			propertySchemaObject, _ := propertySchema.(map[string]interface{})
+			if isSensitive, _ := propertySchemaObject["x-sensitive"].(bool); isSensitive {
+				fieldMapping["sensitive"] = true
+				if mask, ok := propertySchemaObject["x-mask"].(string); ok {
+					fieldMapping["mask"] = mask
+				}
+			}
+
+			fields[property] = fieldMapping
+		}
+	}
+
+	return fields, nil
+}
+
+func deriveFieldType(root map[string]interface{}, schema interface{}, depth int) string {
+	schemaObject, ok := schema.(map[string]interface{})
+	if !ok || depth > maxSchemaDepth {
+		return ""
+	}
+
+	if ref, ok := schemaObject["$ref"].(string); ok {
+		if resolved, err := resolveRef(root, ref); err == nil {
+			return deriveFieldType(root, resolved, depth+1)
+		}
+	}
+
+	if schemaObject["format"] == "geopoint" {
+		return "geopoint"
+	}
+
+	// a nullable type like ["string", "null"] is indexed as the non-null type
+	var types []string
+	switch t := schemaObject["type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, element := range t {
+			if typeName, ok := element.(string); ok && typeName != "null" {
+				types = append(types, typeName)
+			}
+		}
+	}
+
+	if len(types) != 1 {
+		return ""
+	}
+
+	switch types[0] {
+	case "string":
+		if schemaObject["format"] == "date-time" {
+			return "datetime"
+		}
+		return "text"
+	case "number", "integer":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		return deriveFieldType(root, schemaObject["items"], depth+1)
+	default:
+		return ""
+	}
+}
+
+// schemaValidation is the validation of a document against a compiled schema
+type schemaValidation struct {
+	*compiledSchema
+	steps int // the schema nodes evaluated
+}
+
+// isExhausted tells if the validation has evaluated too many schema nodes to go on
+func (v *schemaValidation) isExhausted() bool {
+	return v.steps > maxSchemaSteps
+}
+
+// validateSchema validates a value against a schema node and adds the violations to validationErrors by path
+func (v *schemaValidation) validateSchema(schema interface{}, value interface{}, path string, validationErrors map[string]string, depth int) {
+	if depth > maxSchemaDepth {
+		validationErrors[path] = "schema is nested too deeply"
+		return
+	}
+
+	v.steps++
+	if v.isExhausted() {
+		validationErrors[path] = "schema takes too many steps to validate"
+		return
+	}
+
+	if isAllowed, ok := schema.(bool); ok {
+		if !isAllowed {
+			validationErrors[path] = "value is not allowed by the schema"
+		}
+		return
+	}
+
+	schemaObject, _ := schema.(map[string]interface{})
+
+	if ref, ok := schemaObject["$ref"].(string); ok {
+		resolved, err := resolveRef(v.root, ref)
+		if err != nil {
+			validationErrors[path] = err.Error()
+			return
+		}
+		v.validateSchema(resolved, value, path, validationErrors, depth+1)
+	}
+
+	if message := validateType(schemaObject["type"], value); message != "" {
+		validationErrors[path] = message
+		return
+	}
+
+	if enum, ok := schemaObject["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		enumJSON, _ := json.Marshal(enum)
+		validationErrors[path] = fmt.Sprintf("value should be one of %s", enumJSON)
+		return
+	}
+
+	if constValue, ok := schemaObject["const"]; ok && !reflect.DeepEqual(constValue, value) {
+		constJSON, _ := json.Marshal(constValue)
+		validationErrors[path] = fmt.Sprintf("value should be %s", constJSON)
+		return
+	}
+
+	switch value := value.(type) {
+	case string:
+		if message := v.validateString(schemaObject, value); message != "" {
+			validationErrors[path] = message
+			return
+		}
+	case float64:
+		if message := validateNumber(schemaObject, value); message != "" {
+			validationErrors[path] = message
+			return
+		}
+	case []interface{}:
+		v.validateArray(schemaObject, value, path, validationErrors, depth)
+	case map[string]interface{}:
+		v.validateObject(schemaObject, value, path, validationErrors, depth)
+	}
+
+	if schemaObject["format"] == "geopoint" {
+		if _, _, isGeoPoint := geo.ExtractGeoPoint(value); !isGeoPoint {
+			validationErrors[path] = "value should be a geopoint"
+			return
+		}
+	}
+
+	if allOf, ok := schemaObject["allOf"].([]interface{}); ok {
+		for _, subschema := range allOf {
+			v.validateSchema(subschema, value, path, validationErrors, depth+1)
+		}
+	}
+
+	if anyOf, ok := schemaObject["anyOf"].([]interface{}); ok && v.countValid(anyOf, value, depth) == 0 {
+		validationErrors[path] = "value should match at least one schema of anyOf"
+	}
+
+	if oneOf, ok := schemaObject["oneOf"].([]interface{}); ok && v.countValid(oneOf, value, depth) != 1 {
+		validationErrors[path] = "value should match exactly one schema of oneOf"
+	}
+
+	if not, ok := schemaObject["not"]; ok && v.countValid([]interface{}{not}, value, depth) == 1 {
+		validationErrors[path] = "value should not match the schema of not"
+	}
+}
+
+func validateType(schemaType interface{}, value interface{}) string {
+	var types []interface{}
+	switch t := schemaType.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		types = t
+	default:
+		types = []interface{}{t}
+	}
+
+	for _, t := range types {
+		switch t {
+		case "null":
+			if value == nil {
+				return ""
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return ""
+			}
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return ""
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return ""
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return ""
+			}
+		case "integer":
+			if number, ok := value.(float64); ok && number == math.Trunc(number) {
+				return ""
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return ""
+			}
+		}
+	}
+
+	typesJSON, _ := json.Marshal(schemaType)
+	return fmt.Sprintf("value type should be %s", typesJSON)
+}
+
+func (v *schemaValidation) validateString(schema map[string]interface{}, value string) string {
+	length := float64(len([]rune(value)))
+
+	if minLength, ok := schema["minLength"].(float64); ok && length < minLength {
+		return fmt.Sprintf("value length should be at least %v", minLength)
+	}
+	if maxLength, ok := schema["maxLength"].(float64); ok && length > maxLength {
+		return fmt.Sprintf("value length should be at most %v", maxLength)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if !v.patterns[pattern].MatchString(value) {
+			return fmt.Sprintf("value should match the pattern %s", pattern)
+		}
+	}
+	if schema["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "cannot parse as RFC3339 time format"
+		}
+	}
+
+	return ""
+}
+
+func validateNumber(schema map[string]interface{}, value float64) string {
+	if minimum, ok := schema["minimum"].(float64); ok && value < minimum {
+		return fmt.Sprintf("value should be greater than or equal to %v", minimum)
+	}
+	if maximum, ok := schema["maximum"].(float64); ok && value > maximum {
+		return fmt.Sprintf("value should be less than or equal to %v", maximum)
+	}
+	if exclusiveMinimum, ok := schema["exclusiveMinimum"].(float64); ok && value <= exclusiveMinimum {
+		return fmt.Sprintf("value should be greater than %v", exclusiveMinimum)
+	}
+	if exclusiveMaximum, ok := schema["exclusiveMaximum"].(float64); ok && value >= exclusiveMaximum {
+		return fmt.Sprintf("value should be less than %v", exclusiveMaximum)
+	}
+	if multipleOf, ok := schema["multipleOf"].(float64); ok {
+		if quotient := value / multipleOf; quotient != math.Trunc(quotient) {
+			return fmt.Sprintf("value should be a multiple of %v", multipleOf)
+		}
+	}
+
+	return ""
+}
+
+func (v *schemaValidation) validateArray(schema map[string]interface{}, value []interface{}, path string, validationErrors map[string]string, depth int) {
+	length := float64(len(value))
+
+	if minItems, ok := schema["minItems"].(float64); ok && length < minItems {
+		validationErrors[path] = fmt.Sprintf("array should have at least %v items", minItems)
+		return
+	}
+	if maxItems, ok := schema["maxItems"].(float64); ok && length > maxItems {
+		validationErrors[path] = fmt.Sprintf("array should have at most %v items", maxItems)
+		return
+	}
+	if uniqueItems, _ := schema["uniqueItems"].(bool); uniqueItems {
+		for i := range value {
+			if containsValue(value[:i], value[i]) {
+				validationErrors[path] = "array items should be unique"
+				return
+			}
+		}
+	}
+	if contains, ok := schema["contains"]; ok {
+		isContained := false
+		for _, item := range value {
+			if v.countValid([]interface{}{contains}, item, depth) == 1 {
+				isContained = true
+				break
+			}
+		}
+		if !isContained {
+			validationErrors[path] = "array should contain an item matching the schema of contains"
+			return
+		}
+	}
+
+	prefixItems, _ := schema["prefixItems"].([]interface{})
+	for i, item := range value {
+		itemPath := joinPath(path, strconv.Itoa(i))
+		if i < len(prefixItems) {
+			v.validateSchema(prefixItems[i], item, itemPath, validationErrors, depth+1)
+		} else if items, ok := schema["items"]; ok {
+			v.validateSchema(items, item, itemPath, validationErrors, depth+1)
+		}
+	}
+}
+
+func (v *schemaValidation) validateObject(schema map[string]interface{}, value map[string]interface{}, path string, validationErrors map[string]string, depth int) {
+	length := float64(len(value))
+
+	if minProperties, ok := schema["minProperties"].(float64); ok && length < minProperties {
+		validationErrors[path] = fmt.Sprintf("object should have at least %v properties", minProperties)
+	}
+	if maxProperties, ok := schema["maxProperties"].(float64); ok && length > maxProperties {
+		validationErrors[path] = fmt.Sprintf("object should have at most %v properties", maxProperties)
+	}
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, property := range required {
+			if propertyName, _ := property.(string); propertyName != "" {
+				if _, ok := value[propertyName]; !ok {
+					validationErrors[joinPath(path, propertyName)] = "field is required"
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	patternProperties, _ := schema["patternProperties"].(map[string]interface{})
+
+	// sorted for the deterministic violation messages
+	var propertyNames []string
+	for propertyName := range value {
+		propertyNames = append(propertyNames, propertyName)
+	}
+	sort.Strings(propertyNames)
+
+	for _, propertyName := range propertyNames {
+		propertyPath := joinPath(path, propertyName)
+		isEvaluated := false
+
+		if propertySchema, ok := properties[propertyName]; ok {
+			v.validateSchema(propertySchema, value[propertyName], propertyPath, validationErrors, depth+1)
+			isEvaluated = true
+		}
+
+		for pattern, propertySchema := range patternProperties {
+			if v.patterns[pattern].MatchString(propertyName) {
+				v.validateSchema(propertySchema, value[propertyName], propertyPath, validationErrors, depth+1)
+				isEvaluated = true
+			}
+		}
+
+		if additionalProperties, ok := schema["additionalProperties"]; ok && !isEvaluated {
+			if isAllowed, ok := additionalProperties.(bool); ok && !isAllowed {
+				validationErrors[propertyPath] = "field is not defined in the schema"
+			} else {
+				v.validateSchema(additionalProperties, value[propertyName], propertyPath, validationErrors, depth+1)
+			}
+		}
+	}
+}
+
+// countValid returns how many of the schemas the value is valid against
+func (v *schemaValidation) countValid(schemas []interface{}, value interface{}, depth int) int {
+	valid := 0
+	for _, subschema := range schemas {
+		subschemaErrors := make(map[string]string)
+		v.validateSchema(subschema, value, "", subschemaErrors, depth+1)
+		if len(subschemaErrors) == 0 {
+			valid++
+		}
+	}
+
+	return valid
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, element := range values {
+		if reflect.DeepEqual(element, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinPath builds the field path of the violations, e.g. address.city or tags.0
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
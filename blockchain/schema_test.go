@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+var schemaJSON = `
+{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"properties": {
+		"id": {"type": "string", "pattern": "^[a-z0-9-]+$"},
+		"age": {"type": ["integer", "null"], "minimum": 0},
+		"created": {"type": "string", "format": "date-time"},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+		"address": {"$ref": "#/$defs/address"},
+		"location": {"type": "object", "format": "geopoint"},
+		"kind": {"oneOf": [{"const": "person"}, {"const": "company"}]}
+	},
+	"required": ["id"],
+	"additionalProperties": false,
+	"$defs": {
+		"address": {
+			"type": "object",
+			"properties": {"city": {"type": "string", "minLength": 1}},
+			"required": ["city"]
+		}
+	}
+}
+`
+
+func TestDeriveFields(t *testing.T) {
+	schema, err := parseSchema([]byte(schemaJSON))
+	if err != nil {
+		t.Fatalf("parseSchema expected no error, actual: %s", err)
+	}
+
+	fields, _ := deriveFields(schema.root)
+	expectedTypes := map[string]string{"id": "text", "age": "number", "created": "datetime", "tags": "text", "location": "geopoint"}
+
+	if len(fields) != len(expectedTypes) {
+		t.Errorf("deriveFields expected %d fields, actual: %v", len(expectedTypes), fields)
+	}
+	for field, expectedType := range expectedTypes {
+		if fieldMapping, _ := fields[field].(map[string]interface{}); fieldMapping["type"] != expectedType {
+			t.Errorf("field %s expected type: %s, actual: %v", field, expectedType, fields[field])
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	documentMapping := DocumentMapping{Collection: "c", Schema: []byte(schemaJSON)}
+
+	validationErrors, _ := documentMapping.Validate([]byte(`{"id": "a-1", "age": null, "created": "2020-01-01T00:00:00Z", "tags": ["x", "y"], "address": {"city": "Toronto"}, "location": {"lat": 43.6, "lon": -79.4}, "kind": "person"}`))
+	if validationErrors != nil {
+		t.Errorf("valid document expected no errors, actual: %v", validationErrors)
+	}
+
+	validationErrors, _ = documentMapping.Validate([]byte(`{"age": 1.5, "created": "now", "tags": ["x", "x"], "address": {}, "location": "here", "kind": "robot", "extra": true}`))
+	expectedPaths := []string{"id", "age", "created", "tags", "address.city", "location", "kind", "extra"}
+	if len(validationErrors) != len(expectedPaths) {
+		t.Errorf("invalid document expected %d errors, actual: %v", len(expectedPaths), validationErrors)
+	}
+	for _, path := range expectedPaths {
+		if validationErrors[path] == "" {
+			t.Errorf("expected a violation at %s, actual: %v", path, validationErrors)
+		}
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	invalidSchemas := []string{
+		`[]`,
+		`{"type": "text"}`,
+		`{"properties": {"id": {"pattern": "("}}}`,
+		`{"properties": {"address": {"$ref": "#/$defs/missing"}}}`,
+		`{"properties": {"address": {"$ref": "https://example.com/address.json"}}}`,
+		`{"anyOf": []}`,
+		`{"multipleOf": 0}`,
+	}
+
+	for _, schema := range invalidSchemas {
+		if _, err := parseSchema([]byte(schema)); err == nil {
+			t.Errorf("parseSchema expected an error for %s", schema)
+		}
+	}
+}
+
+func TestCompiledSchemaCache(t *testing.T) {
+	documentMapping := DocumentMapping{Collection: "cached", Schema: []byte(schemaJSON), LastModified: 1}
+
+	schema, err := documentMapping.getCompiledSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := documentMapping.getCompiledSchema(); cached != schema {
+		t.Error("the schema should be compiled once for a version of the mapping")
+	}
+
+	documentMapping.LastModified = 2
+	if modified, _ := documentMapping.getCompiledSchema(); modified == schema {
+		t.Error("the schema should be compiled again when the mapping is modified")
+	}
+}
+
+func TestValidateSchemaSteps(t *testing.T) {
+	// each level of the recursive anyOf doubles the schemas to evaluate
+	documentMapping := DocumentMapping{Collection: "steps", Schema: []byte(`{
+		"$defs": {"node": {"anyOf": [{"$ref": "#/$defs/node"}, {"$ref": "#/$defs/node"}, {"type": "string"}]}},
+		"properties": {"a": {"$ref": "#/$defs/node"}}
+	}`)}
+
+	done := make(chan map[string]string)
+	go func() {
+		validationErrors, _ := documentMapping.Validate([]byte(`{"a": 1}`))
+		done <- validationErrors
+	}()
+
+	select {
+	case validationErrors := <-done:
+		if validationErrors["_document"] == "" {
+			t.Errorf("the validation should stop at the step limit, actual: %v", validationErrors)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the validation should be bounded by the step limit")
+	}
+}
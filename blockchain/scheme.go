@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The schemes to sign the messages of documents, batches and challenge words with. Browser wallets can't sign the plain keccak256 of a message
+const (
+	SignatureSchemeKeccak256 = ""       // the keccak256 of the message
+	SignatureSchemeEIP191    = "eip191" // personal_sign: the keccak256 of "\x19Ethereum Signed Message:\n", the length of the message and the message
+	SignatureSchemeEIP712    = "eip712" // eth_signTypedData_v4: the typed data in the Blocace domain
+)
+
+// The EIP-712 domain and types. A permittedAddresses member is the lowercased, sorted and comma separated addresses including the author
+const (
+	EIP712DomainName    = "Blocace"
+	EIP712DomainVersion = "1"
+	eip712DomainType    = "EIP712Domain(string name,string version)"
+	eip712DocumentType  = "Document(string collection,uint256 nonce,uint256 expiresAt,string permittedAddresses,string document)"
+	eip712BatchType     = "Batch(string collection,uint256 nonce,uint256 expiresAt,string permittedAddresses,bytes32 merkleRoot)"
+	eip712ChallengeType = "Challenge(string challengeWord)"
+)
+
+// CheckSignatureScheme rejects a signature scheme which is not supported
+func CheckSignatureScheme(scheme string) error {
+	if scheme != SignatureSchemeKeccak256 && scheme != SignatureSchemeEIP191 && scheme != SignatureSchemeEIP712 {
+		return fmt.Errorf("unsupported signature scheme: %s. Must be empty (keccak256), %s or %s", scheme, SignatureSchemeEIP191, SignatureSchemeEIP712)
+	}
+
+	return nil
+}
+
+// DocumentDigest returns the digest a signature in the scheme signs for a document in the envelope. The message is SigningMessage for keccak256 and EIP-191,
+// or the Document typed data for EIP-712. Unlike keccak256, a wallet scheme signs the envelope even if it is unbound
+func (e Envelope) DocumentDigest(scheme string, rawData []byte, authorAddress string) ([]byte, error) {
+	switch scheme {
+	case SignatureSchemeKeccak256:
+		return crypto.Keccak256(e.SigningMessage(rawData, authorAddress)), nil
+	case SignatureSchemeEIP191:
+		return personalMessageDigest(e.SigningMessage(rawData, authorAddress)), nil
+	case SignatureSchemeEIP712:
+		if e.ExpiresAt < 0 {
+			return nil, fmt.Errorf("invalid expiresAt: %d", e.ExpiresAt)
+		}
+		return typedDataDigest(eip712DocumentType, encodeTypedString(e.Collection), encodeTypedUint(e.Nonce), encodeTypedUint(uint64(e.ExpiresAt)),
+			encodeTypedString(e.normalizedAddresses(authorAddress)), encodeTypedString(string(rawData))), nil
+	}
+
+	return nil, fmt.Errorf("unsupported signature scheme: %s", scheme)
+}
+
+// BatchDigest returns the digest a signature in the scheme signs for a batch in the envelope. The message is BatchSigningMessage for keccak256 and EIP-191,
+// or the Batch typed data for EIP-712
+func (e Envelope) BatchDigest(scheme string, merkleRoot []byte, authorAddress string) ([]byte, error) {
+	switch scheme {
+	case SignatureSchemeKeccak256:
+		return crypto.Keccak256(e.BatchSigningMessage(merkleRoot, authorAddress)), nil
+	case SignatureSchemeEIP191:
+		return personalMessageDigest(e.BatchSigningMessage(merkleRoot, authorAddress)), nil
+	case SignatureSchemeEIP712:
+		if e.ExpiresAt < 0 {
+			return nil, fmt.Errorf("invalid expiresAt: %d", e.ExpiresAt)
+		}
+		return typedDataDigest(eip712BatchType, encodeTypedString(e.Collection), encodeTypedUint(e.Nonce), encodeTypedUint(uint64(e.ExpiresAt)),
+			encodeTypedString(e.normalizedAddresses(authorAddress)), merkleRoot), nil
+	}
+
+	return nil, fmt.Errorf("unsupported signature scheme: %s", scheme)
+}
+
+// ChallengeDigest returns the digest a signature in the scheme signs for a JWT challenge word. The message is the challenge word,
+// or the Challenge typed data for EIP-712
+func ChallengeDigest(scheme string, challengeWord string) ([]byte, error) {
+	switch scheme {
+	case SignatureSchemeKeccak256:
+		return crypto.Keccak256([]byte(challengeWord)), nil
+	case SignatureSchemeEIP191:
+		return personalMessageDigest([]byte(challengeWord)), nil
+	case SignatureSchemeEIP712:
+		return typedDataDigest(eip712ChallengeType, encodeTypedString(challengeWord)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported signature scheme: %s", scheme)
+}
+
+// RecoverPubKey recovers the secp256k1 public key which signed a digest from a recoverable signature of [R || S || V] format. V is 0 or 1, or 27 or 28 as wallets sign
+func RecoverPubKey(digest []byte, signature []byte) ([]byte, error) {
+	if len(signature) != 65 {
+		return nil, fmt.Errorf("the signature is not recoverable: %d bytes instead of 65", len(signature))
+	}
+
+	recoverableSignature := append([]byte{}, signature...)
+	if recoverableSignature[64] >= 27 {
+		recoverableSignature[64] -= 27
+	}
+	if recoverableSignature[64] > 1 {
+		return nil, fmt.Errorf("invalid recovery id: %d", signature[64])
+	}
+
+	publicKey, err := crypto.SigToPub(digest, recoverableSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.FromECDSAPub(publicKey), nil
+}
+
+func personalMessageDigest(message []byte) []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(message))), message)
+}
+
+// typedDataDigest is the keccak256 of "\x19\x01", the domain separator and the hash of the struct of the type with the encoded members
+func typedDataDigest(structType string, encodedMembers ...[]byte) []byte {
+	domainSeparator := crypto.Keccak256(crypto.Keccak256([]byte(eip712DomainType)), encodeTypedString(EIP712DomainName), encodeTypedString(EIP712DomainVersion))
+	structHash := crypto.Keccak256(append([][]byte{crypto.Keccak256([]byte(structType))}, encodedMembers...)...)
+
+	return crypto.Keccak256([]byte("\x19\x01"), domainSeparator, structHash)
+}
+
+func encodeTypedString(value string) []byte {
+	return crypto.Keccak256([]byte(value))
+}
+
+// encodeTypedUint encodes a uint256 member as 32 bytes in big endian
+func encodeTypedUint(value uint64) []byte {
+	encoded := make([]byte, 32)
+	binary.BigEndian.PutUint64(encoded[24:], value)
+	return encoded
+}
@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignatureScheme(t *testing.T) {
+	privateKey, _ := crypto.GenerateKey()
+	publicKey := crypto.FromECDSAPub(&privateKey.PublicKey)
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).String()
+	rawData := []byte(`{"id":1}`)
+	envelope := Envelope{Collection: "default", Nonce: 3, ExpiresAt: 1700000000000}
+
+	if err := CheckSignatureScheme("eip999"); err == nil {
+		t.Error("an unsupported scheme should be rejected")
+	}
+
+	// EIP-191 prefixes the message with its length as personal_sign does
+	message := envelope.SigningMessage(rawData, address)
+	digest, _ := envelope.DocumentDigest(SignatureSchemeEIP191, rawData, address)
+	if !bytes.Equal(digest, crypto.Keccak256(append([]byte("\x19Ethereum Signed Message:\n"+strconv.Itoa(len(message))), message...))) {
+		t.Error("the EIP-191 digest should be of the prefixed message")
+	}
+
+	challengeDigest, _ := ChallengeDigest(SignatureSchemeEIP191, "hello")
+	if !bytes.Equal(challengeDigest, crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n5hello"))) {
+		t.Error("the EIP-191 challenge digest should be of the prefixed challenge word")
+	}
+
+	// EIP-712 hashes the typed data in the Blocace domain
+	domainSeparator := crypto.Keccak256(crypto.Keccak256([]byte("EIP712Domain(string name,string version)")), crypto.Keccak256([]byte("Blocace")), crypto.Keccak256([]byte("1")))
+	structHash := crypto.Keccak256(crypto.Keccak256([]byte("Challenge(string challengeWord)")), crypto.Keccak256([]byte("hello")))
+	challengeDigest, _ = ChallengeDigest(SignatureSchemeEIP712, "hello")
+	if !bytes.Equal(challengeDigest, crypto.Keccak256([]byte("\x19\x01"), domainSeparator, structHash)) {
+		t.Error("the EIP-712 challenge digest should be of the typed data")
+	}
+
+	for _, scheme := range []string{SignatureSchemeKeccak256, SignatureSchemeEIP191, SignatureSchemeEIP712} {
+		digest, err := envelope.DocumentDigest(scheme, rawData, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx := NewTransaction([]byte("peer"), rawData, envelope.Collection, publicKey, Sign(*privateKey, digest), []string{address})
+		tx.Nonce = envelope.Nonce
+		tx.ExpiresAt = envelope.ExpiresAt
+		tx.SignatureScheme = scheme
+		if !IsValidTransactionSig(tx) {
+			t.Errorf("the signature in the scheme %q should be valid", scheme)
+		}
+
+		// the scheme is part of what is verified
+		tx.SignatureScheme = SignatureSchemeEIP712
+		if scheme != SignatureSchemeEIP712 && IsValidTransactionSig(tx) {
+			t.Errorf("the signature in the scheme %q should not be valid in another scheme", scheme)
+		}
+	}
+
+	// a wallet signs the envelope of an unbound document too
+	digest, _ = Envelope{Collection: "default"}.DocumentDigest(SignatureSchemeEIP191, rawData, address)
+	tx := NewTransaction([]byte("peer"), rawData, "default", publicKey, Sign(*privateKey, digest), nil)
+	tx.SignatureScheme = SignatureSchemeEIP191
+	if !IsValidTransactionSig(tx) {
+		t.Error("the EIP-191 signature of an unbound document should be valid")
+	}
+}
+
+func TestRecoverPubKey(t *testing.T) {
+	privateKey, _ := crypto.GenerateKey()
+	publicKey := crypto.FromECDSAPub(&privateKey.PublicKey)
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).String()
+	digest := crypto.Keccak256([]byte("hello"))
+
+	signature := Sign(*privateKey, digest)
+	if recovered, err := RecoverPubKey(digest, signature); err != nil || !bytes.Equal(recovered, publicKey) {
+		t.Errorf("the public key should be recovered: %v", err)
+	}
+
+	// as wallets sign
+	signature[64] += 27
+	if recovered, err := RecoverPubKey(digest, signature); err != nil || !bytes.Equal(recovered, publicKey) {
+		t.Errorf("the public key should be recovered with V of 27 or 28: %v", err)
+	}
+
+	signature[64] = 5
+	if _, err := RecoverPubKey(digest, signature); err == nil {
+		t.Error("an invalid recovery id should be rejected")
+	}
+	if _, err := RecoverPubKey(digest, signature[:64]); err == nil {
+		t.Error("a signature without V should not be recoverable")
+	}
+
+	// the address of a transaction without its public key
+	envelope := Envelope{Collection: "default", Nonce: 1, PermittedAddresses: []string{address}}
+	tx := NewTransaction([]byte("peer"), []byte(`{"id":1}`), envelope.Collection, nil, nil, envelope.PermittedAddresses)
+	tx.Nonce = envelope.Nonce
+	digest, _ = TransactionDigest(tx, address)
+	tx.Signature = Sign(*privateKey, digest)
+	if transactionAddress, err := TransactionAddress(tx); err != nil || transactionAddress != address {
+		t.Errorf("the address should be recovered from the signature: %s %v", transactionAddress, err)
+	}
+
+	if transactionAddress, err := TransactionAddress(NewCoinbaseTX([]byte("peer"))); err != nil || transactionAddress != "" {
+		t.Errorf("the address of the genesis transaction should be empty: %s %v", transactionAddress, err)
+	}
+
+	unsigned := NewTransaction([]byte("peer"), []byte(`{"id":1}`), "default", nil, nil, nil)
+	unsigned.Address = address
+	if transactionAddress, err := TransactionAddress(unsigned); err != nil || transactionAddress != "" {
+		t.Errorf("an unsigned transaction should not claim an author: %s %v", transactionAddress, err)
+	}
+}
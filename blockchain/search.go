@@ -4,15 +4,19 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
 	"github.com/blevesearch/bleve/index/scorch"
+	"github.com/blevesearch/bleve/mapping"
 	"github.com/boltdb/bolt"
 
 	log "github.com/sirupsen/logrus"
@@ -25,18 +29,36 @@ type Search struct {
 	sync.Mutex
 	db                *bolt.DB
 	indexDirRoot      string
-	BlockchainIndices map[string]bleve.Index
+	BlockchainIndices map[string]bleve.Index // the active collections
+	archivedIndices   map[string]bleve.Index // the read-only and unsearchable collections
+	aliases           map[string]string      // alias -> collection
+	indexListeners    []IndexListener
+	mappingsLock      sync.RWMutex
+	mappings          map[string]*DocumentMapping // the decoded mappings of the collections bucket, with their patterns compiled
+	indexedHeights    map[string][]HeightRange    // peerId -> the heights of the indexed blocks, see getIndexedHeights
 }
 
+// IndexListener is called after all the txs of a block (local or peer) have been indexed. It must not block
+type IndexListener func(block *Block, peerId []byte)
+
 // Document represents a document with metadata in the search result
 type Document struct {
-	ID           string `json:"_id"`
-	BlockID      string `json:"_blockId"`
-	BlockchainId string `json:"_blockchainId"` // peerId
-	Source       string `json:"_source"`
-	Timestamp    string `json:"_timestamp"`
-	Signature    string `json:"_signature"`
-	Address      string `json:"_address"` // Issuer address
+	ID                 string   `json:"_id"`
+	BlockID            string   `json:"_blockId"`
+	BlockchainId       string   `json:"_blockchainId"` // peerId
+	Collection         string   `json:"_type"`
+	Source             string   `json:"_source"`
+	Timestamp          string   `json:"_timestamp"`
+	Signature          string   `json:"_signature"`
+	Address            string   `json:"_address"`            // Issuer address
+	Redacted           bool     `json:"_redacted,omitempty"` // some sensitive fields are stripped or masked. The original is needed to verify the signature
+	Score              float64  `json:"_score,omitempty"`    // the relevance to the search query
+	Nonce              uint64   `json:"_nonce,omitempty"`    // the signature covers the envelope with the nonce, expiry and permitted addresses if either is set
+	ExpiresAt          int64    `json:"_expiresAt,omitempty"`
+	PermittedAddresses []string `json:"_permittedAddresses,omitempty"`
+	SignatureScheme    string   `json:"_signatureScheme,omitempty"` // eip191 or eip712 if the signature is not of the keccak256 of the message
+	Principal          string   `json:"_principal,omitempty"`       // the author, on whose behalf the delegate signed the document
+	Delegate           string   `json:"_delegate,omitempty"`        // the address whose key signed the document for the principal
 }
 
 // NewSearch create an instance to access the search features
@@ -59,7 +81,7 @@ func NewSearch(db *bolt.DB, dataDir string) (*Search, error) {
 		}
 		`
 
-		search := Search{db: db, indexDirRoot: indexDirRoot, BlockchainIndices: blockchainIndices}
+		search := Search{db: db, indexDirRoot: indexDirRoot, BlockchainIndices: blockchainIndices, archivedIndices: make(map[string]bleve.Index), aliases: make(map[string]string), mappings: make(map[string]*DocumentMapping), indexedHeights: make(map[string][]HeightRange)}
 
 		defaultIndex, err := search.CreateMappingByJson([]byte(jsonSchema))
 
@@ -92,56 +114,108 @@ func NewSearch(db *bolt.DB, dataDir string) (*Search, error) {
 		}
 	}
 
-	return &Search{db: db, indexDirRoot: indexDirRoot, BlockchainIndices: blockchainIndices}, nil
+	search := &Search{db: db, indexDirRoot: indexDirRoot, BlockchainIndices: blockchainIndices, archivedIndices: make(map[string]bleve.Index), aliases: make(map[string]string), mappings: make(map[string]*DocumentMapping), indexedHeights: make(map[string][]HeightRange)}
+	if err = search.loadCollectionStates(); err != nil {
+		return nil, err
+	}
+
+	return search, nil
+}
+
+// AddIndexListener registers a listener to be notified of the newly indexed blocks
+func (s *Search) AddIndexListener(listener IndexListener) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.indexListeners = append(s.indexListeners, listener)
 }
 
 // IndexBlock index all the txs in a block
 func (s *Search) IndexBlock(block *Block, peerId []byte) {
+	s.IndexFlaggedBlock(block, peerId, nil)
+}
+
+// IndexFlaggedBlock index all the txs in a block. The flags of the documents by transaction ID are indexed as _flag, e.g. a peer document which fails the local validation
+func (s *Search) IndexFlaggedBlock(block *Block, peerId []byte, flags map[string]string) {
 	s.Lock()
 	defer s.Unlock()
-	var jsonDoc map[string]interface{}
 
-	// using batch index for better performance
-	indexBatches := make(map[string]*bleve.Batch)
-	for collection, index := range s.BlockchainIndices {
-		indexBatches[collection] = index.NewBatch()
+	// using batch index for better performance. The archived collections are kept up-to-date to be restored
+	indices := make(map[string]bleve.Index)
+	for _, collectionIndices := range []map[string]bleve.Index{s.BlockchainIndices, s.archivedIndices} {
+		for collection, index := range collectionIndices {
+			indices[collection] = index
+		}
 	}
+	indexBatches := make(map[string]*bleve.Batch) // of the collections with documents in the block
 
 	for _, tx := range block.Transactions {
 		// do not index the doc where there is no index exists for it
-		if nil == s.BlockchainIndices[tx.Collection] {
+		if nil == indices[tx.Collection] {
 			//log.Println("The collection " + tx.Collection + " doesn't exist... Skipped the indexing.")
 			continue
 		}
 
 		// parse bytes as json
+		var jsonDoc map[string]interface{}
 		err := json.Unmarshal(tx.RawData, &jsonDoc)
 
 		if err != nil {
 			log.Errorf("error indexing tx with ID %x: %s", tx.ID, err)
+			continue
 		}
 
 		// all searchable system fields
 		jsonDoc["_type"] = tx.Collection
 		jsonDoc["_blockId"] = fmt.Sprintf("%x", tx.BlockHash)
+		jsonDoc["_blockHeight"] = block.Height
 		jsonDoc["_timestamp"] = time.Unix(0, tx.AcceptedTimestamp*int64(time.Millisecond)).Format(time.RFC3339)
 		jsonDoc["_publicKey"] = fmt.Sprintf("%x", tx.PubKey)
 		jsonDoc["_id"] = fmt.Sprintf("%x", tx.ID)
 		jsonDoc["_peerId"] = fmt.Sprintf("%x", peerId)
 		jsonDoc["_permittedAddresses"] = tx.PermittedAddresses
+		if flag, ok := flags[string(tx.ID)]; ok {
+			jsonDoc["_flag"] = flag
+		}
 
+		if indexBatches[tx.Collection] == nil {
+			indexBatches[tx.Collection] = indices[tx.Collection].NewBatch()
+		}
 		indexBatches[tx.Collection].Index(string(append(append(block.Hash, []byte("_")...), tx.ID...)), jsonDoc)
 	}
 
 	for collection, batch := range indexBatches {
-		s.BlockchainIndices[collection].Batch(batch)
+		s.setLastIndexedBlock(indices[collection], batch, block, peerId)
+	}
+
+	// the lag of all the collections is of the blocks indexed
+	if indexBatches[indexDefault] == nil {
+		indexBatches[indexDefault] = indices[indexDefault].NewBatch()
+	}
+	if err := s.addIndexedHeight(indexBatches[indexDefault], block.Height, peerId); err != nil {
+		log.Errorf("error recording the height of block %x: %s", block.Hash, err)
+	}
+
+	for collection, batch := range indexBatches {
+		indices[collection].Batch(batch)
+	}
+
+	for _, listener := range s.indexListeners {
+		listener(block, peerId)
 	}
 }
 
 // DocumentMapping represents the schema of a collection
 type DocumentMapping struct {
-	Collection string                 `json:"collection"`
-	Fields     map[string]interface{} `json:"fields"`
+	Collection   string                 `json:"collection"`
+	Fields       map[string]interface{} `json:"fields"`
+	Strict       bool                   `json:"strict,omitempty"`  // reject the fields not defined in the mapping
+	Schema       json.RawMessage        `json:"schema,omitempty"`  // JSON Schema the fields are derived from and the documents are validated against
+	State        string                 `json:"state,omitempty"`   // active (empty), archived or dropped
+	AliasOf      string                 `json:"aliasOf,omitempty"` // the collection this alias points to
+	LastModified int64                  `json:"lastModified"`
+
+	patterns map[string]*regexp.Regexp // the compiled patterns of the fields, see compilePatterns
 }
 
 // Serialize serializes the transaction
@@ -152,6 +226,7 @@ func (dm DocumentMapping) Serialize() []byte {
 		"id": "{\"type\": \"text\"}",
 	}
 	gob.Register(mappingExpression)
+	gob.Register([]interface{}{}) // enum
 
 	encoder := gob.NewEncoder(&result)
 	err := encoder.Encode(dm)
@@ -170,6 +245,7 @@ func DeserializeDocumentMapping(a []byte) *DocumentMapping {
 		"id": "{\"type\": \"text\"}",
 	}
 	gob.Register(mappingExpression)
+	gob.Register([]interface{}{}) // enum
 
 	decoder := gob.NewDecoder(bytes.NewReader(a))
 	err := decoder.Decode(&dm)
@@ -182,10 +258,44 @@ func DeserializeDocumentMapping(a []byte) *DocumentMapping {
 
 // CreateMapping creates the data schema for a specific collection.
 func (s *Search) CreateMapping(documentMapping DocumentMapping) (bleve.Index, error) {
+	if len(documentMapping.Schema) > 0 {
+		schema, err := parseSchema(documentMapping.Schema)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"method": "CreateMapping()",
+			}).Error(err)
+			return nil, err
+		}
+
+		if documentMapping.Fields, err = deriveFields(schema.root); err != nil {
+			log.WithFields(log.Fields{
+				"method": "CreateMapping()",
+			}).Error(err)
+			return nil, err
+		}
+	}
+
+	if err := documentMapping.checkConstraints(); err != nil {
+		log.WithFields(log.Fields{
+			"method": "CreateMapping()",
+		}).Error(err)
+		return nil, err
+	}
+
 	// a generic reusable mapping for text
 	textFieldMapping := bleve.NewTextFieldMapping()
 	textFieldMapping.Store = false
 
+	// a generic reusable mapping for identifiers which are matched as a whole
+	keywordFieldMapping := bleve.NewTextFieldMapping()
+	keywordFieldMapping.Analyzer = keyword.Name
+	keywordFieldMapping.Store = false
+
+	// _peerId is stored to route the search hits to their blockchain dbs
+	peerIdFieldMapping := bleve.NewTextFieldMapping()
+	peerIdFieldMapping.Analyzer = keyword.Name
+	peerIdFieldMapping.Store = true
+
 	// a generic reusable mapping for datetime
 	dateTimeFieldMapping := bleve.NewDateTimeFieldMapping()
 	dateTimeFieldMapping.Store = false
@@ -194,6 +304,10 @@ func (s *Search) CreateMapping(documentMapping DocumentMapping) (bleve.Index, er
 	numericFieldMapping := bleve.NewNumericFieldMapping()
 	numericFieldMapping.Store = false
 
+	// _blockHeight is stored to tell the position of a document in its blockchain
+	blockHeightFieldMapping := bleve.NewNumericFieldMapping()
+	blockHeightFieldMapping.Store = true
+
 	// a generic reusable mapping for boolean
 	booleanFieldMapping := bleve.NewBooleanFieldMapping()
 	booleanFieldMapping.Store = false
@@ -212,31 +326,42 @@ func (s *Search) CreateMapping(documentMapping DocumentMapping) (bleve.Index, er
 
 		fieldType := v.(map[string]interface{})
 
+		var fieldMapping *mapping.FieldMapping
 		switch fieldType["type"] {
 		case "text":
-			collectionSchema.AddFieldMappingsAt(fieldName, textFieldMapping)
+			fieldMapping = textFieldMapping
 		case "number":
-			collectionSchema.AddFieldMappingsAt(fieldName, numericFieldMapping)
+			fieldMapping = numericFieldMapping
 		case "datetime":
-			collectionSchema.AddFieldMappingsAt(fieldName, dateTimeFieldMapping)
+			fieldMapping = dateTimeFieldMapping
 		case "boolean":
-			collectionSchema.AddFieldMappingsAt(fieldName, booleanFieldMapping)
+			fieldMapping = booleanFieldMapping
 		case "geopoint":
-			collectionSchema.AddFieldMappingsAt(fieldName, geoPointFieldMapping)
+			fieldMapping = geoPointFieldMapping
 		default:
 			log.Errorf("The data type: %s for field: %s is not valid.", fieldType["type"], fieldName)
 			return nil, fmt.Errorf("the data type: %s for field: %s is not valid", fieldType["type"], fieldName)
 		}
+
+		// a sensitive field is left out of the composite field _all, so that a query without field doesn't match its value
+		if isSensitive, _ := fieldType["sensitive"].(bool); isSensitive {
+			sensitiveFieldMapping := *fieldMapping
+			sensitiveFieldMapping.IncludeInAll = false
+			fieldMapping = &sensitiveFieldMapping
+		}
+		collectionSchema.AddFieldMappingsAt(fieldName, fieldMapping)
 	}
 
 	// System fields
 	collectionSchema.AddFieldMappingsAt("_blockId", textFieldMapping)
+	collectionSchema.AddFieldMappingsAt("_blockHeight", blockHeightFieldMapping)
 	collectionSchema.AddFieldMappingsAt("_publicKey", textFieldMapping)
 	collectionSchema.AddFieldMappingsAt("_timestamp", dateTimeFieldMapping)
-	collectionSchema.AddFieldMappingsAt("_type", textFieldMapping)
+	collectionSchema.AddFieldMappingsAt("_type", keywordFieldMapping)
 	collectionSchema.AddFieldMappingsAt("_id", textFieldMapping) // transaction ID
-	collectionSchema.AddFieldMappingsAt("_peerId", textFieldMapping)
+	collectionSchema.AddFieldMappingsAt("_peerId", peerIdFieldMapping)
 	collectionSchema.AddFieldMappingsAt("_permittedAddresses", textFieldMapping)
+	collectionSchema.AddFieldMappingsAt("_flag", keywordFieldMapping) // why a peer document didn't pass the local checks
 
 	indexMapping := bleve.NewIndexMapping()
 	indexMapping.AddDocumentMapping(documentMapping.Collection, collectionSchema)
@@ -255,25 +380,7 @@ func (s *Search) CreateMapping(documentMapping DocumentMapping) (bleve.Index, er
 		return nil, err
 	}
 
-	err = s.db.Update(func(dbtx *bolt.Tx) error {
-		collectionBucket, err := dbtx.CreateBucketIfNotExists([]byte(CollectionsBucket))
-
-		if err != nil {
-			return err
-		}
-
-		err = collectionBucket.Put([]byte(documentMapping.Collection), documentMapping.Serialize())
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		log.WithFields(log.Fields{
-			"method": "CreateMapping()",
-		}).Error(err)
+	if err = s.saveMapping(documentMapping); err != nil {
 		return nil, err
 	}
 
@@ -287,12 +394,31 @@ func (s *Search) CreateMapping(documentMapping DocumentMapping) (bleve.Index, er
 // {
 //     "collection": "new_collection",
 //     "fields": {
-//         "id": {"type": "text"},
-//         "title": {"type": "text"},
-//         "age": {"type": "number"},
+//         "id": {"type": "text", "required": true, "pattern": "^[a-z0-9-]+$"},
+//         "title": {"type": "text", "minLength": 1, "maxLength": 200},
+//         "status": {"type": "text", "enum": ["draft", "published"]},
+//         "age": {"type": "number", "min": 0, "max": 150},
 //         "created": {"type": "datetime"},
 //         "isModified": {"type": "boolean"},
-//         "location": {"type": "geopoint"}
+//         "location": {"type": "geopoint"},
+//         "ssn": {"type": "text", "sensitive": true, "mask": "***-**-****"}
+//     },
+//     "strict": true
+// }
+// Or defined in JSON Schema (draft 2020-12 subset), where the fields are derived from the top level properties:
+// {
+//     "collection": "new_collection",
+//     "schema": {
+//         "$schema": "https://json-schema.org/draft/2020-12/schema",
+//         "type": "object",
+//         "properties": {
+//             "id": {"type": "string"},
+//             "age": {"type": "integer", "minimum": 0},
+//             "created": {"type": "string", "format": "date-time"},
+//             "tags": {"type": "array", "items": {"type": "string"}},
+//             "location": {"type": "object", "format": "geopoint"}
+//         },
+//         "required": ["id"]
 //     }
 // }
 func (s *Search) CreateMappingByJson(mappingJSON []byte) (bleve.Index, error) {
@@ -303,16 +429,63 @@ func (s *Search) CreateMappingByJson(mappingJSON []byte) (bleve.Index, error) {
 		return nil, err
 	}
 
-	if len(documentMapping.Collection) == 0 || documentMapping.Fields == nil {
+	if len(documentMapping.Collection) == 0 || (documentMapping.Fields == nil) == (len(documentMapping.Schema) == 0) {
 		log.Errorf("%s is not a valid collection schema definition\n", mappingJSON)
 		return nil, fmt.Errorf("%s is not a valid collection schema definition", mappingJSON)
 	}
 
-	if nil != s.BlockchainIndices[documentMapping.Collection] {
+	if s.collectionExists(documentMapping.Collection) {
 		log.Warnf("the collection " + documentMapping.Collection + " already exists. Nothing to do.")
 		return nil, fmt.Errorf("the collection %s already exists. Nothing to do", documentMapping.Collection)
 	}
 
+	// a dropped collection can be created again
+	documentMapping.State = CollectionActive
+	documentMapping.AliasOf = ""
+	documentMapping.LastModified = time.Now().UnixNano() / 1000000
+
 	return s.CreateMapping(documentMapping)
 
 }
+
+// GetDocumentMapping returns the mapping of a collection
+func (s *Search) GetDocumentMapping(collection string) (*DocumentMapping, error) {
+	// a copy, so that the callers can modify it
+	s.mappingsLock.RLock()
+	cached := s.mappings[collection]
+	s.mappingsLock.RUnlock()
+	if cached != nil {
+		documentMapping := *cached
+		return &documentMapping, nil
+	}
+
+	// saveMapping can't replace the mapping between reading and caching it
+	s.mappingsLock.Lock()
+	defer s.mappingsLock.Unlock()
+
+	var documentMapping *DocumentMapping
+	err := s.db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(CollectionsBucket))
+
+		if b == nil {
+			return errors.New("collection doesn't exist")
+		}
+
+		encodedCollectionMapping := b.Get([]byte(collection))
+		if encodedCollectionMapping == nil {
+			return errors.New("collection doesn't exist")
+		}
+		documentMapping = DeserializeDocumentMapping(encodedCollectionMapping)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	documentMapping.patterns = documentMapping.compilePatterns()
+	cachedMapping := *documentMapping
+	s.mappings[collection] = &cachedMapping
+
+	return documentMapping, nil
+}
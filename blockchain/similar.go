@@ -0,0 +1,192 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// The defaults of the "more like this" options
+const (
+	defaultMaxQueryTerms = 25
+	defaultMinTermFreq   = 1
+	defaultMinDocFreq    = 1
+)
+
+// MoreLikeThis selects the significant terms of a document to find the similar ones
+type MoreLikeThis struct {
+	Fields        []string `json:"fields"`        // the text fields to take the terms from. All the text fields of the mapping if empty
+	MaxQueryTerms int      `json:"maxQueryTerms"` // the most significant terms to keep
+	MinTermFreq   int      `json:"minTermFreq"`   // the terms less frequent in the document are ignored
+	MinDocFreq    int      `json:"minDocFreq"`    // the terms in fewer documents of the collection are ignored
+	MaxDocFreq    int      `json:"maxDocFreq"`    // the terms in more documents of the collection are ignored. No limit if 0
+}
+
+// significantTerm is a term of a document field weighted by tf-idf
+type significantTerm struct {
+	field  string
+	term   string
+	weight float64
+}
+
+// SimilarityQuery builds a weighted disjunction of the most significant terms of a document across the text fields, using the term statistics of the index.
+// The sensitive fields the role may not read are left out so that the similar documents don't reveal them
+func (mlt MoreLikeThis) SimilarityQuery(index bleve.Index, documentMapping DocumentMapping, rawData []byte, role Role) (query.Query, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(rawData, &document); err != nil {
+		return nil, err
+	}
+
+	if mlt.MaxQueryTerms <= 0 {
+		mlt.MaxQueryTerms = defaultMaxQueryTerms
+	}
+	if mlt.MinTermFreq <= 0 {
+		mlt.MinTermFreq = defaultMinTermFreq
+	}
+	if mlt.MinDocFreq <= 0 {
+		mlt.MinDocFreq = defaultMinDocFreq
+	}
+
+	sensitiveFields := documentMapping.SensitiveFields()
+	fields := mlt.Fields
+	if len(fields) == 0 {
+		for field, v := range documentMapping.Fields {
+			fieldMapping, _ := v.(map[string]interface{})
+			if _, isSensitive := sensitiveFields[field]; fieldMapping["type"] == "text" && (!isSensitive || role.CanReadSensitiveField(documentMapping.Collection, field)) {
+				fields = append(fields, field)
+			}
+		}
+	}
+
+	for _, field := range fields {
+		if _, isSensitive := sensitiveFields[field]; isSensitive && !role.CanReadSensitiveField(documentMapping.Collection, field) {
+			return nil, fmt.Errorf("not allowed to read the sensitive field %s", field)
+		}
+	}
+
+	termFreqs, err := analyzeTextFields(index, documentMapping, document, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	significantTerms, err := mlt.weighTerms(index, termFreqs)
+	if err != nil {
+		return nil, err
+	}
+	if len(significantTerms) == 0 {
+		return nil, errors.New("the document has no significant terms in the text fields")
+	}
+
+	// the boosts are relative to the most significant term
+	var termQueries []query.Query
+	for _, term := range significantTerms {
+		termQuery := query.NewTermQuery(term.term)
+		termQuery.SetField(term.field)
+		termQuery.SetBoost(term.weight / significantTerms[0].weight)
+		termQueries = append(termQueries, termQuery)
+	}
+
+	return query.NewDisjunctionQuery(termQueries), nil
+}
+
+// analyzeTextFields counts the terms of the text fields with the analyzers of the index. Returns field -> term -> frequency
+func analyzeTextFields(index bleve.Index, documentMapping DocumentMapping, document map[string]interface{}, fields []string) (map[string]map[string]int, error) {
+	termFreqs := make(map[string]map[string]int)
+
+	for _, field := range fields {
+		fieldMapping, ok := documentMapping.Fields[field].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s is not defined in the collection mapping", field)
+		} else if fieldMapping["type"] != "text" {
+			return nil, fmt.Errorf("field %s is not a text field", field)
+		}
+
+		analyzer := index.Mapping().AnalyzerNamed(index.Mapping().AnalyzerNameForPath(field))
+		if analyzer == nil {
+			return nil, fmt.Errorf("no analyzer for field %s", field)
+		}
+
+		var texts []string
+		switch value := document[field].(type) {
+		case string:
+			texts = append(texts, value)
+		case []interface{}:
+			for _, element := range value {
+				if text, ok := element.(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+
+		for _, text := range texts {
+			for _, token := range analyzer.Analyze([]byte(text)) {
+				if termFreqs[field] == nil {
+					termFreqs[field] = make(map[string]int)
+				}
+				termFreqs[field][string(token.Term)]++
+			}
+		}
+	}
+
+	return termFreqs, nil
+}
+
+// weighTerms weighs the terms by tf-idf with the document frequencies of the index and returns the most significant ones in descending order
+func (mlt MoreLikeThis) weighTerms(index bleve.Index, termFreqs map[string]map[string]int) ([]significantTerm, error) {
+	indexer, _, err := index.Advanced()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := indexer.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	docCount, err := reader.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	var significantTerms []significantTerm
+	for field, terms := range termFreqs {
+		for term, termFreq := range terms {
+			if termFreq < mlt.MinTermFreq {
+				continue
+			}
+
+			termFieldReader, err := reader.TermFieldReader([]byte(term), field, false, false, false)
+			if err != nil {
+				return nil, err
+			}
+			docFreq := termFieldReader.Count()
+			termFieldReader.Close()
+
+			if docFreq < uint64(mlt.MinDocFreq) || (mlt.MaxDocFreq > 0 && docFreq > uint64(mlt.MaxDocFreq)) {
+				continue
+			}
+
+			idf := 1 + math.Log(float64(docCount)/float64(docFreq+1))
+			significantTerms = append(significantTerms, significantTerm{field: field, term: term, weight: float64(termFreq) * idf})
+		}
+	}
+
+	sort.Slice(significantTerms, func(i, j int) bool {
+		if significantTerms[i].weight != significantTerms[j].weight {
+			return significantTerms[i].weight > significantTerms[j].weight
+		}
+		return significantTerms[i].field+significantTerms[i].term < significantTerms[j].field+significantTerms[j].term
+	})
+
+	if len(significantTerms) > mlt.MaxQueryTerms {
+		significantTerms = significantTerms[:mlt.MaxQueryTerms]
+	}
+
+	return significantTerms, nil
+}
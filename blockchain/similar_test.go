@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+func TestSimilarityQuery(t *testing.T) {
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	documents := map[string]map[string]interface{}{
+		"1": {"title": "the quick brown fox", "ssn": "123"},
+		"2": {"title": "the quick brown dog", "ssn": "456"},
+		"3": {"title": "the lazy cat", "ssn": "123"},
+		"4": {"title": "the zebra", "ssn": "789"},
+	}
+	for id, document := range documents {
+		index.Index(id, document)
+	}
+
+	documentMapping := DocumentMapping{Collection: "animals", Fields: map[string]interface{}{
+		"title": map[string]interface{}{"type": "text"},
+		"ssn":   map[string]interface{}{"type": "text", "sensitive": true},
+		"age":   map[string]interface{}{"type": "number"},
+	}}
+	rawData := []byte(`{"title": "a quick brown fox", "ssn": "123", "age": 3}`)
+
+	similarityQuery, err := MoreLikeThis{}.SimilarityQuery(index, documentMapping, rawData, Role{Name: "user"})
+	if err != nil {
+		t.Fatalf("SimilarityQuery expected no error, actual: %s", err)
+	}
+
+	searchResponse, _ := index.Search(bleve.NewSearchRequest(similarityQuery))
+	if searchResponse.Total != 2 || searchResponse.Hits[0].ID != "1" || searchResponse.Hits[1].ID != "2" {
+		t.Errorf("expected the documents 1 and 2 without the sensitive ssn, actual: %v", searchResponse.Hits)
+	}
+
+	similarityQuery, _ = MoreLikeThis{Fields: []string{"ssn"}}.SimilarityQuery(index, documentMapping, rawData, Role{Name: "admin"})
+	searchResponse, _ = index.Search(bleve.NewSearchRequest(similarityQuery))
+	if searchResponse.Total != 2 {
+		t.Errorf("expected the documents 1 and 3 by ssn, actual: %v", searchResponse.Hits)
+	}
+
+	if _, err = (MoreLikeThis{Fields: []string{"ssn"}}).SimilarityQuery(index, documentMapping, rawData, Role{Name: "user"}); err == nil {
+		t.Error("SimilarityQuery expected an error for the unreadable sensitive field")
+	}
+	if _, err = (MoreLikeThis{Fields: []string{"age"}}).SimilarityQuery(index, documentMapping, rawData, Role{Name: "user"}); err == nil {
+		t.Error("SimilarityQuery expected an error for the number field")
+	}
+	if _, err = (MoreLikeThis{MaxDocFreq: 1}).SimilarityQuery(index, documentMapping, []byte(`{"title": "the"}`), Role{Name: "user"}); err == nil {
+		t.Error("SimilarityQuery expected an error without significant terms")
+	}
+}
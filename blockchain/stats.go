@@ -0,0 +1,171 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+)
+
+// the internal key in each index for the last block of a blockchain with documents of the collection, followed by the peerId
+const lastIndexedKeyPrefix = "lastIndexed_"
+
+// the internal key in the default index for the heights of the indexed blocks of a blockchain, followed by the peerId
+const indexedHeightsKeyPrefix = "indexedHeights_"
+
+// IndexedBlock is the highest block of a blockchain which has been indexed to a collection
+type IndexedBlock struct {
+	Height uint64
+	Hash   []byte
+}
+
+// HeightRange is the heights from From to To of the blocks of a blockchain, inclusive
+type HeightRange struct {
+	From uint64
+	To   uint64
+}
+
+// GetCollectionIndex returns the index of an active or archived collection and its state
+func (s *Search) GetCollectionIndex(name string) (bleve.Index, string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if index := s.BlockchainIndices[name]; index != nil {
+		return index, CollectionActive, nil
+	} else if index := s.archivedIndices[name]; index != nil {
+		return index, CollectionArchived, nil
+	}
+
+	return nil, "", fmt.Errorf("collection %s doesn't exist", name)
+}
+
+// GetLastIndexedBlock returns the highest block of a blockchain with documents indexed to a collection. nil if no such block has been indexed
+func (s *Search) GetLastIndexedBlock(index bleve.Index, peerId []byte) (*IndexedBlock, error) {
+	encodedIndexedBlock, err := index.GetInternal(lastIndexedKey(peerId))
+	if err != nil || len(encodedIndexedBlock) < 8 {
+		return nil, err
+	}
+
+	return &IndexedBlock{Height: binary.BigEndian.Uint64(encodedIndexedBlock[:8]), Hash: encodedIndexedBlock[8:]}, nil
+}
+
+// GetIndexingLag returns the number of blocks of a blockchain up to the tip height which are not indexed, wherever they are in the blockchain.
+// The genesis block has no documents to index
+func (s *Search) GetIndexingLag(peerId []byte, tipHeight uint64) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	indexedHeights, err := s.getIndexedHeights(peerId)
+	if err != nil {
+		return 0, err
+	}
+
+	lag := tipHeight
+	for _, heightRange := range indexedHeights {
+		from, to := heightRange.From, heightRange.To
+		if from < 1 {
+			from = 1
+		}
+		if to > tipHeight {
+			to = tipHeight
+		}
+		if from <= to {
+			lag -= to - from + 1
+		}
+	}
+
+	return lag, nil
+}
+
+// setLastIndexedBlock records the block in the batch of an index with documents of the block if it's higher than the last indexed one.
+// Peer blocks may be synced from the tip backwards
+func (s *Search) setLastIndexedBlock(index bleve.Index, batch *bleve.Batch, block *Block, peerId []byte) {
+	lastIndexedBlock, err := s.GetLastIndexedBlock(index, peerId)
+	if err == nil && lastIndexedBlock != nil && lastIndexedBlock.Height > block.Height {
+		return
+	}
+
+	var encodedIndexedBlock bytes.Buffer
+	encodedIndexedBlock.Write(IntToHex(int64(block.Height)))
+	encodedIndexedBlock.Write(block.Hash)
+	batch.SetInternal(lastIndexedKey(peerId), encodedIndexedBlock.Bytes())
+}
+
+// addIndexedHeight records the height of a block in the batch of the default index. The caller holds the lock
+func (s *Search) addIndexedHeight(defaultBatch *bleve.Batch, height uint64, peerId []byte) error {
+	indexedHeights, err := s.getIndexedHeights(peerId)
+	if err != nil {
+		return err
+	}
+
+	indexedHeights = addHeight(indexedHeights, height)
+	s.indexedHeights[fmt.Sprintf("%x", peerId)] = indexedHeights
+
+	var encodedHeights bytes.Buffer
+	if err = gob.NewEncoder(&encodedHeights).Encode(indexedHeights); err != nil {
+		return err
+	}
+	defaultBatch.SetInternal(indexedHeightsKey(peerId), encodedHeights.Bytes())
+
+	return nil
+}
+
+// getIndexedHeights returns the heights of the indexed blocks of a blockchain, read from the default index once. The caller holds the lock
+func (s *Search) getIndexedHeights(peerId []byte) ([]HeightRange, error) {
+	if indexedHeights, ok := s.indexedHeights[fmt.Sprintf("%x", peerId)]; ok {
+		return indexedHeights, nil
+	}
+
+	defaultIndex := s.BlockchainIndices[indexDefault]
+	encodedHeights, err := defaultIndex.GetInternal(indexedHeightsKey(peerId))
+	if err != nil {
+		return nil, err
+	}
+
+	var indexedHeights []HeightRange
+	if encodedHeights != nil {
+		if err = gob.NewDecoder(bytes.NewReader(encodedHeights)).Decode(&indexedHeights); err != nil {
+			return nil, err
+		}
+	} else if lastIndexedBlock, err := s.GetLastIndexedBlock(defaultIndex, peerId); err == nil && lastIndexedBlock != nil {
+		// the blocks indexed before the heights were recorded, forwards
+		indexedHeights = []HeightRange{{From: 0, To: lastIndexedBlock.Height}}
+	}
+
+	s.indexedHeights[fmt.Sprintf("%x", peerId)] = indexedHeights
+	return indexedHeights, nil
+}
+
+// addHeight adds a height to the sorted ranges of heights, merging the adjacent ones
+func addHeight(heightRanges []HeightRange, height uint64) []HeightRange {
+	i := sort.Search(len(heightRanges), func(i int) bool { return heightRanges[i].To+1 >= height })
+	switch {
+	case i < len(heightRanges) && heightRanges[i].From <= height && height <= heightRanges[i].To:
+		return heightRanges // indexed again
+	case i < len(heightRanges) && heightRanges[i].To+1 == height:
+		heightRanges[i].To = height
+		if i+1 < len(heightRanges) && heightRanges[i+1].From == height+1 {
+			heightRanges[i].To = heightRanges[i+1].To
+			heightRanges = append(heightRanges[:i+1], heightRanges[i+2:]...)
+		}
+	case i < len(heightRanges) && heightRanges[i].From == height+1:
+		heightRanges[i].From = height
+	default:
+		heightRanges = append(heightRanges, HeightRange{})
+		copy(heightRanges[i+1:], heightRanges[i:])
+		heightRanges[i] = HeightRange{From: height, To: height}
+	}
+
+	return heightRanges
+}
+
+func lastIndexedKey(peerId []byte) []byte {
+	return []byte(fmt.Sprintf("%s%x", lastIndexedKeyPrefix, peerId))
+}
+
+func indexedHeightsKey(peerId []byte) []byte {
+	return []byte(fmt.Sprintf("%s%x", indexedHeightsKeyPrefix, peerId))
+}
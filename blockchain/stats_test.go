@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddHeight(t *testing.T) {
+	var heightRanges []HeightRange
+	for _, height := range []uint64{5, 4, 1, 9, 2, 4, 7} {
+		heightRanges = addHeight(heightRanges, height)
+	}
+	expected := []HeightRange{{1, 2}, {4, 5}, {7, 7}, {9, 9}}
+	if !reflect.DeepEqual(heightRanges, expected) {
+		t.Fatalf("expected %v, actual: %v", expected, heightRanges)
+	}
+
+	heightRanges = addHeight(addHeight(addHeight(heightRanges, 3), 8), 6)
+	if expected = []HeightRange{{1, 9}}; !reflect.DeepEqual(heightRanges, expected) {
+		t.Errorf("expected the ranges merged into %v, actual: %v", expected, heightRanges)
+	}
+}
+
+func TestIndexingLag(t *testing.T) {
+	s, stop := newTestSearch(t)
+	defer stop()
+	peerId := []byte("peer")
+
+	// a peer blockchain synced from the tip backwards, with block 3 missing
+	for _, height := range []uint64{5, 4, 2, 1, 0} {
+		tx := NewTransaction(peerId, []byte(`{"a": "b"}`), "orders", nil, nil, nil)
+		s.IndexBlock(NewBlock([]*Transaction{tx}, []byte{}, height), peerId)
+	}
+
+	if lag, err := s.GetIndexingLag(peerId, 5); err != nil || lag != 1 {
+		t.Errorf("expected the missing block in the middle counted, actual: %d %v", lag, err)
+	}
+	if lastIndexedBlock, _ := s.GetLastIndexedBlock(s.BlockchainIndices["orders"], peerId); lastIndexedBlock == nil || lastIndexedBlock.Height != 5 {
+		t.Errorf("expected the highest block with orders recorded, actual: %v", lastIndexedBlock)
+	}
+	if lastIndexedBlock, _ := s.GetLastIndexedBlock(s.BlockchainIndices[indexDefault], peerId); lastIndexedBlock != nil {
+		t.Errorf("expected the collection without documents in the blocks untouched, actual: %v", lastIndexedBlock)
+	}
+
+	// of a collection which doesn't exist
+	s.IndexBlock(NewBlock([]*Transaction{NewTransaction(peerId, []byte(`{"a": "b"}`), "unknown", nil, nil, nil)}, []byte{}, 3), peerId)
+	if lag, _ := s.GetIndexingLag(peerId, 6); lag != 1 {
+		t.Errorf("expected the block above the indexed ones counted, actual: %d", lag)
+	}
+
+	// read again from the default index
+	s.indexedHeights = make(map[string][]HeightRange)
+	if lag, err := s.GetIndexingLag(peerId, 5); err != nil || lag != 0 {
+		t.Errorf("expected all the blocks indexed, actual: %d %v", lag, err)
+	}
+}
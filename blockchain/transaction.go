@@ -23,6 +23,14 @@ type Transaction struct {
 	PubKey             []byte
 	Signature          []byte
 	PermittedAddresses []string
+	Nonce              uint64         // the signature commits to the nonce if not 0
+	ExpiresAt          int64          // the signature commits to the expiry if not 0
+	BatchRoot          []byte         // the merkle root of the signed batch of the document, nil if the document is signed alone
+	BatchPath          map[int][]byte // the verification path from the document to the batch root
+	SignatureScheme    string         // how the signature signs the message, see SignatureSchemeKeccak256
+	KeyType            string         // the type of the public key, see KeyTypeSecp256k1
+	Address            string         // the address of the author, which a rotated key doesn't derive. Empty for the transactions before the key rotation
+	Delegation         *Delegation    // the delegation of the author to the delegate which signed the transaction, nil if the author signed it
 }
 
 // SetID sets ID of a transaction based on the raw data and timestamp
@@ -69,12 +77,17 @@ func Sign(privKey ecdsa.PrivateKey, rawDataDigest []byte) []byte {
 
 // NewTransaction creates a new transaction
 func NewTransaction(peerId []byte, data []byte, collection string, pubKey []byte, signature []byte, permittedAddresses []string) *Transaction {
-	tx := &Transaction{[]byte{}, []byte{}, peerId, data, time.Now().UnixNano() / 1000000, collection, pubKey, signature, permittedAddresses}
+	tx := &Transaction{[]byte{}, []byte{}, peerId, data, time.Now().UnixNano() / 1000000, collection, pubKey, signature, permittedAddresses, 0, 0, nil, nil, SignatureSchemeKeccak256, KeyTypeSecp256k1, "", nil}
 	tx.SetID()
 
 	return tx
 }
 
+// IsGenesis tells if the transaction is the unsigned coinbase transaction of a genesis block, which has no author, envelope or delegation
+func (tx *Transaction) IsGenesis() bool {
+	return len(tx.Signature) == 0 && len(tx.PubKey) == 0 && tx.Address == "" && tx.Delegation == nil && !EnvelopeOf(tx).IsBound() && len(tx.PermittedAddresses) == 0 && tx.BatchRoot == nil
+}
+
 // NewCoinbaseTX creates a new coinbase transaction
 func NewCoinbaseTX(peerId []byte) *Transaction {
 	return NewTransaction(peerId, []byte(genesisCoinbaseRawData), "default", []byte{}, []byte{}, nil)
@@ -17,7 +17,13 @@ const (
 	TransactionsBucket     = "transactions"
 	AccountsBucket         = "accounts"
 	CollectionsBucket      = "collections"
+	TxIndexBucket          = "txIndex"     // transactionId -> blockHash
+	BlockAcksBucket        = "blockAcks"   // blockHash + peerId -> the time a peer acknowledged the local block
+	EnvelopesBucket        = "envelopes"   // keccak256 of the signed envelope -> transactionId
+	NoncesBucket           = "nonces"      // address -> the last nonce of the account
+	DelegationsBucket      = "delegations" // principal address + delegate address -> the delegation
 	P2PPrivateKeyKey       = "p2pPrivKey"
+	TxIndexBackfilledKey   = "txIndexBackfilled"
 	genesisCoinbaseRawData = `{"isActive":true,"balance":"$1,608.00","picture":"http://placehold.it/32x32","age":37,"eyeColor":"brown","name":"Rosa Sherman","gender":"male","organization":"STELAECOR","email":"rosasherman@stelaecor.com","phone":"+1 (907) 581-2115","address":"546 Meserole Street, Clara, New Jersey, 5471","about":"Reprehenderit eu pariatur proident id voluptate eu pariatur minim ut magna aliquip esse. Eu et quis sint quis et anim duis non tempor esse minim voluptate fugiat. Cillum qui nulla aute ullamco.\r\n","registered":"2018-01-15T05:53:18 +05:00","latitude":-55.183323,"longitude":-63.077504,"tags":["laborum","ex","officia","nisi","adipisicing","commodo","incididunt"],"friends":[{"id":0,"name":"Franks Harper"},{"id":1,"name":"Bettye Nash"},{"id":2,"name":"Mai Buck"}],"greeting":"Hello, Rosa Sherman! You have 3 unread messages.","favoriteFruit":"strawberry"}`
 
 	letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
@@ -37,13 +43,9 @@ func IntToHex(num int64) []byte {
 	return buff.Bytes()
 }
 
-// isValidSig verifies if the rawData is a signed correctly
-func IsValidSig(rawData []byte, pubKey []byte, signature []byte) bool {
-	hash := crypto.Keccak256(rawData)
-	if !crypto.VerifySignature(pubKey, hash[:], signature[:64]) {
-		return false
-	}
-	return true
+// isValidSig verifies if the rawData is a signed correctly with a public key of the key type
+func IsValidSig(keyType string, rawData []byte, pubKey []byte, signature []byte) bool {
+	return IsValidKeySig(keyType, crypto.Keccak256(rawData), pubKey, signature)
 }
 
 func IsValidAddress(address string) bool {
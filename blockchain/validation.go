@@ -0,0 +1,260 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/geo"
+)
+
+// checkConstraints verifies the validation rules of the field mappings are well-formed, e.g.
+// "age": {"type": "number", "required": true, "min": 0, "max": 150}
+// "status": {"type": "text", "enum": ["active", "inactive"]}
+// "code": {"type": "text", "minLength": 2, "maxLength": 8, "pattern": "^[A-Z]+$"}
+func (dm DocumentMapping) checkConstraints() error {
+	for fieldName, v := range dm.Fields {
+		fieldMapping, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("the mapping of field: %s should be an object", fieldName)
+		}
+
+		fieldType := fieldMapping["type"]
+		for constraint, value := range fieldMapping {
+			switch constraint {
+			case "type":
+			case "required", "sensitive":
+				if _, ok := value.(bool); !ok {
+					return fmt.Errorf("%s of field: %s should be a boolean", constraint, fieldName)
+				}
+			case "mask": // the replacement of a sensitive field for the roles which may not read it
+				if _, ok := value.(string); !ok {
+					return fmt.Errorf("mask of field: %s should be a string", fieldName)
+				}
+				if isSensitive, _ := fieldMapping["sensitive"].(bool); !isSensitive {
+					return fmt.Errorf("mask only applies to the sensitive field: %s", fieldName)
+				}
+			case "enum":
+				if fieldType == "geopoint" {
+					return fmt.Errorf("enum doesn't apply to the geopoint field: %s", fieldName)
+				}
+				if values, ok := value.([]interface{}); !ok || len(values) == 0 {
+					return fmt.Errorf("enum of field: %s should be a non-empty array", fieldName)
+				}
+			case "min", "max":
+				if fieldType != "number" {
+					return fmt.Errorf("%s only applies to the number field: %s", constraint, fieldName)
+				}
+				if _, ok := value.(float64); !ok {
+					return fmt.Errorf("%s of field: %s should be a number", constraint, fieldName)
+				}
+			case "minLength", "maxLength":
+				if fieldType != "text" {
+					return fmt.Errorf("%s only applies to the text field: %s", constraint, fieldName)
+				}
+				if length, ok := value.(float64); !ok || length < 0 || length != math.Trunc(length) {
+					return fmt.Errorf("%s of field: %s should be a non-negative integer", constraint, fieldName)
+				}
+			case "pattern":
+				if fieldType != "text" {
+					return fmt.Errorf("pattern only applies to the text field: %s", fieldName)
+				}
+				pattern, ok := value.(string)
+				if !ok {
+					return fmt.Errorf("pattern of field: %s should be a string", fieldName)
+				}
+				if _, err := regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("pattern of field: %s is not valid: %s", fieldName, err)
+				}
+			default:
+				return fmt.Errorf("%s is not a valid constraint for field: %s", constraint, fieldName)
+			}
+		}
+
+		if min, ok := fieldMapping["min"].(float64); ok {
+			if max, ok := fieldMapping["max"].(float64); ok && min > max {
+				return fmt.Errorf("min of field: %s should not be greater than max", fieldName)
+			}
+		}
+
+		if minLength, ok := fieldMapping["minLength"].(float64); ok {
+			if maxLength, ok := fieldMapping["maxLength"].(float64); ok && minLength > maxLength {
+				return fmt.Errorf("minLength of field: %s should not be greater than maxLength", fieldName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate checks a JSON document against the field types and validation rules of the mapping. Returns the violations by field
+func (dm DocumentMapping) Validate(rawData []byte) (map[string]string, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(rawData, &document); err != nil {
+		return nil, err
+	}
+
+	validationErrors := make(map[string]string)
+	if len(dm.Schema) > 0 {
+		schema, err := dm.getCompiledSchema()
+		if err != nil {
+			return nil, err
+		}
+
+		validation := schemaValidation{compiledSchema: schema}
+		validation.validateSchema(schema.root, document, "", validationErrors, 0)
+		if validation.isExhausted() {
+			validationErrors[""] = "schema takes too many steps to validate" // the violations of the subschemas of anyOf and oneOf are discarded
+		}
+		if message, ok := validationErrors[""]; ok {
+			delete(validationErrors, "")
+			validationErrors["_document"] = message // the violations of the document as a whole
+		}
+	} else {
+		patterns := dm.patterns
+		if patterns == nil {
+			patterns = dm.compilePatterns() // not loaded by GetDocumentMapping
+		}
+		dm.validateFields(document, patterns, validationErrors)
+	}
+
+	if len(validationErrors) == 0 {
+		return nil, nil
+	}
+	return validationErrors, nil
+}
+
+// compilePatterns compiles the patterns of the fields, which checkConstraints has verified
+func (dm DocumentMapping) compilePatterns() map[string]*regexp.Regexp {
+	patterns := make(map[string]*regexp.Regexp)
+	for _, v := range dm.Fields {
+		fieldMapping, _ := v.(map[string]interface{})
+		if pattern, ok := fieldMapping["pattern"].(string); ok {
+			if compiled, err := regexp.Compile(pattern); err == nil {
+				patterns[pattern] = compiled
+			}
+		}
+	}
+
+	return patterns
+}
+
+// validateFields checks a document against the field types and validation rules of the mapping with the compiled patterns of the fields
+func (dm DocumentMapping) validateFields(document map[string]interface{}, patterns map[string]*regexp.Regexp, validationErrors map[string]string) {
+	for field, value := range document {
+		fieldMapping, ok := dm.Fields[field].(map[string]interface{})
+		if !ok {
+			if dm.Strict {
+				validationErrors[field] = "field is not defined in the collection mapping"
+			}
+			continue
+		}
+
+		if message := validateField(fieldMapping, patterns, value); message != "" {
+			validationErrors[field] = message
+		}
+	}
+
+	for field, v := range dm.Fields {
+		fieldMapping, _ := v.(map[string]interface{})
+		if required, _ := fieldMapping["required"].(bool); required && document[field] == nil {
+			validationErrors[field] = "field is required"
+		}
+	}
+}
+
+// validateField checks a field value, or each of its elements if it's an array. Returns the violation message or empty
+func validateField(fieldMapping map[string]interface{}, patterns map[string]*regexp.Regexp, value interface{}) string {
+	elements, isArray := value.([]interface{})
+	if !isArray {
+		return validateValue(fieldMapping, patterns, value)
+	}
+
+	// a geopoint can be expressed as an array of [lon, lat]
+	if fieldMapping["type"] == "geopoint" {
+		if _, _, isGeoPoint := geo.ExtractGeoPoint(value); isGeoPoint {
+			return ""
+		}
+	}
+
+	for _, element := range elements {
+		if message := validateValue(fieldMapping, patterns, element); message != "" {
+			return message
+		}
+	}
+
+	return ""
+}
+
+func validateValue(fieldMapping map[string]interface{}, patterns map[string]*regexp.Regexp, value interface{}) string {
+	fieldType := fieldMapping["type"]
+
+	switch value := value.(type) {
+	case string:
+		if fieldType == "datetime" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return "cannot parse as RFC3339 time format"
+			}
+		} else if fieldType != "text" {
+			return fmt.Sprintf("field type should be %s", fieldType)
+		}
+	case float64:
+		if fieldType != "number" {
+			return fmt.Sprintf("field type should be %s", fieldType)
+		}
+	case bool:
+		if fieldType != "boolean" {
+			return fmt.Sprintf("field type should be %s", fieldType)
+		}
+	default:
+		if fieldType != "geopoint" {
+			return fmt.Sprintf("field type should be %s", fieldType)
+		} else if _, _, isGeoPoint := geo.ExtractGeoPoint(value); !isGeoPoint {
+			return "field type should be geopoint"
+		}
+	}
+
+	if enum, ok := fieldMapping["enum"].([]interface{}); ok {
+		isAllowed := false
+		for _, allowed := range enum {
+			if allowed == value {
+				isAllowed = true
+				break
+			}
+		}
+
+		if !isAllowed {
+			enumJSON, _ := json.Marshal(enum)
+			return fmt.Sprintf("field value should be one of %s", enumJSON)
+		}
+	}
+
+	if number, ok := value.(float64); ok {
+		if min, ok := fieldMapping["min"].(float64); ok && number < min {
+			return fmt.Sprintf("field value should be greater than or equal to %v", min)
+		}
+		if max, ok := fieldMapping["max"].(float64); ok && number > max {
+			return fmt.Sprintf("field value should be less than or equal to %v", max)
+		}
+	}
+
+	if text, ok := value.(string); ok && fieldType == "text" {
+		length := utf8.RuneCountInString(text)
+		if minLength, ok := fieldMapping["minLength"].(float64); ok && float64(length) < minLength {
+			return fmt.Sprintf("field length should be at least %v", minLength)
+		}
+		if maxLength, ok := fieldMapping["maxLength"].(float64); ok && float64(length) > maxLength {
+			return fmt.Sprintf("field length should be at most %v", maxLength)
+		}
+		if pattern, ok := fieldMapping["pattern"].(string); ok {
+			if compiled := patterns[pattern]; compiled == nil || !compiled.MatchString(text) {
+				return fmt.Sprintf("field value should match the pattern %s", pattern)
+			}
+		}
+	}
+
+	return ""
+}
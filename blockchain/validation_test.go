@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var mappingJSON = `
+{
+	"collection": "people",
+	"fields": {
+		"id": {"type": "text", "required": true, "pattern": "^[a-z0-9-]+$"},
+		"name": {"type": "text", "minLength": 2, "maxLength": 5},
+		"status": {"type": "text", "enum": ["active", "inactive"]},
+		"age": {"type": "number", "min": 0, "max": 150},
+		"scores": {"type": "number", "max": 100},
+		"created": {"type": "datetime"},
+		"location": {"type": "geopoint"}
+	},
+	"strict": true
+}
+`
+
+func TestValidate(t *testing.T) {
+	var documentMapping DocumentMapping
+	json.Unmarshal([]byte(mappingJSON), &documentMapping)
+
+	if err := documentMapping.checkConstraints(); err != nil {
+		t.Fatalf("checkConstraints expected no error, actual: %s", err)
+	}
+
+	validationErrors, _ := documentMapping.Validate([]byte(`{"id": "a-1", "name": "Bob", "status": "active", "age": 30, "scores": [90, 100], "created": "2020-01-01T00:00:00Z", "location": [-63.07, -55.18]}`))
+	if validationErrors != nil {
+		t.Errorf("valid document expected no errors, actual: %v", validationErrors)
+	}
+
+	validationErrors, _ = documentMapping.Validate([]byte(`{"name": "B", "status": "gone", "age": -1, "scores": [90, 101], "created": "yesterday", "extra": 1}`))
+	expectedFields := []string{"id", "name", "status", "age", "scores", "created", "extra"}
+	if len(validationErrors) != len(expectedFields) {
+		t.Errorf("invalid document expected %d errors, actual: %v", len(expectedFields), validationErrors)
+	}
+	for _, field := range expectedFields {
+		if validationErrors[field] == "" {
+			t.Errorf("expected a violation for field %s, actual: %v", field, validationErrors)
+		}
+	}
+
+	validationErrors, _ = documentMapping.Validate([]byte(`{"id": "UPPER", "name": "Roberta"}`))
+	if validationErrors["id"] == "" || validationErrors["name"] == "" {
+		t.Errorf("pattern and maxLength expected violations, actual: %v", validationErrors)
+	}
+}
+
+func TestCheckConstraints(t *testing.T) {
+	invalidFields := []string{
+		`{"age": {"type": "number", "min": "zero"}}`,
+		`{"age": {"type": "number", "min": 10, "max": 1}}`,
+		`{"name": {"type": "number", "pattern": "^a"}}`,
+		`{"name": {"type": "text", "pattern": "("}}`,
+		`{"name": {"type": "text", "maxLength": 1.5}}`,
+		`{"name": {"type": "text", "enum": []}}`,
+		`{"name": {"type": "text", "unique": true}}`,
+		`{"name": {"type": "text", "encrypted": true}}`,
+	}
+
+	for _, fields := range invalidFields {
+		documentMapping := DocumentMapping{Collection: "c"}
+		json.Unmarshal([]byte(fields), &documentMapping.Fields)
+
+		if err := documentMapping.checkConstraints(); err == nil {
+			t.Errorf("checkConstraints expected an error for %s", fields)
+		}
+	}
+}
+
+func TestDocumentMappingSerialize(t *testing.T) {
+	var documentMapping DocumentMapping
+	json.Unmarshal([]byte(mappingJSON), &documentMapping)
+
+	deserialized := DeserializeDocumentMapping(documentMapping.Serialize())
+	validationErrors, _ := deserialized.Validate([]byte(`{"id": "a", "status": "gone"}`))
+	if !deserialized.Strict || validationErrors["status"] == "" {
+		t.Errorf("deserialized mapping expected to keep the rules, actual: %v", validationErrors)
+	}
+}
+
+func TestGetDocumentMappingCache(t *testing.T) {
+	bc, dir, cleanup := newTestBlockchain(t)
+	defer cleanup()
+
+	s, err := NewSearch(bc.Db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CreateMappingByJson([]byte(mappingJSON)); err != nil {
+		t.Fatal(err)
+	}
+
+	documentMapping, err := s.GetDocumentMapping("people")
+	if err != nil || documentMapping.patterns["^[a-z0-9-]+$"] == nil {
+		t.Fatalf("expected the pattern compiled when the mapping is loaded: %v %v", documentMapping, err)
+	}
+	if validationErrors, _ := documentMapping.Validate([]byte(`{"id": "UPPER"}`)); validationErrors["id"] == "" {
+		t.Errorf("expected the pattern violated, actual: %v", validationErrors)
+	}
+
+	// the callers modify a copy of the cached mapping
+	documentMapping.State = CollectionArchived
+	if cached, _ := s.GetDocumentMapping("people"); cached.State != CollectionActive || cached.patterns["^[a-z0-9-]+$"] != documentMapping.patterns["^[a-z0-9-]+$"] {
+		t.Errorf("expected the cached mapping unchanged and its patterns compiled once, actual: %+v", cached)
+	}
+
+	// a new version of the mapping replaces the cached one
+	documentMapping.Fields = map[string]interface{}{"id": map[string]interface{}{"type": "text", "pattern": "^[A-Z]+$"}}
+	if err = s.saveMapping(*documentMapping); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := s.GetDocumentMapping("people"); saved.State != CollectionArchived || saved.patterns["^[A-Z]+$"] == nil {
+		t.Errorf("expected the saved mapping, actual: %+v", saved)
+	}
+}
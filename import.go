@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/webapi"
+)
+
+var importServer string
+var importCollection string
+var importFile string
+var importFormat string
+var importPrivateKey string
+var importOffset int
+
+// importClient signs the documents of a file with the private key of an account and streams them to /import of a Blocace server
+type importClient struct {
+	server     string
+	privateKey *ecdsa.PrivateKey
+	address    string
+	token      string
+}
+
+// importDocuments imports a NDJSON file of raw documents or a CSV file with a header row into a collection. When the admission limits of the server
+// stop the import, it waits as told by Retry-After and resumes after the last line read
+func importDocuments() error {
+	privateKey, err := crypto.HexToECDSA(importPrivateKey)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %s", err)
+	}
+
+	client := importClient{server: strings.TrimRight(importServer, "/"), privateKey: privateKey, address: crypto.PubkeyToAddress(privateKey.PublicKey).String()}
+	if err = client.authenticate(); err != nil {
+		return err
+	}
+
+	format := importFormat
+	if funk.IsEmpty(format) {
+		format = webapi.ImportFormatNDJSON
+		if strings.EqualFold(filepath.Ext(importFile), ".csv") {
+			format = webapi.ImportFormatCSV
+		}
+	}
+
+	// the signatures commit to the collection an alias points to
+	var mappingResponse struct {
+		Mapping blockchain.DocumentMapping `json:"mapping"`
+	}
+	if _, err = client.request("GET", "/collection/"+url.PathEscape(importCollection), nil, &mappingResponse); err != nil {
+		return err
+	}
+
+	offset := importOffset
+	for {
+		var account map[string]interface{}
+		if _, err = client.request("GET", "/account/"+client.address, nil, &account); err != nil {
+			return err
+		}
+		nonce, _ := account["nonce"].(float64)
+
+		input := os.Stdin
+		if importFile != "-" {
+			if input, err = os.Open(importFile); err != nil {
+				return err
+			}
+		}
+
+		body, writer := io.Pipe()
+		go func() {
+			defer input.Close()
+			writer.CloseWithError(client.signRecords(writer, input, format, mappingResponse.Mapping, uint64(nonce), offset))
+		}()
+
+		var importResponse webapi.ImportResponse
+		response, err := client.request("POST", fmt.Sprintf("/import/%s?format=%s&offset=%d", url.PathEscape(importCollection), format, offset), body, &importResponse)
+		body.Close()
+		if err != nil && (response == nil || response.StatusCode != http.StatusTooManyRequests) {
+			return err
+		}
+
+		for _, result := range importResponse.Results {
+			if result.Status != "ok" && len(result.FieldErrorMapping) > 0 {
+				log.Warnf("line %d: %s %v", result.Line, result.Status, result.FieldErrorMapping)
+			} else if result.Status != "ok" {
+				log.Warnf("line %d: %s", result.Line, result.Status)
+			}
+		}
+		log.Infof("%d of %d documents accepted, read up to line %d", importResponse.Accepted, importResponse.Total, importResponse.Offset)
+
+		if importResponse.Complete {
+			return nil
+		} else if importFile == "-" || response.Header.Get("Retry-After") == "" {
+			return fmt.Errorf("the import stopped at line %d. run again with --offset %d to resume", importResponse.Offset, importResponse.Offset)
+		}
+
+		retryAfter, _ := strconv.Atoi(response.Header.Get("Retry-After"))
+		log.Infof("the queue of the server is full. resuming after line %d in %d seconds...", importResponse.Offset, retryAfter)
+		time.Sleep(time.Duration(retryAfter) * time.Second)
+		offset = importResponse.Offset
+	}
+}
+
+// authenticate signs a challenge word of the server for a JWT
+func (c *importClient) authenticate() error {
+	var challengeResponse struct {
+		Challenge string `json:"challenge"`
+	}
+	if _, err := c.request("GET", "/jwt/challenge/"+c.address, nil, &challengeResponse); err != nil {
+		return err
+	}
+
+	signature := blockchain.Sign(*c.privateKey, crypto.Keccak256([]byte(challengeResponse.Challenge)))
+	authPayload, _ := json.Marshal(webapi.AuthPayload{Address: c.address, ChallengeWord: challengeResponse.Challenge, Signature: hex.EncodeToString(signature)})
+
+	var jwtResponse struct {
+		Token string `json:"token"`
+	}
+	if _, err := c.request("POST", "/jwt", bytes.NewReader(authPayload), &jwtResponse); err != nil {
+		return err
+	}
+	c.token = jwtResponse.Token
+
+	return nil
+}
+
+// request calls the server and decodes the JSON response. Returns an error with the message of the server if the status is not 2xx
+func (c *importClient) request(method string, path string, body io.Reader, response interface{}) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if !funk.IsEmpty(c.token) {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res, err
+	}
+	json.Unmarshal(resBody, response)
+
+	if res.StatusCode/100 != 2 {
+		var errorResponse struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		}
+		json.Unmarshal(resBody, &errorResponse)
+		return res, errors.New(strings.TrimSpace(errorResponse.Message + errorResponse.Status))
+	}
+
+	return res, nil
+}
+
+// signRecords writes the records of the input with their signatures in the format of /import, signing with the nonces after the given one.
+// The lines up to the offset are written empty as the server skips them; a CSV record which cannot be mapped is written unsigned for the server to report it
+func (c *importClient) signRecords(output io.Writer, input io.Reader, format string, mapping blockchain.DocumentMapping, nonce uint64, offset int) error {
+	// the documents are signed in their canonical form (RFC 8785)
+	sign := func(rawDocument []byte) (string, uint64) {
+		nonce++
+		envelope := blockchain.Envelope{Collection: mapping.Collection, Nonce: nonce}
+		return hex.EncodeToString(blockchain.Sign(*c.privateKey, crypto.Keccak256(envelope.SigningMessage(rawDocument, c.address)))), nonce
+	}
+
+	if format == webapi.ImportFormatNDJSON {
+		reader := bufio.NewReader(input)
+		encoder := json.NewEncoder(output)
+		for line := 1; ; line++ {
+			content, err := reader.ReadBytes('\n')
+			if err == io.EOF && len(content) == 0 {
+				return nil
+			} else if err != nil && err != io.EOF {
+				return err
+			}
+
+			content = bytes.TrimSpace(content)
+			if line <= offset || len(content) == 0 {
+				if _, err = output.Write([]byte("\n")); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// a document which cannot be canonicalized is sent unsigned for the server to report it
+			payload := webapi.TransactionPayload{RawDocument: string(content), Canonical: true}
+			if canonical, err := blockchain.Canonicalize(content); err == nil {
+				payload.Signature, payload.Nonce = sign(canonical)
+			}
+			if err = encoder.Encode(payload); err != nil {
+				return err
+			}
+		}
+	}
+
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	writer := csv.NewWriter(output)
+	defer writer.Flush()
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	for _, column := range header {
+		if blockchain.IsCSVEnvelopeColumn(column) {
+			return fmt.Errorf("the CSV header has the column %s reserved for the signatures", column)
+		}
+	}
+	if err = writer.Write(append(header, blockchain.CSVSignatureColumn, blockchain.CSVNonceColumn)); err != nil {
+		return err
+	}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if _, isParseErr := err.(*csv.ParseError); err != nil && !isParseErr {
+			return err
+		}
+
+		signature, signedNonce := "", ""
+		if line > offset && err == nil {
+			if rawDocument, err := mapping.CSVRecordToDocument(header, record); err == nil {
+				var recordNonce uint64
+				signature, recordNonce = sign(rawDocument)
+				signedNonce = strconv.FormatUint(recordNonce, 10)
+			}
+		}
+
+		if err = writer.Write(append(record, signature, signedNonce)); err != nil {
+			return err
+		}
+	}
+}
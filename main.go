@@ -31,7 +31,10 @@ import (
 var secret = "blocace_secret"
 var dataDir string
 var maxTxsPerBlock int
+var maxBytesPerBlock int64
 var maxTimeToGenerateBlock int // milliseconds
+var maxIdleTime int            // milliseconds
+var admissionLimits pool.AdmissionLimits
 var portHttp string
 var portP2p int
 var hostP2p string
@@ -39,6 +42,7 @@ var advertiseAddress string
 var peerAddresses string
 var peerAddressesArray []string
 var bulkLoading string
+var legacySignatures string
 var loglevel string
 var version string // build-time variable
 
@@ -97,6 +101,42 @@ func main() {
 					Usage:       "the time in milliseconds interval to generate a block",
 					Destination: &maxTimeToGenerateBlock,
 				},
+				cli.Int64Flag{
+					Name:        "maxBytesPerBlock",
+					Value:       0,
+					Usage:       "the max bytes of the documents in a block, 0 for no limit",
+					Destination: &maxBytesPerBlock,
+				},
+				cli.IntFlag{
+					Name:        "maxIdleTime",
+					Value:       30000,
+					Usage:       "the longest time in milliseconds interval to check the idle transaction queue",
+					Destination: &maxIdleTime,
+				},
+				cli.IntFlag{
+					Name:        "maxQueueLength",
+					Value:       0,
+					Usage:       "the max transactions waiting for a block, 0 for no limit",
+					Destination: &admissionLimits.MaxQueueLength,
+				},
+				cli.Int64Flag{
+					Name:        "maxQueueBytes",
+					Value:       0,
+					Usage:       "the max bytes of the documents waiting for a block, 0 for no limit",
+					Destination: &admissionLimits.MaxQueueBytes,
+				},
+				cli.IntFlag{
+					Name:        "maxPendingPerAddress",
+					Value:       0,
+					Usage:       "the max transactions of an account waiting for a block, 0 for no limit",
+					Destination: &admissionLimits.MaxPendingPerAddress,
+				},
+				cli.IntFlag{
+					Name:        "maxPendingPerCollection",
+					Value:       0,
+					Usage:       "the max transactions to a collection waiting for a block, 0 for no limit",
+					Destination: &admissionLimits.MaxPendingPerCollection,
+				},
 				cli.StringFlag{
 					Name:        "porthttp, o",
 					Value:       "6899",
@@ -133,6 +173,12 @@ func main() {
 					Usage:       "enable bulking loading API",
 					Destination: &bulkLoading,
 				},
+				cli.StringFlag{
+					Name:        "legacySignatures",
+					Value:       "false",
+					Usage:       "accept the signatures over the documents only without a nonce or expiry, which can be replayed",
+					Destination: &legacySignatures,
+				},
 				cli.StringFlag{
 					Name:        "loglevel, l",
 					Value:       "info",
@@ -164,12 +210,16 @@ func main() {
 					"path":             dataDir,
 					"maxtx":            maxTxsPerBlock,
 					"maxtime":          maxTimeToGenerateBlock,
+					"maxBytesPerBlock": maxBytesPerBlock,
+					"maxIdleTime":      maxIdleTime,
+					"admissionLimits":  admissionLimits,
 					"porthttp":         portHttp,
 					"portP2p":          portP2p,
 					"hostP2p":          hostP2p,
 					"advertiseAddress": advertiseAddress,
 					"peerAddresses":    peerAddresses,
 					"bulkLoading":      bulkLoading,
+					"legacySignatures": legacySignatures,
 					"loglevel":         loglevel,
 				}).Info("configurations: ")
 
@@ -199,6 +249,51 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:     "import",
+			Aliases:  []string{"i"},
+			Usage:    "sign and import the documents of a NDJSON or CSV file to a collection",
+			HelpName: "blocace import",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "server, s",
+					Value:       "http://localhost:6899",
+					Usage:       "the URL of the Blocace server. The server needs the bulk loading API enabled",
+					Destination: &importServer,
+				},
+				cli.StringFlag{
+					Name:        "collection, c",
+					Value:       "default",
+					Usage:       "the collection to import the documents to",
+					Destination: &importCollection,
+				},
+				cli.StringFlag{
+					Name:        "file, f",
+					Value:       "-",
+					Usage:       "the file to import. - reads the standard input",
+					Destination: &importFile,
+				},
+				cli.StringFlag{
+					Name:        "format, t",
+					Usage:       "ndjson (a raw document per line) or csv (a header row and the columns mapped to the fields of the collection). By default csv if the file ends with .csv, otherwise ndjson",
+					Destination: &importFormat,
+				},
+				cli.StringFlag{
+					Name:        "privateKey, k",
+					Usage:       "the private key of the account to sign the documents with",
+					Destination: &importPrivateKey,
+				},
+				cli.IntFlag{
+					Name:        "offset, o",
+					Value:       0,
+					Usage:       "the lines of the file to skip, to resume an import",
+					Destination: &importOffset,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return importDocuments()
+			},
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -235,9 +330,11 @@ func server() {
 	time.Sleep(200 * time.Millisecond) // wait for p2p connection to release before sending another request
 	p.SyncAccountsFromPeers()
 	time.Sleep(200 * time.Millisecond)
+	p.SyncDelegationsFromPeers()
+	time.Sleep(200 * time.Millisecond)
 	p.SyncPeerBlockchains()
 
-	r = pool.NewReceiver(p, maxTxsPerBlock, maxTimeToGenerateBlock)
+	r = pool.NewReceiver(p, maxTxsPerBlock, maxBytesPerBlock, maxTimeToGenerateBlock, maxIdleTime, dataDir+filepath.Dir("/")+"pending.wal", admissionLimits, legacySignatures == "true")
 	go r.Monitor()
 
 	httpHandler := webapi.NewHTTPHandler(p.BlockchainForest, r, p, secret, version)
@@ -246,22 +343,38 @@ func server() {
 	router.Handle("/", httpHandler)
 	router.HandleFunc("/jwt", httpHandler.HandleJWT).Methods("POST", "GET")
 	router.HandleFunc("/jwt/challenge/{address}", httpHandler.JWTChallenge).Methods("GET")
-	router.HandleFunc("/peers", httpHandler.HandlePeers).Methods("GET")                                             // user
-	router.HandleFunc("/info", httpHandler.HandleInfo).Methods("GET")                                               // user
-	router.HandleFunc("/block/{blockchainId}/{blockId}", httpHandler.HandleBlockInfo).Methods("GET")                // user
-	router.HandleFunc("/verification/{blockchainId}/{blockId}/{txId}", httpHandler.HandleMerklePath).Methods("GET") // user
-	router.HandleFunc("/search/{collection}", httpHandler.HandleSearch).Methods("POST", "GET")                      // user
-	router.HandleFunc("/document/{collection}", httpHandler.HandleTransaction).Methods("POST")                      // user
-	router.HandleFunc("/collection", httpHandler.CollectionMappingCreation).Methods("POST")                         // admin
-	router.HandleFunc("/collections", httpHandler.CollectionList).Methods("GET")                                    // user
-	router.HandleFunc("/collection/{name}", httpHandler.CollectionMappingGet).Methods("GET")                        // user
+	router.HandleFunc("/peers", httpHandler.HandlePeers).Methods("GET")                                                        // user
+	router.HandleFunc("/info", httpHandler.HandleInfo).Methods("GET")                                                          // user
+	router.HandleFunc("/pool", httpHandler.HandlePoolStats).Methods("GET")                                                     // admin
+	router.HandleFunc("/block/{blockchainId}/{blockId}", httpHandler.HandleBlockInfo).Methods("GET")                           // user
+	router.HandleFunc("/verification/{blockchainId}/{blockId}/{txId}", httpHandler.HandleMerklePath).Methods("GET")            // user
+	router.HandleFunc("/search", httpHandler.HandleMultiSearch).Methods("POST")                                                // user
+	router.HandleFunc("/search/{collection}", httpHandler.HandleSearch).Methods("POST", "GET")                                 // user
+	router.HandleFunc("/search/{collection}/similar", httpHandler.HandleSimilarSearch).Methods("POST")                         // user
+	router.HandleFunc("/subscribe/{collection}", httpHandler.HandleSubscription).Methods("GET")                                // user
+	router.HandleFunc("/document/{collection}", httpHandler.HandleTransaction).Methods("POST")                                 // user
+	router.HandleFunc("/transaction/{txId}", httpHandler.HandleTransactionStatus).Methods("GET")                               // user
+	router.HandleFunc("/document/{blockchainId}/{blockId}/{txId}", httpHandler.HandleDocumentGet).Methods("GET")               // user
+	router.HandleFunc("/document/{blockchainId}/{blockId}/{txId}/original", httpHandler.HandleDocumentOriginal).Methods("GET") // user
+	router.HandleFunc("/collection", httpHandler.CollectionMappingCreation).Methods("POST")                                    // admin
+	router.HandleFunc("/collections", httpHandler.CollectionList).Methods("GET")                                               // user
+	router.HandleFunc("/collection/{name}", httpHandler.CollectionMappingGet).Methods("GET")                                   // user
+	router.HandleFunc("/collection/{name}", httpHandler.CollectionDrop).Methods("DELETE")                                      // admin
+	router.HandleFunc("/collection/{name}/stats", httpHandler.CollectionStatsGet).Methods("GET")                               // user
+	router.HandleFunc("/collection/{name}/archive", httpHandler.CollectionArchive).Methods("POST")                             // admin
+	router.HandleFunc("/collection/{name}/restore", httpHandler.CollectionRestore).Methods("POST")                             // admin
+	router.HandleFunc("/collection/{name}/alias/{alias}", httpHandler.CollectionAliasCreation).Methods("POST")                 // admin
 	router.HandleFunc("/account", httpHandler.AccountRegistration).Methods("POST")
-	router.HandleFunc("/account/{address}", httpHandler.AccountUpdate).Methods("POST")                    // admin
-	router.HandleFunc("/account/{address}", httpHandler.AccountGet).Methods("GET")                        // user
-	router.HandleFunc("/setaccountpermission/{address}", httpHandler.SetAccountReadWrite).Methods("POST") // admin
+	router.HandleFunc("/account/{address}", httpHandler.AccountUpdate).Methods("POST")                                // admin
+	router.HandleFunc("/account/{address}/key", httpHandler.AccountKeyRotation).Methods("POST")                       // user
+	router.HandleFunc("/account/{address}/delegation", httpHandler.DelegationCreation).Methods("POST")                // user
+	router.HandleFunc("/account/{address}/delegation/{delegate}", httpHandler.DelegationRevocation).Methods("DELETE") // user
+	router.HandleFunc("/account/{address}", httpHandler.AccountGet).Methods("GET")                                    // user
+	router.HandleFunc("/setaccountpermission/{address}", httpHandler.SetAccountReadWrite).Methods("POST")             // admin
 
 	if bulkLoading == "true" {
-		router.HandleFunc("/bulk/{collection}", httpHandler.HandleTransactionBulk).Methods("POST") // everyone
+		router.HandleFunc("/bulk/{collection}", httpHandler.HandleTransactionBulk).Methods("POST") // user
+		router.HandleFunc("/import/{collection}", httpHandler.HandleImport).Methods("POST")        // user
 	}
 
 	handler := cors.AllowAll().Handler(router)
@@ -276,7 +389,7 @@ func server() {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	sigs := make(chan os.Signal)
+	sigs := make(chan os.Signal, 1)
 	done := make(chan bool)
 
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,40 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BlockAckP2P acknowledges to the origin of a block that the block has been replicated. The sender of the message is the replica
+type BlockAckP2P struct {
+	PeerId    []byte // the blockchain of the block
+	BlockHash []byte
+}
+
+// Marshal serializes BlockAckP2P
+func (b BlockAckP2P) Marshal() []byte {
+	var result bytes.Buffer
+
+	encoder := gob.NewEncoder(&result)
+	err := encoder.Encode(b)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return result.Bytes()
+}
+
+// unmarshalBlockAckP2P deserializes encoded bytes to BlockAckP2P object
+func unmarshalBlockAckP2P(b []byte) (BlockAckP2P, error) {
+	var blockAckP2P BlockAckP2P
+
+	decoder := gob.NewDecoder(bytes.NewReader(b))
+	err := decoder.Decode(&blockAckP2P)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return blockAckP2P, err
+}
@@ -35,15 +35,16 @@ func (b BlockP2P) Marshal() []byte {
 	return result.Bytes()
 }
 
-// MapToBlock verified each transaction in the BlockP2P on wire and convert it to blockchain.Block
+// MapToBlock verified each transaction in the BlockP2P on wire by the key type of its author and convert it to blockchain.Block. Only the genesis transaction
+// of the genesis block may be unsigned. Whether the key was the one of the author at the time is up to BlockchainForest.AddBlock
 func (b BlockP2P) MapToBlock() (*blockchain.Block, error) {
 	var transactions []*blockchain.Transaction
 
 	// cannot use range here because the memory address for elements being iterated is always the same one
 	for i := 0; i < len(b.Transactions); i++ {
-		if bytes.Compare(b.Transactions[i].Signature, []byte{}) == 0 { // skipping for genisis transation and bulk loading
+		if b.Height == 0 && b.Transactions[i].IsGenesis() {
 			transactions = append(transactions, &b.Transactions[i])
-		} else if blockchain.IsValidSig(b.Transactions[i].RawData, b.Transactions[i].PubKey, b.Transactions[i].Signature) {
+		} else if blockchain.IsValidTransactionSig(&b.Transactions[i]) {
 			transactions = append(transactions, &b.Transactions[i])
 		} else {
 			transactions = nil
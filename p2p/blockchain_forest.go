@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -19,26 +20,95 @@ import (
 
 const peerBlockchainDir = "peers"
 
+// the flags of the peer documents which don't pass the local checks. The signed history of the peer is kept as is
+const (
+	flagInvalid   = "invalid"   // failed the validation of the local mapping, its envelope has expired or its key is not the one of its author as known locally
+	flagDuplicate = "duplicate" // a replay of a signed document in another blockchain
+)
+
 // BlockchainForest defines the local and peer chains
 type BlockchainForest struct {
 	Local *blockchain.Blockchain
 	Peers map[string]*blockchain.Blockchain
+
+	blockAckListeners     []BlockAckListener
+	blockAckListenersLock sync.Mutex
 }
 
-// AddBlock persist the broadcasted or requested block from a peer to local peer blockchain db and index it
-func (b *BlockchainForest) AddBlock(blockP2p BlockP2P) {
+// BlockAckListener is called after a peer has acknowledged a local block. It must not block
+type BlockAckListener func(blockHash []byte, peerId []byte)
+
+// AddBlockAckListener registers a listener to be notified of the acknowledgements of the local blocks
+func (b *BlockchainForest) AddBlockAckListener(listener BlockAckListener) {
+	b.blockAckListenersLock.Lock()
+	defer b.blockAckListenersLock.Unlock()
+
+	b.blockAckListeners = append(b.blockAckListeners, listener)
+}
+
+// RecordBlockAck records that a peer has replicated a local block and notifies the listeners
+func (b *BlockchainForest) RecordBlockAck(blockHash []byte, peerId []byte) error {
+	if err := b.Local.AddBlockAck(blockHash, peerId); err != nil {
+		return err
+	}
+
+	b.blockAckListenersLock.Lock()
+	defer b.blockAckListenersLock.Unlock()
+
+	for _, listener := range b.blockAckListeners {
+		listener(blockHash, peerId)
+	}
+	return nil
+}
+
+// AddBlock persist the broadcasted or requested block from a peer to local peer blockchain db and index it. Returns an error if the block is abandoned
+func (b *BlockchainForest) AddBlock(blockP2p BlockP2P) error {
 	peerIdStr := fmt.Sprintf("%x", blockP2p.PeerId)
 
 	block, err := blockP2p.MapToBlock()
 
 	if err != nil {
 		log.Error(err)
-		return
+		return err
 	}
 
 	if bytes.Compare(block.Hash, block.SetHash()) != 0 {
 		log.Errorf("block hash verification failed, abandon this block")
-		return
+		return errors.New("block hash verification failed")
+	}
+
+	// apply the same validation rules as the local writes for the collections known locally. A document which fails them is flagged
+	flags := make(map[string]string)
+	for _, tx := range block.Transactions {
+		documentMapping, err := b.Local.Search.GetDocumentMapping(tx.Collection)
+		if err != nil {
+			continue
+		}
+
+		fieldErrorMapping, err := documentMapping.Validate(tx.RawData)
+		if err != nil || fieldErrorMapping != nil {
+			log.Warnf("document %x in collection %s failed the validation: %v %v, flagging it as %s", tx.ID, tx.Collection, fieldErrorMapping, err, flagInvalid)
+			flags[string(tx.ID)] = flagInvalid
+		}
+	}
+
+	// a signed document must not be a replay of one in any blockchain. A replay is flagged and the original keeps its envelope.
+	// The key of a document must have been the one of its author, which the local accounts may not know yet after a key rotation or of a delegation
+	rejectedEnvelopes := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		if !tx.IsGenesis() && !b.Local.IsAuthorKey(tx) {
+			log.Warnf("document %x is not signed with the key of its author as known locally, flagging it as %s", tx.ID, flagInvalid)
+			flags[string(tx.ID)] = flagInvalid
+			rejectedEnvelopes[string(tx.ID)] = true
+		} else if err = b.Local.CheckEnvelope(tx, false); err != nil {
+			flag := flagInvalid
+			if envelopeErr, ok := err.(*blockchain.EnvelopeError); ok && envelopeErr.IsReplay {
+				flag = flagDuplicate
+			}
+			log.Warnf("document %x is rejected: %s, flagging it as %s", tx.ID, err, flag)
+			flags[string(tx.ID)] = flag
+			rejectedEnvelopes[string(tx.ID)] = true
+		}
 	}
 
 	if b.Peers[peerIdStr] == nil {
@@ -76,7 +146,7 @@ func (b *BlockchainForest) AddBlock(blockP2p BlockP2P) {
 		}
 	} else if bytes.Compare(b.Peers[peerIdStr].Tip, block.Hash) == 0 {
 		log.Infof("peer %s tip is already up-to-date: %x", peerIdStr, b.Peers[peerIdStr].Tip)
-		return
+		return nil
 	}
 
 	if blockP2p.IsTip {
@@ -86,13 +156,25 @@ func (b *BlockchainForest) AddBlock(blockP2p BlockP2P) {
 	_, err = block.Persist(b.Peers[peerIdStr].Db, blockP2p.IsTip)
 	if err != nil {
 		log.Error(err)
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		if rejectedEnvelopes[string(tx.ID)] {
+			continue
+		}
+		if err = b.Local.RecordEnvelope(tx); err != nil {
+			log.Error(err)
+		}
 	}
 
 	start := time.Now().UnixNano()
 	log.Debugf("start indexing the block at %d for peer blockchain %s...", start, peerIdStr)
-	b.Local.Search.IndexBlock(block, blockP2p.PeerId)
+	b.Local.Search.IndexFlaggedBlock(block, blockP2p.PeerId, flags)
 	end := time.Now().UnixNano()
 	log.Debug("end indexing the block:" + strconv.FormatInt(end, 10) + ", duration:" + strconv.FormatInt((end-start)/1000000, 10) + "ms")
+
+	return nil
 }
 
 // GetBlock returns a local or peer block as requested
@@ -161,6 +243,14 @@ func (b *BlockchainForest) GetBlock(peerId []byte, blockId []byte, blockOnly boo
 		TotalTransactions: block.TotalTransactions, Transactions: transactions}
 }
 
+// GetLocalTipBlock returns the tip block of the local blockchain marked as the tip, so that the peers move their tip and height of the local blockchain forward to it
+func (b *BlockchainForest) GetLocalTipBlock() BlockP2P {
+	tipBlock := b.GetBlock(b.Local.PeerId, b.Local.Tip, false)
+	tipBlock.IsTip = true
+
+	return tipBlock
+}
+
 // NewBlockchainForest initializes the peer blockchains by reading existing dbs from peerBlockchainDir which will be created should not exist
 func NewBlockchainForest(bcLocal *blockchain.Blockchain) *BlockchainForest {
 	peers := make(map[string]*blockchain.Blockchain)
@@ -212,9 +302,13 @@ func NewBlockchainForest(bcLocal *blockchain.Blockchain) *BlockchainForest {
 				log.Warnf("cannot get peerId of blockchain at %s: %s", peerBlockchainsDirRoot+filepath.Dir("/")+file.Name(), err.Error())
 				continue
 			}
+
+			if err = blockchain.BackfillTxIndex(db); err != nil {
+				log.Warnf("cannot index the transactions of blockchain at %s: %s", peerBlockchainsDirRoot+filepath.Dir("/")+file.Name(), err.Error())
+			}
 			peers[fmt.Sprintf("%x", peerId)] = &blockchain.Blockchain{Tip: tip, PeerId: peerId, Db: db, DataDir: bcLocal.DataDir}
 		}
 	}
 
-	return &BlockchainForest{bcLocal, peers}
+	return &BlockchainForest{Local: bcLocal, Peers: peers}
 }
@@ -0,0 +1,233 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/perlin-network/noise"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+// newTestForest creates a local blockchain with a collection of orders which requires a non-negative quantity. The returned function removes it
+func newTestForest(t *testing.T) (*BlockchainForest, func()) {
+	dir, err := ioutil.TempDir("", "forest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc := blockchain.CreateBlockchain(filepath.Join(dir, "blockchain.db"), dir)
+	if _, err = bc.Search.CreateMappingByJson([]byte(`{"collection": "orders", "fields": {"qty": {"type": "number", "required": true, "min": 0}}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	bf := NewBlockchainForest(bc)
+	return bf, func() {
+		for _, peer := range bf.Peers {
+			peer.Db.Close()
+		}
+		bc.Db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newPeerBlock builds the tip block of a peer with the transactions
+func newPeerBlock(peerId []byte, height uint64, txs ...*blockchain.Transaction) BlockP2P {
+	block := blockchain.NewBlock(txs, []byte{}, height)
+	blockP2p := BlockP2P{PeerId: peerId, Timestamp: block.Timestamp, PrevBlockHash: block.PrevBlockHash, Height: block.Height, Hash: block.Hash,
+		IsTip: true, TotalTransactions: block.TotalTransactions}
+	for _, tx := range txs {
+		blockP2p.Transactions = append(blockP2p.Transactions, *tx)
+	}
+
+	return blockP2p
+}
+
+// newOrder creates a transaction of an order signed by a new account
+func newOrder(peerId []byte, rawData string) *blockchain.Transaction {
+	authorKey, _ := crypto.GenerateKey()
+	tx := blockchain.NewTransaction(peerId, []byte(rawData), "orders", crypto.FromECDSAPub(&authorKey.PublicKey), nil, nil)
+	digest, _ := blockchain.TransactionDigest(tx, "")
+	tx.Signature = blockchain.Sign(*authorKey, digest)
+
+	return tx
+}
+
+func TestAddBlockFlagsInvalidDocuments(t *testing.T) {
+	bf, stop := newTestForest(t)
+	defer stop()
+
+	peerId, _, _ := noise.GenerateKeys(nil)
+	if err := bf.AddBlock(newPeerBlock(peerId[:], 1, newOrder(peerId[:], `{"qty": 1}`), newOrder(peerId[:], `{"qty": -1}`))); err != nil {
+		t.Fatalf("a peer block should be kept even if its documents fail the local validation: %s", err)
+	}
+
+	index := bf.Local.Search.BlockchainIndices["orders"]
+	if count, _ := index.DocCount(); count != 2 {
+		t.Errorf("all the documents of the peer block should be indexed: %d", count)
+	}
+
+	if total := countFlagged(t, index, flagInvalid); total != 1 {
+		t.Errorf("the invalid document should be flagged: %d", total)
+	}
+}
+
+func TestAddBlockFlagsReplays(t *testing.T) {
+	bf, stop := newTestForest(t)
+	defer stop()
+
+	authorKey, _ := crypto.GenerateKey()
+	author := crypto.PubkeyToAddress(authorKey.PublicKey).String()
+	account := blockchain.Account{PublicKey: hex.EncodeToString(crypto.FromECDSAPub(&authorKey.PublicKey)), Role: blockchain.Role{Name: "user"}}
+	if err := bf.Local.RegisterAccount([]byte(author), account); err != nil {
+		t.Fatal(err)
+	}
+
+	peerId, _, _ := noise.GenerateKeys(nil)
+	tx := blockchain.NewTransaction(peerId[:], []byte(`{"qty": 1}`), "orders", crypto.FromECDSAPub(&authorKey.PublicKey), nil, nil)
+	tx.Nonce = 1
+	tx.Address = author
+	digest, _ := blockchain.TransactionDigest(tx, author)
+	tx.Signature = blockchain.Sign(*authorKey, digest)
+	if err := bf.AddBlock(newPeerBlock(peerId[:], 1, tx)); err != nil {
+		t.Fatal(err)
+	}
+
+	// another peer replays the signed document
+	otherPeerId, _, _ := noise.GenerateKeys(nil)
+	replay := *tx
+	replay.SetID()
+	if err := bf.AddBlock(newPeerBlock(otherPeerId[:], 1, &replay)); err != nil {
+		t.Fatalf("a peer block should be kept even if it replays a signed document: %s", err)
+	}
+
+	if total := countFlagged(t, bf.Local.Search.BlockchainIndices["orders"], flagDuplicate); total != 1 {
+		t.Errorf("the replay should be flagged as a duplicate: %d", total)
+	}
+	if err := bf.Local.CheckEnvelope(tx, false); err != nil {
+		t.Errorf("the original document should keep its envelope: %s", err)
+	}
+}
+
+func TestAddBlockFlagsUnknownAuthorKeys(t *testing.T) {
+	bf, stop := newTestForest(t)
+	defer stop()
+
+	authorKey, _ := crypto.GenerateKey()
+	author := crypto.PubkeyToAddress(authorKey.PublicKey).String()
+	account := blockchain.Account{PublicKey: hex.EncodeToString(crypto.FromECDSAPub(&authorKey.PublicKey)), Role: blockchain.Role{Name: "user"}}
+	if err := bf.Local.RegisterAccount([]byte(author), account); err != nil {
+		t.Fatal(err)
+	}
+
+	// signed with a rotated key the local accounts don't know yet
+	rotatedKey, _ := crypto.GenerateKey()
+	peerId, _, _ := noise.GenerateKeys(nil)
+	tx := blockchain.NewTransaction(peerId[:], []byte(`{"qty": 1}`), "orders", crypto.FromECDSAPub(&rotatedKey.PublicKey), nil, nil)
+	tx.Nonce = 1
+	tx.Address = author
+	digest, _ := blockchain.TransactionDigest(tx, author)
+	tx.Signature = blockchain.Sign(*rotatedKey, digest)
+	if err := bf.AddBlock(newPeerBlock(peerId[:], 1, tx, newOrder(peerId[:], `{"qty": 2}`))); err != nil {
+		t.Fatalf("a peer block should be kept even if the key of a document is not known locally: %s", err)
+	}
+
+	if total := countFlagged(t, bf.Local.Search.BlockchainIndices["orders"], flagInvalid); total != 1 {
+		t.Errorf("the document with the unknown key should be flagged: %d", total)
+	}
+	if err := bf.Local.CheckEnvelope(tx, false); err != nil {
+		t.Errorf("the envelope of the flagged document should not be recorded: %s", err)
+	}
+}
+
+func TestAddBlockRejectsUnsignedDocuments(t *testing.T) {
+	bf, stop := newTestForest(t)
+	defer stop()
+
+	victimKey, _ := crypto.GenerateKey()
+	victim := crypto.PubkeyToAddress(victimKey.PublicKey).String()
+	unsigned := blockchain.NewTransaction(nil, []byte(`{"qty": 1}`), "orders", nil, nil, nil)
+	unsigned.Address = victim
+
+	peerId, _, _ := noise.GenerateKeys(nil)
+	if err := bf.AddBlock(newPeerBlock(peerId[:], 1, unsigned)); err == nil {
+		t.Error("a peer block with an unsigned document should be abandoned")
+	}
+	if err := bf.AddBlock(newPeerBlock(peerId[:], 0, unsigned)); err == nil {
+		t.Error("a genesis block with an unsigned document of an author should be abandoned")
+	}
+	if err := bf.AddBlock(newPeerBlock(peerId[:], 0, blockchain.NewCoinbaseTX(peerId[:]))); err != nil {
+		t.Errorf("the unsigned genesis transaction should be accepted: %s", err)
+	}
+}
+
+func TestAddBlockMovesPeerTip(t *testing.T) {
+	origin, stopOrigin := newTestForest(t)
+	defer stopOrigin()
+	bf, stop := newTestForest(t)
+	defer stop()
+
+	for height := uint64(1); height <= 2; height++ {
+		newBlockHash := origin.Local.AddBlock([]*blockchain.Transaction{newOrder(origin.Local.PeerId, `{"qty": 1}`)})
+		if err := bf.AddBlock(origin.GetLocalTipBlock()); err != nil {
+			t.Fatal(err)
+		}
+
+		peer := bf.Peers[fmt.Sprintf("%x", origin.Local.PeerId)]
+		var peerHeight []byte
+		peer.Db.View(func(dbtx *bolt.Tx) error {
+			peerHeight = dbtx.Bucket([]byte(blockchain.BlocksBucket)).Get([]byte("b"))
+			return nil
+		})
+		if !bytes.Equal(peer.Tip, newBlockHash) || string(peerHeight) != fmt.Sprint(height) {
+			t.Errorf("the peer blockchain should move forward to the broadcast tip: %x %s", peer.Tip, peerHeight)
+		}
+	}
+}
+
+func TestNewBlockchainForestBackfillsPeerTxIndex(t *testing.T) {
+	origin, stopOrigin := newTestForest(t)
+	defer stopOrigin()
+	bf, stop := newTestForest(t)
+	defer stop()
+
+	order := newOrder(origin.Local.PeerId, `{"qty": 1}`)
+	origin.Local.AddBlock([]*blockchain.Transaction{order})
+	if err := bf.AddBlock(origin.GetLocalTipBlock()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a peer blockchain persisted before the transaction index existed
+	peerId := fmt.Sprintf("%x", origin.Local.PeerId)
+	peer := bf.Peers[peerId]
+	peer.Db.Update(func(dbtx *bolt.Tx) error {
+		dbtx.Bucket([]byte(blockchain.BlocksBucket)).Delete([]byte(blockchain.TxIndexBackfilledKey))
+		return dbtx.DeleteBucket([]byte(blockchain.TxIndexBucket))
+	})
+	peer.Db.Close()
+
+	bf.Peers = NewBlockchainForest(bf.Local).Peers
+	if tx, block, err := bf.Peers[peerId].FindTransaction(order.ID); err != nil || tx == nil || block.Height != 1 {
+		t.Errorf("expected the transaction of the peer backfilled in block 1, actual: %v %v %v", tx, block, err)
+	}
+}
+
+// countFlagged returns the number of documents of the index with the flag
+func countFlagged(t *testing.T, index bleve.Index, flag string) uint64 {
+	flagQuery := bleve.NewTermQuery(flag)
+	flagQuery.SetField("_flag")
+	searchResponse, err := index.Search(bleve.NewSearchRequest(flagQuery))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return searchResponse.Total
+}
@@ -0,0 +1,41 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+// DelegationsP2P represents the delegations and revocations from a peer by key, see blockchain.Delegation.Key
+type DelegationsP2P struct {
+	Delegations map[string]blockchain.Delegation
+}
+
+// Marshal serializes DelegationsP2P
+func (d DelegationsP2P) Marshal() []byte {
+	var result bytes.Buffer
+
+	encoder := gob.NewEncoder(&result)
+	err := encoder.Encode(d)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return result.Bytes()
+}
+
+// unmarshalDelegationsP2P deserializes encoded bytes to DelegationsP2P object
+func unmarshalDelegationsP2P(d []byte) (DelegationsP2P, error) {
+	var delegationsP2p DelegationsP2P
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&delegationsP2p)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return delegationsP2p, err
+}
@@ -87,6 +87,13 @@ func (p *P2P) SyncAccountsFromPeers() {
 	}
 }
 
+// SyncDelegationsFromPeers sends rpc to peers to sync the delegations and revocations
+func (p *P2P) SyncDelegationsFromPeers() {
+	for _, id := range p.overlay.Table().Peers() {
+		sendDelegationsRequest(p.Node, id, p.BlockchainForest.Local)
+	}
+}
+
 // SyncMappingsFromPeers sends rpc to peers to sync the mappings
 func (p *P2P) SyncMappingsFromPeers() {
 	for _, id := range p.overlay.Table().Peers() {
@@ -140,9 +147,11 @@ func NewP2P(bc *blockchain.Blockchain, bindHost string, bindPort uint16, adverti
 	// Register the chatMessage Go type to the node with an associated unmarshal function.
 	node.RegisterMessage(RequestP2P{}, unmarshalRequestP2P)
 	node.RegisterMessage(AccountsP2P{}, unmarshalAccountsP2P)
+	node.RegisterMessage(DelegationsP2P{}, unmarshalDelegationsP2P)
 	node.RegisterMessage(MappingsP2P{}, unmarshalMappingsP2P)
 	node.RegisterMessage(ChallengeWordP2P{}, unmarshalChallengeWordP2P)
 	node.RegisterMessage(BlockP2P{}, unmarshalBlockP2P)
+	node.RegisterMessage(BlockAckP2P{}, unmarshalBlockAckP2P)
 
 	// Register a message handler to the node.
 	node.Handle(func(ctx noise.HandlerContext) error {
@@ -161,6 +170,9 @@ func NewP2P(bc *blockchain.Blockchain, bindHost string, bindPort uint16, adverti
 			case accountsRequestType:
 				ctx.SendMessage(handleAccountsRequest(requestP2P, accounts))
 				accountsRequestReverse(requestP2P, accounts, node, ctx.ID(), blockchainForest.Local) // sync new accounts from remote
+			case delegationsRequestType:
+				ctx.SendMessage(handleDelegationsRequest(requestP2P, bc))
+				delegationsRequestReverse(requestP2P, node, ctx.ID(), bc) // sync new delegations from remote
 			case mappingsRequestType:
 				ctx.SendMessage(handleMappingsRequest(requestP2P, mappings))
 				mappingsRequestReverse(requestP2P, mappings, node, ctx.ID(), blockchainForest.Local.Search) // sync new mappings from remote
@@ -191,11 +203,21 @@ func NewP2P(bc *blockchain.Blockchain, bindHost string, bindPort uint16, adverti
 						}
 					}
 				}
+			case DelegationsP2P:
+				for _, delegation := range objectP2p.Delegations {
+					// a delegation which is not signed by its principal is skipped
+					if _, err = bc.MergeDelegation(delegation); err != nil {
+						if _, ok := err.(*blockchain.DelegationError); !ok {
+							return err
+						}
+						log.Warnf("delegation of %s to %s from %s is rejected: %s", delegation.Principal, delegation.Delegate, ctx.ID().Address, err)
+					}
+				}
 			case MappingsP2P:
 				for mappingName, mapping := range objectP2p.Mappings {
-					if funk.IsEmpty(mappings[mappingName]) {
+					if funk.IsEmpty(mappings[mappingName]) || mappings[mappingName].LastModified < mapping.LastModified {
 						mappings[mappingName] = mapping // update the cache
-						if _, err = bc.Search.CreateMapping(mapping); err != nil {
+						if err = bc.Search.ApplyMapping(mapping); err != nil {
 							return err
 						}
 					}
@@ -206,7 +228,16 @@ func NewP2P(bc *blockchain.Blockchain, bindHost string, bindPort uint16, adverti
 				}
 			case BlockP2P:
 				log.Debugf("BlockFromPeer: %s(%s) > %+x; height: %d\n", ctx.ID().Address, ctx.ID().ID.String(), objectP2p.Hash, objectP2p.Height)
-				blockchainForest.AddBlock(objectP2p)
+				// the origin broadcasts its own blocks
+				if sender := ctx.ID(); blockchainForest.AddBlock(objectP2p) == nil && bytes.Compare(objectP2p.PeerId, sender.ID[:]) == 0 {
+					go sendBlockAck(node, sender, objectP2p)
+				}
+			case BlockAckP2P:
+				if sender := ctx.ID(); bytes.Compare(objectP2p.PeerId, bc.PeerId) == 0 {
+					if err = blockchainForest.RecordBlockAck(objectP2p.BlockHash, sender.ID[:]); err != nil {
+						log.Warnf("cannot record the acknowledgement from %s: %s", sender.ID.String(), err)
+					}
+				}
 			case RequestP2P:
 				ctx.SendMessage(handleBlockRequest(objectP2p, blockchainForest))
 			default:
@@ -360,7 +391,19 @@ func handleAccountsRequest(request RequestP2P, accountsLocal map[string]blockcha
 	return AccountsP2P{Accounts: accountsToSend}
 }
 
-// handleMappingsRequest returns the new mappings which the peer doesn't have
+// handleDelegationsRequest returns the delegations and revocations which the peer doesn't have or has a older version of
+func handleDelegationsRequest(request RequestP2P, bcLocal *blockchain.Blockchain) DelegationsP2P {
+	delegationsToSend := make(map[string]blockchain.Delegation)
+	for key, delegation := range bcLocal.GetDelegations() {
+		if funk.IsEmpty(request.RequestParameters[key]) || delegation.LastModified > parseLastModified(request.RequestParameters[key]) {
+			delegationsToSend[key] = delegation
+		}
+	}
+
+	return DelegationsP2P{Delegations: delegationsToSend}
+}
+
+// handleMappingsRequest returns the new mappings which the peer doesn't have or has a older version of, including the dropped ones
 func handleMappingsRequest(request RequestP2P, mappingsLocal map[string]blockchain.DocumentMapping) MappingsP2P {
 	mappingsToSend := make(map[string]blockchain.DocumentMapping)
 	for collectionName, mapping := range mappingsLocal {
@@ -369,6 +412,8 @@ func handleMappingsRequest(request RequestP2P, mappingsLocal map[string]blockcha
 		}
 		if funk.IsEmpty(request.RequestParameters[collectionName]) {
 			mappingsToSend[collectionName] = mapping
+		} else if mapping.LastModified > parseLastModified(request.RequestParameters[collectionName]) {
+			mappingsToSend[collectionName] = mapping
 		}
 	}
 
@@ -381,8 +426,7 @@ func handleBlockRequest(request RequestP2P, bf *BlockchainForest) BlockP2P {
 	if !funk.IsEmpty(request.RequestParameters["local"]) {
 		requestValue := request.RequestParameters["local"]
 		if requestValue == "tip" {
-			blockToReturn = bf.GetBlock(bf.Local.PeerId, bf.Local.Tip, false)
-			blockToReturn.IsTip = true // mark as tip for peer to process
+			blockToReturn = bf.GetLocalTipBlock() // mark as tip for peer to process
 
 		} else {
 			blockId, err := hex.DecodeString(requestValue)
@@ -409,20 +453,29 @@ func handleBlockRequest(request RequestP2P, bf *BlockchainForest) BlockP2P {
 	return blockToReturn
 }
 
-// mappingsRequestReverse checks if a peer has mapping(s) that is new and request for them
+// mappingsRequestReverse checks if a peer has mapping(s) that is new or newer and request for them
 func mappingsRequestReverse(request RequestP2P, mappingsLocal map[string]blockchain.DocumentMapping, node *noise.Node, id noise.ID, search *blockchain.Search) {
-	for _, mapping := range request.RequestParameters {
-		if mapping == "default" {
+	for collectionName, peerLastModified := range request.RequestParameters {
+		if collectionName == "default" {
 			continue
 		}
 
-		if funk.IsEmpty(mappingsLocal[mapping]) {
+		if funk.IsEmpty(mappingsLocal[collectionName]) || parseLastModified(peerLastModified) > mappingsLocal[collectionName].LastModified {
 			sendMappingsRequest(mappingsLocal, node, id, search)
 			break
 		}
 	}
 }
 
+// parseLastModified parses the lastModified of a mapping from the request parameters. The peers of older versions send the collection name instead
+func parseLastModified(lastModified string) int64 {
+	lastModifiedLong, err := strconv.ParseInt(lastModified, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return lastModifiedLong
+}
+
 // accountsRequestReverse checks if a peer has accounts(s) that is new and request for them
 func accountsRequestReverse(request RequestP2P, accountsLocal map[string]blockchain.Account, node *noise.Node, id noise.ID, bcLocal *blockchain.Blockchain) {
 	for address, peerLastModified := range request.RequestParameters {
@@ -442,6 +495,17 @@ func accountsRequestReverse(request RequestP2P, accountsLocal map[string]blockch
 	}
 }
 
+// delegationsRequestReverse checks if a peer has delegation(s) or revocation(s) that is new or newer and request for them
+func delegationsRequestReverse(request RequestP2P, node *noise.Node, id noise.ID, bcLocal *blockchain.Blockchain) {
+	delegationsLocal := bcLocal.GetDelegations()
+	for key, peerLastModified := range request.RequestParameters {
+		if delegation, ok := delegationsLocal[key]; !ok || parseLastModified(peerLastModified) > delegation.LastModified {
+			sendDelegationsRequest(node, id, bcLocal)
+			break
+		}
+	}
+}
+
 // syncPeerBlockchain sends rpc to a peer to sync the peer blockchain to local
 func syncPeerBlockchain(node *noise.Node, id noise.ID, bf *BlockchainForest, reverse bool) {
 	log.Infof("start syncing blocks from peer %s (%s)...", id.Address, id.ID.String())
@@ -472,8 +536,8 @@ func syncPeerBlockchain(node *noise.Node, id noise.ID, bf *BlockchainForest, rev
 // sendMappingsRequest and update local mapping cache
 func sendMappingsRequest(mappingsLocal map[string]blockchain.DocumentMapping, node *noise.Node, id noise.ID, search *blockchain.Search) {
 	requestParameters := make(map[string]string)
-	for collectionName := range mappingsLocal {
-		requestParameters[collectionName] = collectionName // collectionName:collectionName
+	for collectionName, mapping := range mappingsLocal {
+		requestParameters[collectionName] = strconv.FormatInt(mapping.LastModified, 10) // collectionName:lastModified
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -495,10 +559,10 @@ func sendMappingsRequest(mappingsLocal map[string]blockchain.DocumentMapping, no
 	}
 
 	for collectionName, mapping := range mappingsFromPeer.Mappings {
-		if funk.IsEmpty(mappingsLocal[collectionName]) {
+		if funk.IsEmpty(mappingsLocal[collectionName]) || mappingsLocal[collectionName].LastModified < mapping.LastModified {
 			mappingsLocal[collectionName] = mapping // update the cache
 			log.Debugf("Collection: %s(%s) > %+v\n", id.Address, id.ID.String(), mapping)
-			if _, err = search.CreateMapping(mapping); err != nil {
+			if err = search.ApplyMapping(mapping); err != nil {
 				log.Error(err)
 			}
 		}
@@ -543,6 +607,41 @@ func sendAccountsRequest(accountsLocal map[string]blockchain.Account, node *nois
 	}
 }
 
+// sendDelegationsRequest and update the local delegations
+func sendDelegationsRequest(node *noise.Node, id noise.ID, bcLocal *blockchain.Blockchain) {
+	requestParameters := make(map[string]string)
+	for key, delegation := range bcLocal.GetDelegations() {
+		requestParameters[key] = strconv.FormatInt(delegation.LastModified, 10) // key:lastModified
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	delegationsFromPeerRes, err := node.RequestMessage(ctx, id.Address, RequestP2P{RequestType: delegationsRequestType, RequestParameters: requestParameters})
+	cancel()
+
+	if err != nil {
+		log.Errorf("failed to send delegation request message to %s(%s). Skipping... [error: %s]\n",
+			id.Address,
+			id.ID.String(),
+			err,
+		)
+		return
+	}
+
+	delegationsFromPeer, ok := delegationsFromPeerRes.(DelegationsP2P)
+	if !ok {
+		log.Error("cannot parse delegations from peer: " + id.ID.String())
+	}
+
+	for _, delegation := range delegationsFromPeer.Delegations {
+		isNewer, err := bcLocal.MergeDelegation(delegation)
+		if err != nil {
+			log.Error(err)
+		} else if isNewer {
+			log.Debugf("Delegation: %s(%s) > %+v\n", id.Address, id.ID.String(), delegation)
+		}
+	}
+}
+
 // sendBlockRequest and update peer blockchain locally
 func sendBlockRequest(requestParameters map[string]string, node *noise.Node, id noise.ID, bf *BlockchainForest) []byte {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -564,6 +663,20 @@ func sendBlockRequest(requestParameters map[string]string, node *noise.Node, id
 	}
 	log.Debugf("BlockFromPeer: %s(%s) > %+x; height: %d\n", id.Address, id.ID.String(), blockFromPeer.Hash, blockFromPeer.Height)
 
-	bf.AddBlock(blockFromPeer)
+	// acknowledge the blocks of the peer's own blockchain
+	if bf.AddBlock(blockFromPeer) == nil && !funk.IsEmpty(requestParameters["local"]) {
+		sendBlockAck(node, id, blockFromPeer)
+	}
 	return blockFromPeer.PrevBlockHash
 }
+
+// sendBlockAck acknowledges to the origin of a block that the block has been replicated locally
+func sendBlockAck(node *noise.Node, id noise.ID, blockP2p BlockP2P) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := node.SendMessage(ctx, id.Address, BlockAckP2P{PeerId: blockP2p.PeerId, BlockHash: blockP2p.Hash})
+	cancel()
+
+	if err != nil {
+		log.Warnf("failed to send block acknowledgement to %s(%s). [error: %s]\n", id.Address, id.ID.String(), err)
+	}
+}
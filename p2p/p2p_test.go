@@ -0,0 +1,45 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+func TestHandleDelegationsRequest(t *testing.T) {
+	bf, stop := newTestForest(t)
+	defer stop()
+
+	principalKey, _ := crypto.GenerateKey()
+	principal := crypto.PubkeyToAddress(principalKey.PublicKey).String()
+	account := blockchain.Account{PublicKey: hex.EncodeToString(crypto.FromECDSAPub(&principalKey.PublicKey)), Role: blockchain.Role{Name: "user"}}
+	if err := bf.Local.RegisterAccount([]byte(principal), account); err != nil {
+		t.Fatal(err)
+	}
+
+	delegations := []blockchain.Delegation{
+		{Principal: principal, Delegate: "0x2000000000000000000000000000000000000002", Collections: []string{"orders"}, NotBefore: 100, NotAfter: 1000, LastModified: 100},
+		{Principal: principal, Delegate: "0x3000000000000000000000000000000000000003", Collections: []string{"orders"}, NotBefore: 100, NotAfter: 1000, LastModified: 200, Revoked: true},
+	}
+	delegations[0].Signature = blockchain.Sign(*principalKey, crypto.Keccak256(delegations[0].SigningMessage()))
+	delegations[1].RevocationSignature = blockchain.Sign(*principalKey, crypto.Keccak256(delegations[1].RevocationSigningMessage(200)))
+	for _, delegation := range delegations {
+		if _, err := bf.Local.MergeDelegation(delegation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the peer has the first delegation and an older version of the revoked one
+	request := RequestP2P{RequestType: delegationsRequestType, RequestParameters: map[string]string{
+		delegations[0].Key(): strconv.FormatInt(delegations[0].LastModified, 10),
+		delegations[1].Key(): "150",
+	}}
+	delegationsToSend := handleDelegationsRequest(request, bf.Local).Delegations
+	if len(delegationsToSend) != 1 || !delegationsToSend[delegations[1].Key()].Revoked {
+		t.Errorf("only the revocation should be sent to the peer: %+v", delegationsToSend)
+	}
+}
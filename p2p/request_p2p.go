@@ -7,9 +7,10 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const accountsRequestType = "accounts" // address:lastModified
-const mappingsRequestType = "mappings" // collecionName:collectionName
-const blockRequestType = "block"       // peerId:blockId or local:[tip or blockId] (don't support multiple key-value pairs yet)
+const accountsRequestType = "accounts"       // address:lastModified
+const mappingsRequestType = "mappings"       // collecionName:collectionName
+const blockRequestType = "block"             // peerId:blockId or local:[tip or blockId] (don't support multiple key-value pairs yet)
+const delegationsRequestType = "delegations" // delegation key:lastModified
 
 // RequestP2P represents common p2p request body
 type RequestP2P struct {
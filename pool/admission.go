@@ -0,0 +1,167 @@
+package pool
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+// The limits a transaction can hit when it's admitted to the queue
+const (
+	LimitQueueLength = "queueLength"
+	LimitQueueBytes  = "queueBytes"
+	LimitAddress     = "address"
+	LimitCollection  = "collection"
+)
+
+// AdmissionLimits caps the transactions waiting for a block. No limit if 0
+type AdmissionLimits struct {
+	MaxQueueLength          int   `json:"maxQueueLength"`          // the transactions in the queue
+	MaxQueueBytes           int64 `json:"maxQueueBytes"`           // the raw documents in the queue
+	MaxPendingPerAddress    int   `json:"maxPendingPerAddress"`    // the transactions in the queue from an account
+	MaxPendingPerCollection int   `json:"maxPendingPerCollection"` // the transactions in the queue to a collection
+}
+
+// AdmissionError tells that a transaction is rejected by a limit and when to retry
+type AdmissionError struct {
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("too many pending transactions: the %s limit is reached", e.Limit)
+}
+
+// PoolStats has the depth of the queue, the rejected transactions by limit and the generated blocks by the reason to cut them
+type PoolStats struct {
+	QueueLength         int               `json:"queueLength"`
+	QueueBytes          int64             `json:"queueBytes"`
+	PendingByCollection map[string]int    `json:"pendingByCollection"`
+	Limits              AdmissionLimits   `json:"limits"`
+	Rejections          map[string]uint64 `json:"rejections"`
+	BlockInterval       int64             `json:"blockInterval"` // milliseconds
+	BlocksByCutReason   map[string]uint64 `json:"blocksByCutReason"`
+	RecentBlocks        []BlockCut        `json:"recentBlocks"` // the latest first
+}
+
+// admittedTx is what a transaction takes from the limits until it's in a block
+type admittedTx struct {
+	address    string
+	collection string
+	size       int64
+}
+
+// admission keeps track of the queued transactions against the limits
+type admission struct {
+	sync.Mutex
+	limits       AdmissionLimits
+	length       int
+	bytes        int64
+	byAddress    map[string]int
+	byCollection map[string]int
+	admitted     map[string]admittedTx // transactionId -> admittedTx
+	rejections   map[string]uint64
+}
+
+func newAdmission(limits AdmissionLimits) *admission {
+	return &admission{
+		limits:       limits,
+		byAddress:    make(map[string]int),
+		byCollection: make(map[string]int),
+		admitted:     make(map[string]admittedTx),
+		rejections:   make(map[string]uint64),
+	}
+}
+
+// admit takes a transaction into account, or returns the limit it hits. A forced transaction is admitted regardless of the limits
+func (a *admission) admit(tx *blockchain.Transaction, address string, force bool) *AdmissionError {
+	a.Lock()
+	defer a.Unlock()
+
+	size := int64(len(tx.RawData))
+	if !force {
+		limit := ""
+		if a.limits.MaxQueueLength > 0 && a.length+1 > a.limits.MaxQueueLength {
+			limit = LimitQueueLength
+		} else if a.limits.MaxQueueBytes > 0 && a.bytes+size > a.limits.MaxQueueBytes {
+			limit = LimitQueueBytes
+		} else if a.limits.MaxPendingPerAddress > 0 && address != "" && a.byAddress[address]+1 > a.limits.MaxPendingPerAddress {
+			limit = LimitAddress
+		} else if a.limits.MaxPendingPerCollection > 0 && a.byCollection[tx.Collection]+1 > a.limits.MaxPendingPerCollection {
+			limit = LimitCollection
+		}
+
+		if limit != "" {
+			a.rejections[limit]++
+			return &AdmissionError{Limit: limit}
+		}
+	}
+
+	a.admitted[string(tx.ID)] = admittedTx{address: address, collection: tx.Collection, size: size}
+	a.length++
+	a.bytes += size
+	if address != "" {
+		a.byAddress[address]++
+	}
+	a.byCollection[tx.Collection]++
+
+	return nil
+}
+
+// release gives back what the transactions took from the limits
+func (a *admission) release(txs []*blockchain.Transaction) {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, tx := range txs {
+		admitted, ok := a.admitted[string(tx.ID)]
+		if !ok {
+			continue
+		}
+
+		delete(a.admitted, string(tx.ID))
+		a.length--
+		a.bytes -= admitted.size
+		if admitted.address != "" {
+			if a.byAddress[admitted.address]--; a.byAddress[admitted.address] <= 0 {
+				delete(a.byAddress, admitted.address)
+			}
+		}
+		if a.byCollection[admitted.collection]--; a.byCollection[admitted.collection] <= 0 {
+			delete(a.byCollection, admitted.collection)
+		}
+	}
+}
+
+// pendingBytes returns the bytes of the queued transactions
+func (a *admission) pendingBytes() int64 {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.bytes
+}
+
+// underPressure tells if the queue is over half of its length or bytes limit
+func (a *admission) underPressure() bool {
+	a.Lock()
+	defer a.Unlock()
+
+	return (a.limits.MaxQueueLength > 0 && a.length*2 >= a.limits.MaxQueueLength) || (a.limits.MaxQueueBytes > 0 && a.bytes*2 >= a.limits.MaxQueueBytes)
+}
+
+func (a *admission) stats() PoolStats {
+	a.Lock()
+	defer a.Unlock()
+
+	stats := PoolStats{QueueLength: a.length, QueueBytes: a.bytes, PendingByCollection: make(map[string]int), Limits: a.limits, Rejections: make(map[string]uint64)}
+	for collection, count := range a.byCollection {
+		stats.PendingByCollection[collection] = count
+	}
+	for limit, count := range a.rejections {
+		stats.Rejections[limit] = count
+	}
+
+	return stats
+}
@@ -0,0 +1,52 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+func TestAdmission(t *testing.T) {
+	a := newAdmission(AdmissionLimits{MaxQueueLength: 4, MaxQueueBytes: 100, MaxPendingPerAddress: 2, MaxPendingPerCollection: 3})
+	newTx := func(collection string, size int) *blockchain.Transaction {
+		return blockchain.NewTransaction([]byte("peer"), make([]byte, size), collection, nil, nil, nil)
+	}
+
+	tx1, tx2 := newTx("c", 10), newTx("c", 10)
+	if a.admit(tx1, "a", false) != nil || a.admit(tx2, "a", false) != nil {
+		t.Fatal("expected the transactions admitted")
+	}
+	if err := a.admit(newTx("c", 10), "a", false); err == nil || err.Limit != LimitAddress {
+		t.Errorf("expected the address limit, actual: %v", err)
+	}
+	if a.admit(newTx("c", 10), "b", false) != nil {
+		t.Error("expected the transaction of another address admitted")
+	}
+	if err := a.admit(newTx("c", 10), "c", false); err == nil || err.Limit != LimitCollection {
+		t.Errorf("expected the collection limit, actual: %v", err)
+	}
+	if err := a.admit(newTx("d", 80), "c", false); err == nil || err.Limit != LimitQueueBytes {
+		t.Errorf("expected the bytes limit, actual: %v", err)
+	}
+	if a.admit(newTx("d", 10), "c", false) != nil {
+		t.Error("expected the transaction admitted")
+	}
+	if err := a.admit(newTx("d", 10), "d", false); err == nil || err.Limit != LimitQueueLength {
+		t.Errorf("expected the length limit, actual: %v", err)
+	}
+	if a.admit(newTx("d", 10), "d", true) != nil {
+		t.Error("expected the forced transaction admitted")
+	}
+
+	a.release([]*blockchain.Transaction{tx1, tx2, tx1})
+	stats := a.stats()
+	if stats.QueueLength != 3 || stats.QueueBytes != 30 || stats.PendingByCollection["c"] != 1 || stats.PendingByCollection["d"] != 2 {
+		t.Errorf("expected 3 transactions pending, actual: %+v", stats)
+	}
+	if stats.Rejections[LimitAddress] != 1 || stats.Rejections[LimitCollection] != 1 || stats.Rejections[LimitQueueBytes] != 1 || stats.Rejections[LimitQueueLength] != 1 {
+		t.Errorf("expected 1 rejection by each limit, actual: %v", stats.Rejections)
+	}
+	if a.admit(newTx("c", 10), "a", false) != nil {
+		t.Error("expected the transaction admitted after the release")
+	}
+}
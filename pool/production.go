@@ -0,0 +1,69 @@
+package pool
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The reasons to cut a block
+const (
+	CutCount        = "count"        // the queue holds maxTxsPerBlock transactions
+	CutBytes        = "bytes"        // the queue holds maxBytesPerBlock bytes
+	CutBackpressure = "backpressure" // the queue is over half of its admission limits
+	CutTime         = "time"         // maxTimeToGenerateBlock has passed since the first transaction was queued
+)
+
+// the cut blocks to keep for the stats
+const maxRecentBlockCuts = 100
+
+// BlockCut describes a block generated by the receiver and why
+type BlockCut struct {
+	BlockId      string `json:"blockId"`
+	Transactions int    `json:"transactions"`
+	Bytes        int64  `json:"bytes"`
+	Reason       string `json:"reason"`
+	Timestamp    int64  `json:"timestamp"`
+}
+
+// production keeps track of the generated blocks and the adaptive interval between them
+type production struct {
+	sync.Mutex
+	interval     time.Duration // the current interval to check the queue, backing off when idle
+	cutsByReason map[string]uint64
+	recentCuts   []BlockCut // the latest first
+}
+
+func newProduction(interval time.Duration) *production {
+	return &production{interval: interval, cutsByReason: make(map[string]uint64)}
+}
+
+func (p *production) recordCut(blockHash []byte, txs int, bytes int64, reason string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.cutsByReason[reason]++
+	p.recentCuts = append([]BlockCut{{BlockId: fmt.Sprintf("%x", blockHash), Transactions: txs, Bytes: bytes, Reason: reason, Timestamp: time.Now().UnixNano() / 1000000}}, p.recentCuts...)
+	if len(p.recentCuts) > maxRecentBlockCuts {
+		p.recentCuts = p.recentCuts[:maxRecentBlockCuts]
+	}
+}
+
+func (p *production) setInterval(interval time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.interval = interval
+}
+
+func (p *production) fillStats(stats *PoolStats) {
+	p.Lock()
+	defer p.Unlock()
+
+	stats.BlockInterval = int64(p.interval / time.Millisecond)
+	stats.BlocksByCutReason = make(map[string]uint64)
+	for reason, count := range p.cutsByReason {
+		stats.BlocksByCutReason[reason] = count
+	}
+	stats.RecentBlocks = append([]BlockCut{}, p.recentCuts...)
+}
@@ -0,0 +1,56 @@
+package pool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+func TestCutReason(t *testing.T) {
+	r := &Receiver{transactionsBuffer: NewQueue(), admission: newAdmission(AdmissionLimits{MaxQueueLength: 10}), maxTxsPerBlock: 4, maxBytesPerBlock: 100}
+	admit := func(size int) {
+		tx := blockchain.NewTransaction([]byte("peer"), make([]byte, size), "c", nil, nil, nil)
+		r.admission.admit(tx, "", false)
+		r.transactionsBuffer.Append(tx)
+	}
+
+	admit(10)
+	if reason := r.cutReason(); reason != "" {
+		t.Errorf("expected no reason to cut a block early, actual: %s", reason)
+	}
+	admit(90)
+	if reason := r.cutReason(); reason != CutBytes {
+		t.Errorf("expected %s, actual: %s", CutBytes, reason)
+	}
+	admit(0)
+	admit(0)
+	if reason := r.cutReason(); reason != CutCount {
+		t.Errorf("expected %s, actual: %s", CutCount, reason)
+	}
+
+	r.maxTxsPerBlock = 8
+	r.maxBytesPerBlock = 0
+	admit(0)
+	if reason := r.cutReason(); reason != CutBackpressure {
+		t.Errorf("expected %s, actual: %s", CutBackpressure, reason)
+	}
+}
+
+func TestRecordCut(t *testing.T) {
+	p := newProduction(time.Second)
+	for i := 0; i < maxRecentBlockCuts+5; i++ {
+		p.recordCut([]byte{byte(i)}, 1, 10, CutTime)
+	}
+	p.recordCut([]byte{0xff}, 2, 20, CutCount)
+	p.setInterval(2 * time.Second)
+
+	var stats PoolStats
+	p.fillStats(&stats)
+	if len(stats.RecentBlocks) != maxRecentBlockCuts || stats.RecentBlocks[0].BlockId != "ff" || stats.RecentBlocks[0].Reason != CutCount {
+		t.Errorf("expected the latest %d cuts first, actual: %d %+v", maxRecentBlockCuts, len(stats.RecentBlocks), stats.RecentBlocks[0])
+	}
+	if stats.BlocksByCutReason[CutTime] != uint64(maxRecentBlockCuts+5) || stats.BlocksByCutReason[CutCount] != 1 || stats.BlockInterval != 2000 {
+		t.Errorf("expected the cuts counted by reason, actual: %v %d", stats.BlocksByCutReason, stats.BlockInterval)
+	}
+}
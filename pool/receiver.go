@@ -1,14 +1,14 @@
 package pool
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/blevesearch/bleve/geo"
 	"github.com/boltdb/bolt"
-
 	log "github.com/sirupsen/logrus"
 
 	"github.com/codingpeasant/blocace/blockchain"
@@ -18,204 +18,539 @@ import (
 // Receiver represents the front door for the incoming transactions
 type Receiver struct {
 	transactionsBuffer     *Queue
+	wal                    *WAL // the transactions in transactionsBuffer which are not in a block yet
+	admission              *admission
 	p2p                    *p2p.P2P
 	maxTxsPerBlock         int
+	maxBytesPerBlock       int64 // no limit if 0
 	maxTimeToGenerateBlock int
+	maxIdleTime            int  // the longest interval to check the queue when it's idle
+	allowUnboundSignatures bool // accept the signatures over the documents only, which can be replayed
+	production             *production
+	commitWaiters          map[string][]chan []byte // transactionId -> the callers waiting for the hash of its block
+	commitWaitersLock      sync.Mutex
+	replicationWaiters     map[string][]chan bool // blockHash -> the callers waiting for the acknowledgements of the block
+	replicationWaitersLock sync.Mutex
+	envelopeLocks          map[string]*envelopeLock // the lowercased author address -> the lock of its envelopes
+	envelopeLocksLock      sync.Mutex
+}
+
+// envelopeLock serializes checking and recording the envelopes of an author, so that a replay or a nonce submitted at the same time sees the envelope used
+type envelopeLock struct {
+	sync.Mutex
+	holders int // the callers holding or waiting for the lock
 }
 
-// Put a transaction in JSON format to a collection. Returns isValidSig, fieldErrorMapping, transationId, error
-func (r *Receiver) Put(rawData []byte, collection string, pubKey []byte, signature []byte, permittedAddresses []string) (bool, map[string]string, []byte, error) {
-	isValidSig := blockchain.IsValidSig(rawData, pubKey, signature)
+// ErrWaitTimeout is returned when a transaction isn't committed or replicated in time. The transaction is still accepted
+var ErrWaitTimeout = errors.New("timed out waiting for the transaction")
+
+// Put a transaction in JSON format from an address to a collection, signed with its envelope in the signature scheme by a key of the key type. Returns isValidSig, fieldErrorMapping, the accepted transaction, error.
+// The key is of the delegate if a delegation of the address is given, otherwise of the address. The error is an *AdmissionError if the transaction hits a limit of the queue,
+// a *blockchain.EnvelopeError if the signed document is a replay, or a *blockchain.DelegationError if the delegation doesn't let the delegate sign the document
+func (r *Receiver) Put(rawData []byte, envelope blockchain.Envelope, address string, keyType string, pubKey []byte, signature []byte, signatureScheme string, delegation *blockchain.Delegation) (bool, map[string]string, *blockchain.Transaction, error) {
+	newTx := blockchain.NewTransaction(r.p2p.BlockchainForest.Local.PeerId, rawData, envelope.Collection, pubKey, signature, envelope.PermittedAddresses)
+	newTx.Nonce = envelope.Nonce
+	newTx.ExpiresAt = envelope.ExpiresAt
+	newTx.SignatureScheme = signatureScheme
+	newTx.KeyType = keyType
+	newTx.Address = address
+	newTx.Delegation = delegation
+
+	signerAddress := address
+	if delegation != nil {
+		signerAddress = delegation.Delegate
+	}
 
-	if !isValidSig {
+	digest, err := blockchain.TransactionDigest(newTx, address)
+	if err != nil {
 		return false, nil, nil, nil
 	}
+	if newTx.PubKey, err = signerPubKey(digest, signerAddress, keyType, pubKey, signature); err != nil || !blockchain.IsValidTransactionSig(newTx) {
+		return false, nil, nil, nil
+	}
+
+	if delegation != nil {
+		if err = r.p2p.BlockchainForest.Local.CheckDelegation(delegation, address, envelope.Collection, newTx.AcceptedTimestamp); err != nil {
+			return true, nil, nil, err
+		}
+	}
 
-	fieldErrorMapping, err := r.checkMapping(rawData, collection)
+	if !envelope.IsBound() && !r.allowUnboundSignatures {
+		return true, nil, nil, &blockchain.EnvelopeError{Reason: "the signature must commit to a nonce or an expiry"}
+	}
+
+	fieldErrorMapping, err := r.checkMapping(rawData, envelope.Collection)
 	if err != nil {
 		return true, nil, nil, err
 	} else if fieldErrorMapping != nil {
 		return true, fieldErrorMapping, nil, err
 	}
 
-	newTx := blockchain.NewTransaction(r.p2p.BlockchainForest.Local.PeerId, rawData, collection, pubKey, signature, permittedAddresses)
-	r.transactionsBuffer.Append(newTx)
+	// a replay submitted at the same time must see the envelope used. The other authors don't wait for the WAL
+	defer r.lockEnvelopes(address)()
+
+	if err = r.p2p.BlockchainForest.Local.CheckEnvelope(newTx, true); err != nil {
+		return true, nil, nil, err
+	}
+
+	if err = r.append(newTx, address); err != nil {
+		return true, nil, nil, err
+	}
 
-	return true, nil, newTx.ID, nil
+	if err = r.p2p.BlockchainForest.Local.RecordEnvelope(newTx); err != nil {
+		log.WithFields(log.Fields{
+			"method": "Put()",
+		}).Error(err)
+	}
+
+	return true, nil, newTx, nil
 }
 
-// PutWithoutSignature a transaction in JSON format to a collection. Returns fieldErrorMapping, transationId, error
-// WARNING: this makes the document unverifiable
-func (r *Receiver) PutWithoutSignature(rawData []byte, collection string, permittedAddresses []string) (map[string]string, error) {
-	fieldErrorMapping, err := r.checkMapping(rawData, collection)
+// signerPubKey returns the public key which signed the digest: recovered from a recoverable secp256k1 signature, which must be the given current key of the account
+// of the address, or of the address if the key is not given; the given key otherwise
+func signerPubKey(digest []byte, address string, keyType string, pubKey []byte, signature []byte) ([]byte, error) {
+	if keyType != blockchain.KeyTypeSecp256k1 || len(signature) != 65 {
+		return pubKey, nil
+	}
+
+	recoveredPubKey, err := blockchain.RecoverPubKey(digest, signature)
 	if err != nil {
 		return nil, err
-	} else if fieldErrorMapping != nil {
-		return fieldErrorMapping, err
 	}
+	if len(pubKey) > 0 && !bytes.Equal(recoveredPubKey, pubKey) {
+		return nil, fmt.Errorf("the signature is not of the key of %s", address)
+	} else if recoveredAddress, err := blockchain.PublicKeyToAddress(recoveredPubKey); len(pubKey) == 0 && (err != nil || !strings.EqualFold(recoveredAddress, address)) {
+		return nil, fmt.Errorf("the signature is not of %s", address)
+	}
+
+	return recoveredPubKey, nil
+}
+
+// BatchResult is the outcome of a document in a batch: the accepted transaction, or the field errors or the error which rejected the document
+type BatchResult struct {
+	Transaction       *blockchain.Transaction
+	FieldErrorMapping map[string]string
+	Err               error
+}
+
+// PutBatch puts documents in JSON format from an address to a collection, signed together through the merkle root of the batch. Returns isValidSig, the merkle root,
+// the result of each document, error. The error is a *blockchain.EnvelopeError if the batch is rejected as a whole
+func (r *Receiver) PutBatch(rawDocuments [][]byte, envelope blockchain.Envelope, address string, keyType string, pubKey []byte, signature []byte, signatureScheme string) (bool, []byte, []BatchResult, error) {
+	leaves := make([][]byte, len(rawDocuments))
+	for i, rawData := range rawDocuments {
+		leaves[i] = blockchain.BatchLeaf(rawData)
+	}
+	batchTree := blockchain.NewMerkleTree(leaves)
+	merkleRoot := batchTree.RootNode.Data
+
+	digest, err := envelope.BatchDigest(signatureScheme, merkleRoot, address)
+	if err != nil {
+		return false, merkleRoot, nil, nil
+	}
+	if pubKey, err = signerPubKey(digest, address, keyType, pubKey, signature); err != nil || !blockchain.IsValidKeySig(keyType, digest, pubKey, signature) {
+		return false, merkleRoot, nil, nil
+	}
+
+	if !envelope.IsBound() && !r.allowUnboundSignatures {
+		return true, merkleRoot, nil, &blockchain.EnvelopeError{Reason: "the signature must commit to a nonce or an expiry"}
+	}
+
+	defer r.lockEnvelopes(address)()
+
+	if err = r.p2p.BlockchainForest.Local.CheckBatchEnvelope(envelope, address); err != nil {
+		return true, merkleRoot, nil, err
+	}
+
+	results := make([]BatchResult, len(rawDocuments))
+	for i, rawData := range rawDocuments {
+		newTx := blockchain.NewTransaction(r.p2p.BlockchainForest.Local.PeerId, rawData, envelope.Collection, pubKey, signature, envelope.PermittedAddresses)
+		newTx.Nonce = envelope.Nonce
+		newTx.ExpiresAt = envelope.ExpiresAt
+		newTx.BatchRoot = merkleRoot
+		newTx.SignatureScheme = signatureScheme
+		newTx.KeyType = keyType
+		newTx.Address = address
+		newTx.BatchPath = batchTree.GetVerificationPath(blockchain.BatchLeaf(rawData))
+
+		fieldErrorMapping, err := r.checkMapping(rawData, envelope.Collection)
+		if err != nil || fieldErrorMapping != nil {
+			results[i] = BatchResult{FieldErrorMapping: fieldErrorMapping, Err: err}
+			continue
+		}
+
+		// a document repeated in the batch is a replay of the first one
+		if err = r.p2p.BlockchainForest.Local.CheckEnvelope(newTx, true); err != nil {
+			results[i] = BatchResult{Err: err}
+			continue
+		}
+
+		if err = r.append(newTx, address); err != nil {
+			results[i] = BatchResult{Err: err}
+			continue
+		}
+
+		if err = r.p2p.BlockchainForest.Local.RecordEnvelope(newTx); err != nil {
+			log.WithFields(log.Fields{
+				"method": "PutBatch()",
+			}).Error(err)
+		}
+		results[i] = BatchResult{Transaction: newTx}
+	}
+
+	return true, merkleRoot, results, nil
+}
+
+// lockEnvelopes locks the envelopes of an author. Returns the function to unlock them
+func (r *Receiver) lockEnvelopes(address string) func() {
+	key := strings.ToLower(address)
+	r.envelopeLocksLock.Lock()
+	lock := r.envelopeLocks[key]
+	if lock == nil {
+		lock = &envelopeLock{}
+		r.envelopeLocks[key] = lock
+	}
+	lock.holders++
+	r.envelopeLocksLock.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		r.envelopeLocksLock.Lock()
+		if lock.holders--; lock.holders == 0 {
+			delete(r.envelopeLocks, key)
+		}
+		r.envelopeLocksLock.Unlock()
+	}
+}
+
+// GetPendingTransaction returns an accepted transaction which is not in a block yet, or nil
+func (r *Receiver) GetPendingTransaction(txID []byte) *blockchain.Transaction {
+	return r.wal.Get(txID)
+}
+
+// WaitForCommit blocks until the block of an accepted transaction is persisted and indexed, or the timeout. Returns the hash of the block
+func (r *Receiver) WaitForCommit(txID []byte, timeout time.Duration) ([]byte, error) {
+	committed := make(chan []byte, 1)
+	r.commitWaitersLock.Lock()
+	r.commitWaiters[string(txID)] = append(r.commitWaiters[string(txID)], committed)
+	r.commitWaitersLock.Unlock()
+	defer r.removeCommitWaiter(txID, committed)
+
+	// the block may have been generated before the waiter was added
+	if r.wal.Get(txID) == nil {
+		_, block, err := r.p2p.BlockchainForest.Local.FindTransaction(txID)
+		if err != nil {
+			return nil, err
+		} else if block == nil {
+			return nil, fmt.Errorf("transaction %x doesn't exist", txID)
+		}
+		return block.Hash, nil
+	}
+
+	select {
+	case blockHash := <-committed:
+		return blockHash, nil
+	case <-time.After(timeout):
+		return nil, ErrWaitTimeout
+	}
+}
+
+// WaitForReplication blocks until at least minPeers peers have acknowledged a local block, or the timeout. Returns the peers which have acknowledged the block
+func (r *Receiver) WaitForReplication(blockHash []byte, minPeers int, timeout time.Duration) ([]string, error) {
+	acknowledged := make(chan bool, 1)
+	r.replicationWaitersLock.Lock()
+	r.replicationWaiters[string(blockHash)] = append(r.replicationWaiters[string(blockHash)], acknowledged)
+	r.replicationWaitersLock.Unlock()
+	defer r.removeReplicationWaiter(blockHash, acknowledged)
+
+	// the acknowledgements are read again for each new one, including those before the waiter was added
+	deadline := time.After(timeout)
+	for {
+		peerIds := r.p2p.BlockchainForest.Local.GetBlockAcks(blockHash)
+		if len(peerIds) >= minPeers {
+			return peerIds, nil
+		}
+
+		select {
+		case <-acknowledged:
+		case <-deadline:
+			return r.p2p.BlockchainForest.Local.GetBlockAcks(blockHash), ErrWaitTimeout
+		}
+	}
+}
+
+func (r *Receiver) removeReplicationWaiter(blockHash []byte, acknowledged chan bool) {
+	r.replicationWaitersLock.Lock()
+	defer r.replicationWaitersLock.Unlock()
+
+	waiters := r.replicationWaiters[string(blockHash)]
+	for i, waiter := range waiters {
+		if waiter == acknowledged {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(r.replicationWaiters, string(blockHash))
+	} else {
+		r.replicationWaiters[string(blockHash)] = waiters
+	}
+}
+
+// notifyReplicated wakes up the callers waiting for the acknowledgements of a local block. A waiter which hasn't read the last one yet reads them all anyway
+func (r *Receiver) notifyReplicated(blockHash []byte, peerId []byte) {
+	r.replicationWaitersLock.Lock()
+	defer r.replicationWaitersLock.Unlock()
+
+	for _, waiter := range r.replicationWaiters[string(blockHash)] {
+		select {
+		case waiter <- true:
+		default:
+		}
+	}
+}
 
-	newTx := blockchain.NewTransaction(r.p2p.BlockchainForest.Local.PeerId, rawData, collection, nil, nil, permittedAddresses)
-	r.transactionsBuffer.Append(newTx)
+func (r *Receiver) removeCommitWaiter(txID []byte, committed chan []byte) {
+	r.commitWaitersLock.Lock()
+	defer r.commitWaitersLock.Unlock()
+
+	waiters := r.commitWaiters[string(txID)]
+	for i, waiter := range waiters {
+		if waiter == committed {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
 
-	return nil, nil
+	if len(waiters) == 0 {
+		delete(r.commitWaiters, string(txID))
+	} else {
+		r.commitWaiters[string(txID)] = waiters
+	}
 }
 
-func (r *Receiver) generateBlock() {
+// notifyCommitted wakes up the callers waiting for the transactions of a new block
+func (r *Receiver) notifyCommitted(txs []*blockchain.Transaction, blockHash []byte) {
+	r.commitWaitersLock.Lock()
+	defer r.commitWaitersLock.Unlock()
+
+	for _, tx := range txs {
+		for _, waiter := range r.commitWaiters[string(tx.ID)] {
+			waiter <- blockHash
+		}
+	}
+}
+
+// Stats returns the depth of the queue, the rejected transactions and the generated blocks
+func (r *Receiver) Stats() PoolStats {
+	stats := r.admission.stats()
+	r.production.fillStats(&stats)
+	return stats
+}
+
+// append admits a transaction and logs it to the WAL before queuing it, so that it's not lost once acknowledged
+func (r *Receiver) append(tx *blockchain.Transaction, address string) error {
+	if admissionErr := r.admission.admit(tx, address, false); admissionErr != nil {
+		admissionErr.RetryAfter = r.retryAfter()
+		return admissionErr
+	}
+
+	if err := r.wal.Append(tx); err != nil {
+		r.admission.release([]*blockchain.Transaction{tx})
+		log.WithFields(log.Fields{
+			"method": "append()",
+		}).Error(err)
+		return fmt.Errorf("cannot persist the transaction: %s", err)
+	}
+
+	r.transactionsBuffer.Append(tx)
+	return nil
+}
+
+// generateBlock puts the transactions at the front of the queue in a block, up to maxTxsPerBlock transactions and maxBytesPerBlock bytes.
+// A transaction larger than maxBytesPerBlock gets a block of its own
+func (r *Receiver) generateBlock(reason string) {
 	var candidateTxs []*blockchain.Transaction
+	var candidateBytes int64
+
+	for len(candidateTxs) < r.maxTxsPerBlock && r.transactionsBuffer.Length() > 0 {
+		if front, ok := r.transactionsBuffer.Front().(*blockchain.Transaction); ok && r.maxBytesPerBlock > 0 && len(candidateTxs) > 0 && candidateBytes+int64(len(front.RawData)) > r.maxBytesPerBlock {
+			break
+		}
 
-	for i := 0; i < r.maxTxsPerBlock && r.transactionsBuffer.Length() > 0; i++ {
 		tx, ok := interface{}(r.transactionsBuffer.Pop()).(*blockchain.Transaction)
 		if ok {
 			candidateTxs = append(candidateTxs, tx)
+			candidateBytes += int64(len(tx.RawData))
 		}
 	}
 
 	if len(candidateTxs) > 0 {
 		newBlockHash := r.p2p.BlockchainForest.Local.AddBlock(candidateTxs)
-		r.p2p.BlockchainForest.GetBlock(r.p2p.BlockchainForest.Local.PeerId, newBlockHash, false)
-		r.p2p.BroadcastObject(r.p2p.BlockchainForest.GetBlock(r.p2p.BlockchainForest.Local.PeerId, newBlockHash, false))
+		if err := r.wal.Commit(candidateTxs); err != nil {
+			log.WithFields(log.Fields{
+				"method": "generateBlock()",
+			}).Error(err)
+		}
+		r.admission.release(candidateTxs)
+		r.notifyCommitted(candidateTxs, newBlockHash)
+		r.production.recordCut(newBlockHash, len(candidateTxs), candidateBytes, reason)
+		log.Infof("generated block %x of %d transactions and %d bytes, cut by %s\n", newBlockHash, len(candidateTxs), candidateBytes, reason)
+
+		r.p2p.BroadcastObject(r.p2p.BlockchainForest.GetLocalTipBlock()) // the new block is the tip
+	}
+}
+
+// cutReason tells if the queue holds enough to cut a block before maxTimeToGenerateBlock
+func (r *Receiver) cutReason() string {
+	if r.transactionsBuffer.Length() >= r.maxTxsPerBlock {
+		return CutCount
+	} else if r.maxBytesPerBlock > 0 && r.admission.pendingBytes() >= r.maxBytesPerBlock {
+		return CutBytes
+	} else if r.admission.underPressure() {
+		return CutBackpressure
 	}
+
+	return ""
+}
+
+// cutBlocks generates blocks as long as the queue holds enough to cut one early, so that a burst doesn't wait for the next transaction or
+// maxTimeToGenerateBlock to be cut. Returns true if any block is generated
+func (r *Receiver) cutBlocks() bool {
+	isCut := false
+	for reason := r.cutReason(); reason != "" && r.transactionsBuffer.Length() > 0; reason = r.cutReason() {
+		r.generateBlock(reason)
+		isCut = true
+	}
+
+	return isCut
 }
 
-// Monitor creates a thread to monitor the transaction queue and generate block
+// Monitor creates a thread to monitor the transaction queue and generate block. A block is cut as soon as the queue holds a full block or is under pressure,
+// or maxTimeToGenerateBlock after the first transaction is queued. The queue is checked less often while it's idle, up to every maxIdleTime
 func (r *Receiver) Monitor() {
-	ticker := time.NewTicker(time.Duration(r.maxTimeToGenerateBlock) * time.Millisecond)
+	maxTime := time.Duration(r.maxTimeToGenerateBlock) * time.Millisecond
+	maxIdle := time.Duration(r.maxIdleTime) * time.Millisecond
+	if maxIdle < maxTime {
+		maxIdle = maxTime
+	}
+
+	interval := maxTime
+	timer := time.NewTimer(interval)
+	resetTimer := func(d time.Duration) {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d)
+	}
 	log.Infof("begin to monitor transactions every %d milliseconds...\n", r.maxTimeToGenerateBlock)
 
-	for t := range ticker.C {
-		if r.transactionsBuffer.Length() > 0 {
-			log.Infof("generating a block at %s...\n", t)
-			r.generateBlock()
+	for {
+		select {
+		case <-r.transactionsBuffer.NotEmpty:
+			if r.cutBlocks() {
+				interval = maxTime
+				r.production.setInterval(interval)
+				resetTimer(interval)
+			} else if interval > maxTime {
+				// the queue wakes up from idle: its first transaction waits for maxTimeToGenerateBlock at most
+				interval = maxTime
+				r.production.setInterval(interval)
+				resetTimer(interval)
+			}
+		case t := <-timer.C:
+			if r.transactionsBuffer.Length() > 0 {
+				log.Infof("generating a block at %s...\n", t)
+				r.generateBlock(CutTime)
+				r.cutBlocks()
+				interval = maxTime
+			} else if interval *= 2; interval > maxIdle {
+				interval = maxIdle
+			}
+			r.production.setInterval(interval)
+			timer.Reset(interval)
 		}
 	}
 }
 
-func (r Receiver) checkMapping(rawData []byte, collection string) (map[string]string, error) {
-	var rawDataJSON map[string]interface{}
-	err := json.Unmarshal(rawData, &rawDataJSON)
-
+func (r *Receiver) checkMapping(rawData []byte, collection string) (map[string]string, error) {
+	documentMapping, err := r.p2p.BlockchainForest.Local.Search.GetDocumentMapping(collection)
 	if err != nil {
+		log.WithFields(log.Fields{
+			"method": "checkMapping()",
+		}).Warn(err.Error())
 		return nil, err
+	} else if len(documentMapping.AliasOf) > 0 {
+		return nil, fmt.Errorf("%s is an alias of collection %s", collection, documentMapping.AliasOf)
+	} else if documentMapping.State != blockchain.CollectionActive {
+		return nil, fmt.Errorf("collection %s is %s", collection, documentMapping.State)
 	}
 
-	var documentMapping *blockchain.DocumentMapping
-	err = r.p2p.BlockchainForest.Local.Db.View(func(dbtx *bolt.Tx) error {
-		b := dbtx.Bucket([]byte(blockchain.CollectionsBucket))
+	return documentMapping.Validate(rawData)
+}
 
+// replayWAL queues the transactions left in the WAL by the last run. The ones already in the tip block are committed, in case the node stopped before truncating the WAL
+func (r *Receiver) replayWAL() error {
+	pending := r.wal.Pending()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// a transaction may be in any block: the log keeps the records committed out of order after its committed offset
+	local := r.p2p.BlockchainForest.Local
+	inBlock := make(map[string]bool)
+	local.Db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(blockchain.TxIndexBucket))
 		if b == nil {
-			log.WithFields(log.Fields{
-				"method": "checkMapping()",
-			}).Warn("bucket doesn't exist")
-			return errors.New("collection doesn't exist")
+			return nil
 		}
 
-		encodedCollectionMapping := b.Get([]byte(collection))
-		if encodedCollectionMapping == nil {
-			log.WithFields(log.Fields{
-				"method": "checkMapping()",
-			}).Warn("collection doesn't exist")
-			return errors.New("collection doesn't exist")
+		for _, tx := range pending {
+			if b.Get(tx.ID) != nil {
+				inBlock[string(tx.ID)] = true
+			}
 		}
-		documentMapping = blockchain.DeserializeDocumentMapping(encodedCollectionMapping)
 
 		return nil
 	})
 
-	if err != nil {
-		log.WithFields(log.Fields{
-			"method": "checkMapping()",
-		}).Errorf("%s", err.Error())
-		return nil, err
+	var committed []*blockchain.Transaction
+	for _, tx := range pending {
+		if inBlock[string(tx.ID)] {
+			committed = append(committed, tx)
+		} else {
+			r.admission.admit(tx, "", true)
+			r.transactionsBuffer.Append(tx)
+		}
 	}
 
-	valueMaps := make(map[string]interface{})
-	for field, value := range documentMapping.Fields {
-		valueMap, _ := value.(map[string]interface{})
-		valueMaps[field] = valueMap["type"]
-	}
+	log.Infof("replayed %d pending transactions from the write-ahead log\n", len(pending)-len(committed))
+	return r.wal.Commit(committed)
+}
 
-	validationErrors := make(map[string]string)
-	for field, value := range rawDataJSON {
-		switch value := value.(type) {
-		case string:
-			if valueMaps[field] == nil {
-			} else if valueMaps[field] == "text" {
-			} else if valueMaps[field] == "datetime" {
-				_, err := time.Parse(time.RFC3339, value)
+// retryAfter estimates when the queue has room again from the blocks to generate for the queued transactions
+func (r *Receiver) retryAfter() time.Duration {
+	blocks := r.transactionsBuffer.Length()/r.maxTxsPerBlock + 1
+	return time.Duration(blocks*r.maxTimeToGenerateBlock) * time.Millisecond
+}
 
-				if err != nil {
-					validationErrors[field] = "cannot parse as RFC3339 time format"
-				}
-			} else {
-				validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-			}
-		case float64:
-			if valueMaps[field] == nil {
-			} else if valueMaps[field] != "number" {
-				validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-			}
-		case bool:
-			if valueMaps[field] == nil {
-			} else if valueMaps[field] != "boolean" {
-				validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-			}
-		case []interface{}:
-			if len(value) > 0 {
-				switch element := value[0].(type) {
-				case string:
-					if valueMaps[field] == nil {
-					} else if valueMaps[field] == "text" {
-					} else if valueMaps[field] == "datetime" {
-						_, err := time.Parse(time.RFC3339Nano, element)
-
-						if err != nil {
-							validationErrors[field] = "cannot parse as RFC3339 time format"
-						}
-					} else {
-						validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-					}
-				case float64:
-					if valueMaps[field] == nil {
-					} else if valueMaps[field] != "number" {
-						validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-					}
-				case bool:
-					if valueMaps[field] == nil {
-					} else if valueMaps[field] != "boolean" {
-						validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-					}
-				default:
-					if valueMaps[field] == nil {
-					} else if valueMaps[field] == "geopoint" {
-						_, _, isGeoPoint := geo.ExtractGeoPoint(value)
-						if !isGeoPoint {
-							validationErrors[field] = "field type should be geopoint"
-						}
-					} else {
-						validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-					}
-				}
-			}
-		default:
-			if valueMaps[field] == nil {
-			} else if valueMaps[field] == "geopoint" {
-				_, _, isGeoPoint := geo.ExtractGeoPoint(value)
-				if !isGeoPoint {
-					validationErrors[field] = "field type should be geopoint"
-				}
-			} else {
-				validationErrors[field] = fmt.Sprintf("field type should be %s", valueMaps[field])
-			}
-		}
+// NewReceiver creates an instance of Receiver. The transactions pending in the WAL at walPath are queued again, even beyond the limits
+func NewReceiver(p2p *p2p.P2P, maxTxsPerBlock int, maxBytesPerBlock int64, maxTimeToGenerateBlock int, maxIdleTime int, walPath string, limits AdmissionLimits, allowUnboundSignatures bool) *Receiver {
+	wal, err := NewWAL(walPath)
+	if err != nil {
+		log.Panic(err)
 	}
 
-	if len(validationErrors) == 0 {
-		return nil, nil
+	r := &Receiver{transactionsBuffer: NewQueue(), wal: wal, admission: newAdmission(limits), p2p: p2p, envelopeLocks: make(map[string]*envelopeLock), commitWaiters: make(map[string][]chan []byte), replicationWaiters: make(map[string][]chan bool), maxTxsPerBlock: maxTxsPerBlock, maxBytesPerBlock: maxBytesPerBlock, maxTimeToGenerateBlock: maxTimeToGenerateBlock, maxIdleTime: maxIdleTime, allowUnboundSignatures: allowUnboundSignatures, production: newProduction(time.Duration(maxTimeToGenerateBlock) * time.Millisecond)}
+	if err = r.replayWAL(); err != nil {
+		log.Panic(err)
 	}
-	return validationErrors, nil
-}
+	p2p.BlockchainForest.AddBlockAckListener(r.notifyReplicated)
 
-// NewReceiver creates an instance of Receiver
-func NewReceiver(p2p *p2p.P2P, maxTxsPerBlock int, maxTimeToGenerateBlock int) *Receiver {
-	return &Receiver{transactionsBuffer: NewQueue(), p2p: p2p, maxTxsPerBlock: maxTxsPerBlock, maxTimeToGenerateBlock: maxTimeToGenerateBlock}
+	return r
 }
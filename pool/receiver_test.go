@@ -0,0 +1,189 @@
+package pool
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/p2p"
+)
+
+// newTestReceiver creates a receiver of a fresh blockchain with no peers. The returned function removes it. The p2p node listens until the test exits
+func newTestReceiver(t *testing.T, maxTxsPerBlock int, limits AdmissionLimits) (*Receiver, func()) {
+	dir, err := ioutil.TempDir("", "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc := blockchain.CreateBlockchain(filepath.Join(dir, "blockchain.db"), dir)
+	if err = bc.RegisterAccount([]byte("admin"), blockchain.Account{Role: blockchain.Role{Name: "admin"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReceiver(p2p.NewP2P(bc, "127.0.0.1", 0, ""), maxTxsPerBlock, 0, 60000, 60000, filepath.Join(dir, "pending.wal"), limits, false)
+
+	return r, func() {
+		r.wal.Close()
+		bc.Db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCutBlocks(t *testing.T) {
+	r, stop := newTestReceiver(t, 2, AdmissionLimits{})
+	defer stop()
+
+	for i := 0; i < 5; i++ {
+		if err := r.append(blockchain.NewTransaction(r.p2p.BlockchainForest.Local.PeerId, []byte(`{"id": `+strconv.Itoa(i)+`}`), "default", nil, nil, nil), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a burst of 5 transactions is cut in 2 full blocks at once, the rest waits for maxTimeToGenerateBlock
+	isCut := r.cutBlocks()
+	var stats PoolStats
+	r.production.fillStats(&stats)
+	if !isCut || r.transactionsBuffer.Length() != 1 || stats.BlocksByCutReason[CutCount] != 2 {
+		t.Errorf("expected 2 blocks cut and 1 transaction left, actual: %d left, %v", r.transactionsBuffer.Length(), stats.BlocksByCutReason)
+	}
+	if r.cutBlocks() {
+		t.Error("expected no block cut for the rest")
+	}
+}
+
+func TestPutDelegated(t *testing.T) {
+	r, stop := newTestReceiver(t, 2, AdmissionLimits{})
+	defer stop()
+
+	local := r.p2p.BlockchainForest.Local
+	if _, err := local.Search.CreateMappingByJson([]byte(`{"collection": "c", "fields": {"a": {"type": "text"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	principalKey, _ := crypto.GenerateKey()
+	principal := crypto.PubkeyToAddress(principalKey.PublicKey).String()
+	if err := local.RegisterAccount([]byte(principal), blockchain.Account{PublicKey: hex.EncodeToString(crypto.FromECDSAPub(&principalKey.PublicKey)), Role: blockchain.Role{Name: "user", CollectionsWrite: []string{"c"}}}); err != nil {
+		t.Fatal(err)
+	}
+	delegateKey, _ := crypto.GenerateKey()
+	delegate := crypto.PubkeyToAddress(delegateKey.PublicKey).String()
+
+	now := time.Now().UnixNano() / 1000000
+	delegation := blockchain.Delegation{Principal: principal, Delegate: delegate, Collections: []string{"c"}, NotBefore: now - 1000, NotAfter: now + 60000}
+	delegation.Signature = blockchain.Sign(*principalKey, crypto.Keccak256(delegation.SigningMessage()))
+
+	// the delegate signs the document of the principal
+	rawData := []byte(`{"a": "delegated"}`)
+	envelope := blockchain.Envelope{Collection: "c", Nonce: 1, PermittedAddresses: []string{delegate}}
+	signature := blockchain.Sign(*delegateKey, crypto.Keccak256(envelope.SigningMessage(rawData, principal)))
+	delegatePubKey := crypto.FromECDSAPub(&delegateKey.PublicKey)
+
+	isValidSig, _, tx, err := r.Put(rawData, envelope, principal, "", delegatePubKey, signature, "", &delegation)
+	if !isValidSig || err != nil || tx == nil || tx.Delegation == nil || tx.Address != principal {
+		t.Fatalf("expected the document accepted on behalf of the principal: %v %v %+v", isValidSig, err, tx)
+	}
+
+	// signed by the principal instead of the delegate
+	envelope.Nonce = 2
+	signature = blockchain.Sign(*principalKey, crypto.Keccak256(envelope.SigningMessage(rawData, principal)))
+	if isValidSig, _, _, _ = r.Put(rawData, envelope, principal, "", delegatePubKey, signature, "", &delegation); isValidSig {
+		t.Error("expected the signature of the principal rejected as the one of the delegate")
+	}
+
+	// a delegation to another collection
+	other := delegation
+	other.Collections = []string{"other"}
+	other.Signature = blockchain.Sign(*principalKey, crypto.Keccak256(other.SigningMessage()))
+	signature = blockchain.Sign(*delegateKey, crypto.Keccak256(envelope.SigningMessage(rawData, principal)))
+	if _, _, _, err = r.Put(rawData, envelope, principal, "", delegatePubKey, signature, "", &other); err == nil {
+		t.Error("expected the delegation to another collection rejected")
+	} else if _, ok := err.(*blockchain.DelegationError); !ok {
+		t.Errorf("expected a DelegationError, actual: %v", err)
+	}
+
+	// a delegation not signed by the principal
+	forged := delegation
+	forged.Signature = blockchain.Sign(*delegateKey, crypto.Keccak256(forged.SigningMessage()))
+	if _, _, _, err = r.Put(rawData, envelope, principal, "", delegatePubKey, signature, "", &forged); err == nil {
+		t.Error("expected the forged delegation rejected")
+	}
+}
+
+func TestLockEnvelopes(t *testing.T) {
+	r, stop := newTestReceiver(t, 2, AdmissionLimits{})
+	defer stop()
+
+	unlock := r.lockEnvelopes("0xAbc")
+
+	// another author doesn't wait for the WAL write of the first
+	otherLocked := make(chan bool)
+	go func() {
+		r.lockEnvelopes("0xdef")()
+		otherLocked <- true
+	}()
+	select {
+	case <-otherLocked:
+	case <-time.After(time.Second):
+		t.Fatal("expected another author not blocked")
+	}
+
+	// the same author in another case waits
+	sameLocked := make(chan bool)
+	go func() {
+		r.lockEnvelopes("0xabc")()
+		sameLocked <- true
+	}()
+	select {
+	case <-sameLocked:
+		t.Fatal("expected the same author blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	<-sameLocked
+	r.envelopeLocksLock.Lock()
+	defer r.envelopeLocksLock.Unlock()
+	if len(r.envelopeLocks) != 0 {
+		t.Errorf("expected the locks removed once released, actual: %v", r.envelopeLocks)
+	}
+}
+
+func TestWaitForReplication(t *testing.T) {
+	r, stop := newTestReceiver(t, 2, AdmissionLimits{})
+	defer stop()
+
+	if err := r.append(blockchain.NewTransaction(r.p2p.BlockchainForest.Local.PeerId, []byte(`{"id": 1}`), "default", nil, nil, nil), ""); err != nil {
+		t.Fatal(err)
+	}
+	r.generateBlock(CutTime)
+	blockHash := r.p2p.BlockchainForest.Local.Tip
+
+	// acknowledged while waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.p2p.BlockchainForest.RecordBlockAck(blockHash, []byte{1})
+	}()
+	if peerIds, err := r.WaitForReplication(blockHash, 1, 5*time.Second); err != nil || len(peerIds) != 1 || peerIds[0] != "01" {
+		t.Fatalf("expected the block replicated to peer 01, actual: %v %v", peerIds, err)
+	}
+
+	// acknowledged before waiting
+	r.p2p.BlockchainForest.RecordBlockAck(blockHash, []byte{2})
+	if peerIds, err := r.WaitForReplication(blockHash, 2, time.Millisecond); err != nil || len(peerIds) != 2 {
+		t.Errorf("expected the block replicated to 2 peers, actual: %v %v", peerIds, err)
+	}
+
+	if peerIds, err := r.WaitForReplication(blockHash, 3, 100*time.Millisecond); err != ErrWaitTimeout || len(peerIds) != 2 {
+		t.Errorf("expected the wait for 3 peers timed out, actual: %v %v", peerIds, err)
+	}
+	if len(r.replicationWaiters) != 0 {
+		t.Errorf("expected the waiters removed, actual: %v", r.replicationWaiters)
+	}
+}
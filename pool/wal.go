@@ -0,0 +1,330 @@
+package pool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+// a WAL record is the length and the CRC-32 of the serialized transaction followed by the transaction
+const walRecordHeaderSize = 8
+
+// the committed records before the marker are dropped from the log once they take this many bytes
+const walCompactionSize = 64 << 20
+
+// WAL is the write-ahead log of the accepted transactions which are not in a block yet. It survives a crash or restart of the node.
+// The records before the committed offset, kept in a marker file next to the log, are all committed. A record committed out of order after it
+// may be read again after a restart, which the receiver finds in the blockchain
+type WAL struct {
+	sync.Mutex
+	path       string
+	file       *os.File
+	marker     *os.File
+	size       int64
+	entries    []*walEntry          // in the order of the records, from the committed offset
+	index      map[string]*walEntry // transactionId -> the pending entry
+	compaction int64                // the committed offset to compact the log at
+}
+
+// walEntry is a record in the log
+type walEntry struct {
+	offset      int64
+	tx          *blockchain.Transaction
+	isCommitted bool
+}
+
+// NewWAL opens the write-ahead log at path and reads the pending transactions in it. A torn record at the end left by a crash is discarded
+func NewWAL(path string) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	marker, err := os.OpenFile(path+".committed", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &WAL{path: path, file: file, marker: marker, index: make(map[string]*walEntry), compaction: walCompactionSize}
+	if err = w.load(); err != nil {
+		file.Close()
+		marker.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// load reads the records from the committed offset and drops the torn record at the end so that the new records follow the valid ones
+func (w *WAL) load() error {
+	committedOffset := make([]byte, 8)
+	var offset int64
+	if _, err := io.ReadFull(w.marker, committedOffset); err == nil {
+		offset = int64(binary.BigEndian.Uint64(committedOffset))
+	}
+
+	fileInfo, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	if offset > fileInfo.Size() {
+		offset = 0
+	}
+
+	entries, validSize, err := readWALRecords(w.file, offset)
+	if err != nil {
+		return err
+	}
+	if err = w.file.Truncate(validSize); err != nil {
+		return err
+	}
+	if _, err = w.file.Seek(validSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.size = validSize
+	w.entries = entries
+	for _, entry := range entries {
+		w.index[string(entry.tx.ID)] = entry
+	}
+
+	return nil
+}
+
+// Pending returns the transactions in the log which are not in a block yet
+func (w *WAL) Pending() []*blockchain.Transaction {
+	w.Lock()
+	defer w.Unlock()
+
+	var pending []*blockchain.Transaction
+	for _, entry := range w.entries {
+		if !entry.isCommitted {
+			pending = append(pending, entry.tx)
+		}
+	}
+
+	return pending
+}
+
+// Get returns a pending transaction by its ID, or nil if it's not pending
+func (w *WAL) Get(txID []byte) *blockchain.Transaction {
+	w.Lock()
+	defer w.Unlock()
+
+	if entry, ok := w.index[string(txID)]; ok {
+		return entry.tx
+	}
+
+	return nil
+}
+
+// Append writes a transaction to the log and syncs it to disk
+func (w *WAL) Append(tx *blockchain.Transaction) error {
+	w.Lock()
+	defer w.Unlock()
+
+	record := encodeWALRecord(tx)
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	entry := &walEntry{offset: w.size, tx: tx}
+	w.size += int64(len(record))
+	w.entries = append(w.entries, entry)
+	w.index[string(tx.ID)] = entry
+	return nil
+}
+
+// Commit marks the transactions persisted in a block committed. The log is truncated once nothing is pending,
+// otherwise the committed offset moves past the committed records at the front
+func (w *WAL) Commit(txs []*blockchain.Transaction) error {
+	w.Lock()
+	defer w.Unlock()
+
+	isCommitted := false
+	for _, tx := range txs {
+		if entry, ok := w.index[string(tx.ID)]; ok {
+			entry.isCommitted = true
+			delete(w.index, string(tx.ID))
+			isCommitted = true
+		}
+	}
+	if !isCommitted {
+		return nil
+	}
+
+	if len(w.index) == 0 {
+		if err := w.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+		w.size = 0
+		w.entries = nil
+		return w.writeCommittedOffset(0)
+	}
+
+	front := 0
+	for front < len(w.entries) && w.entries[front].isCommitted {
+		front++
+	}
+	if front == 0 {
+		return nil
+	}
+	w.entries = w.entries[front:]
+
+	if w.entries[0].offset >= w.compaction {
+		return w.compact()
+	}
+	return w.writeCommittedOffset(w.entries[0].offset)
+}
+
+// Close closes the log file
+func (w *WAL) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	w.marker.Close()
+	return w.file.Close()
+}
+
+// writeCommittedOffset persists the offset before which all the records are committed
+func (w *WAL) writeCommittedOffset(offset int64) error {
+	committedOffset := make([]byte, 8)
+	binary.BigEndian.PutUint64(committedOffset, uint64(offset))
+	if _, err := w.marker.WriteAt(committedOffset, 0); err != nil {
+		return err
+	}
+
+	return w.marker.Sync()
+}
+
+// compact drops the committed records at the front of the log by copying the rest to a new log, which is renamed over the old one
+// so that a crash leaves either of them. The records from the committed offset are a small part of the log by then
+func (w *WAL) compact() error {
+	tmpPath := w.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	committedOffset := w.entries[0].offset
+	if _, err = w.file.Seek(committedOffset, io.SeekStart); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if _, err = io.Copy(tmpFile, w.file); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	// the marker is reset first: a crash before the rename reads the old log from its start, which the receiver handles like any committed record
+	if err = w.writeCommittedOffset(0); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = os.Rename(tmpPath, w.path); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	// persist the rename
+	if dir, err := os.Open(filepath.Dir(w.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	w.file.Close()
+	w.file = tmpFile
+	w.size -= committedOffset
+	for _, entry := range w.entries {
+		entry.offset -= committedOffset
+	}
+	_, err = w.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+func encodeWALRecord(tx *blockchain.Transaction) []byte {
+	encodedTx := tx.Serialize()
+
+	var record bytes.Buffer
+	header := make([]byte, walRecordHeaderSize)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(encodedTx)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(encodedTx))
+	record.Write(header)
+	record.Write(encodedTx)
+
+	return record.Bytes()
+}
+
+// readWALRecords reads the records from the offset of the log. Returns the size of the log up to the last valid record
+func readWALRecords(file *os.File, offset int64) ([]*walEntry, int64, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []*walEntry
+	validSize := offset
+	reader := bufio.NewReader(file)
+	header := make([]byte, walRecordHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err != io.EOF {
+				log.WithFields(log.Fields{
+					"method": "readWALRecords()",
+				}).Warnf("discarding the torn record at %d: %s", validSize, err)
+			}
+			return entries, validSize, nil
+		}
+
+		txSize := int64(binary.BigEndian.Uint32(header[:4]))
+		if validSize+walRecordHeaderSize+txSize > fileInfo.Size() {
+			log.WithFields(log.Fields{
+				"method": "readWALRecords()",
+			}).Warnf("discarding the torn record at %d", validSize)
+			return entries, validSize, nil
+		}
+
+		encodedTx := make([]byte, txSize)
+		if _, err := io.ReadFull(reader, encodedTx); err != nil || crc32.ChecksumIEEE(encodedTx) != binary.BigEndian.Uint32(header[4:]) {
+			log.WithFields(log.Fields{
+				"method": "readWALRecords()",
+			}).Warnf("discarding the torn record at %d", validSize)
+			return entries, validSize, nil
+		}
+
+		tx := blockchain.DeserializeTransaction(encodedTx)
+		if tx.ID == nil {
+			return nil, 0, errors.New("cannot decode the transaction in the write-ahead log")
+		}
+
+		entries = append(entries, &walEntry{offset: validSize, tx: tx})
+		validSize += int64(walRecordHeaderSize + len(encodedTx))
+	}
+}
@@ -0,0 +1,119 @@
+package pool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingpeasant/blocace/blockchain"
+)
+
+func TestWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pending.wal")
+
+	wal, err := NewWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var txs []*blockchain.Transaction
+	for i := 0; i < 3; i++ {
+		tx := blockchain.NewTransaction([]byte("peer"), []byte(`{"id": 1}`), "c", nil, nil, []string{"a"})
+		if err = wal.Append(tx); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	wal.Close()
+
+	// a crash in the middle of a record
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte{0, 0, 1, 0, 1, 2})
+	file.Close()
+
+	wal, err = NewWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := wal.Pending(); len(pending) != 3 || string(pending[2].ID) != string(txs[2].ID) || string(pending[2].RawData) != `{"id": 1}` {
+		t.Fatalf("expected the 3 transactions without the torn record, actual: %d", len(pending))
+	}
+
+	if err = wal.Commit(txs[:2]); err != nil {
+		t.Fatal(err)
+	}
+	tx := blockchain.NewTransaction([]byte("peer"), []byte(`{"id": 2}`), "c", nil, nil, nil)
+	wal.Append(tx)
+	wal.Close()
+
+	wal, _ = NewWAL(path)
+	if pending := wal.Pending(); len(pending) != 2 || string(pending[0].ID) != string(txs[2].ID) || string(pending[1].ID) != string(tx.ID) {
+		t.Errorf("expected the uncommitted transaction and the new one, actual: %d", len(pending))
+	}
+
+	wal.Commit(wal.Pending())
+	wal.Close()
+	if fileInfo, _ := os.Stat(path); fileInfo.Size() != 0 {
+		t.Errorf("expected the WAL truncated, actual size: %d", fileInfo.Size())
+	}
+}
+
+func TestWALCommittedOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pending.wal")
+
+	wal, err := NewWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var txs []*blockchain.Transaction
+	for i := 0; i < 4; i++ {
+		tx := blockchain.NewTransaction([]byte("peer"), []byte(`{"id": 1}`), "c", nil, nil, []string{"a"})
+		wal.Append(tx)
+		txs = append(txs, tx)
+	}
+	fileInfo, _ := os.Stat(path)
+	size := fileInfo.Size()
+
+	// committed out of order: the first one moves the committed offset past the third one only
+	wal.Commit([]*blockchain.Transaction{txs[2], txs[0]})
+	if wal.Get(txs[0].ID) != nil || wal.Get(txs[1].ID) == nil {
+		t.Error("expected only the pending transactions found")
+	}
+	if fileInfo, _ := os.Stat(path); fileInfo.Size() != size {
+		t.Errorf("expected the log not rewritten, actual size: %d", fileInfo.Size())
+	}
+	wal.Close()
+
+	wal, _ = NewWAL(path)
+	if pending := wal.Pending(); len(pending) != 3 || string(pending[0].ID) != string(txs[1].ID) {
+		t.Fatalf("expected the transactions from the committed offset, actual: %d", len(pending))
+	}
+
+	// the committed records at the front are dropped once they pass the compaction size
+	wal.compaction = 1
+	wal.Commit(txs[1:3])
+	tx := blockchain.NewTransaction([]byte("peer"), []byte(`{"id": 2}`), "c", nil, nil, nil)
+	wal.Append(tx)
+	if fileInfo, _ := os.Stat(path); fileInfo.Size() >= size {
+		t.Errorf("expected the log compacted, actual size: %d", fileInfo.Size())
+	}
+	wal.Close()
+
+	wal, _ = NewWAL(path)
+	defer wal.Close()
+	if pending := wal.Pending(); len(pending) != 2 || string(pending[0].ID) != string(txs[3].ID) || wal.Get(tx.ID) == nil {
+		t.Errorf("expected the last transaction and the new one, actual: %d", len(pending))
+	}
+}
@@ -0,0 +1,174 @@
+package webapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/p2p"
+)
+
+// DelegationPayload is what an account authorizes a delegate to sign on its behalf
+type DelegationPayload struct {
+	Delegate    string   `json:"delegate"`
+	Collections []string `json:"collections"`
+	NotBefore   int64    `json:"notBefore"` // unix time in milliseconds. A new delegation to the same delegate must start after the last one, or its revocation
+	NotAfter    int64    `json:"notAfter"`  // unix time in milliseconds
+	Signature   string   `json:"signature"` // of blockchain.Delegation.SigningMessage by the key of the account
+}
+
+// RevocationPayload is the signed revocation of a delegation
+type RevocationPayload struct {
+	RevokedAt int64  `json:"revokedAt"` // unix time in milliseconds, after the notBefore of the delegation
+	Signature string `json:"signature"` // of blockchain.Delegation.RevocationSigningMessage by the key of the account
+}
+
+// DelegationCreation lets a delegate, such as the account of a backend service, sign documents on behalf of the account in its collections for a time window
+func (h *HTTPHandler) DelegationCreation(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	address := vars["address"]
+	if address != r.Header.Get("address") {
+		http.Error(w, "{\"message\": \"you can only delegate for your own account\"}", 401)
+		return
+	}
+
+	// read the request body
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "{\"message\": \"could not process the request body payload: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	var delegationPayload DelegationPayload
+	if err = json.Unmarshal(requestBody, &delegationPayload); err != nil {
+		http.Error(w, "{\"message\": \"error parsing the json payload: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	if !blockchain.IsValidAddress(delegationPayload.Delegate) || common.HexToAddress(delegationPayload.Delegate).Hex() == address {
+		http.Error(w, "{\"message\": \"the delegate must be the address of another account\"}", 400)
+		return
+	} else if len(delegationPayload.Collections) == 0 {
+		http.Error(w, "{\"message\": \"the delegation must permit at least one collection\"}", 400)
+		return
+	} else if delegationPayload.NotAfter <= delegationPayload.NotBefore || delegationPayload.NotAfter <= time.Now().UnixNano()/1000000 {
+		http.Error(w, "{\"message\": \"notAfter must be in the future and after notBefore\"}", 400)
+		return
+	}
+
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "DelegationCreation")
+	if err != nil {
+		http.Error(w, "{\"message\": \"account doesn't exist\"}", 404)
+		return
+	}
+
+	// the delegate writes with the permissions of the account
+	for _, collection := range delegationPayload.Collections {
+		if !funk.ContainsString(account.CollectionsWrite, collection) {
+			http.Error(w, "{\"message\": \"insufficient permission to write to collection: "+collection+"\"}", 401)
+			return
+		}
+	}
+
+	// the peers order the versions of a delegation by notBefore, which the principal signs
+	delegation := blockchain.Delegation{Principal: address, Delegate: common.HexToAddress(delegationPayload.Delegate).Hex(), Collections: delegationPayload.Collections, NotBefore: delegationPayload.NotBefore, NotAfter: delegationPayload.NotAfter, LastModified: delegationPayload.NotBefore}
+	if delegation.Signature, err = hex.DecodeString(delegationPayload.Signature); err != nil || h.bf.Local.CheckDelegationSignature(delegation) != nil {
+		http.Error(w, "{\"message\": \"bad signature\"}", 401)
+		return
+	}
+
+	isNewer, err := h.bf.Local.MergeDelegation(delegation)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "DelegationCreation",
+			"address": address,
+		}).Error(err)
+		http.Error(w, "{\"message\": \"error saving the delegation: "+err.Error()+"\"}", 500)
+		return
+	} else if !isNewer {
+		http.Error(w, "{\"message\": \"notBefore must be after the last delegation to the delegate or its revocation\"}", 409)
+		return
+	}
+
+	h.p2p.BroadcastObject(p2p.DelegationsP2P{Delegations: map[string]blockchain.Delegation{delegation.Key(): delegation}})
+
+	log.WithFields(log.Fields{
+		"route":   "DelegationCreation",
+		"address": address,
+	}).Info("delegated to: ", delegation.Delegate)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"message\": \"delegation created\", \"principal\": \"%s\", \"delegate\": \"%s\"}", delegation.Principal, delegation.Delegate)
+}
+
+// DelegationRevocation stops a delegate from signing on behalf of the account with a revocation the account signs, so that the peers verify it.
+// The documents the delegate has signed stay valid
+// {"revokedAt": 1600000000000, "signature": "8e0063b7..."}
+func (h *HTTPHandler) DelegationRevocation(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	address := vars["address"]
+	if address != r.Header.Get("address") && r.Header.Get("role") != "admin" {
+		http.Error(w, "{\"message\": \"you can only revoke the delegations of your own account\"}", 401)
+		return
+	}
+
+	// read the request body
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "{\"message\": \"could not process the request body payload: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	var revocationPayload RevocationPayload
+	if err = json.Unmarshal(requestBody, &revocationPayload); err != nil {
+		http.Error(w, "{\"message\": \"error parsing the json payload: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	signature, err := hex.DecodeString(revocationPayload.Signature)
+	if err != nil {
+		http.Error(w, "{\"message\": \"bad signature\"}", 401)
+		return
+	}
+
+	revocation, err := h.bf.Local.RevokeDelegation(address, vars["delegate"], revocationPayload.RevokedAt, signature)
+	if delegationErr, ok := err.(*blockchain.DelegationError); ok {
+		http.Error(w, "{\"message\": \""+delegationErr.Error()+"\"}", 401)
+		return
+	} else if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 404)
+		return
+	}
+	h.p2p.BroadcastObject(p2p.DelegationsP2P{Delegations: map[string]blockchain.Delegation{revocation.Key(): *revocation}})
+
+	log.WithFields(log.Fields{
+		"route":   "DelegationRevocation",
+		"address": r.Header.Get("address"),
+	}).Info("revoked the delegation of ", address, " to: ", vars["delegate"])
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"message\": \"delegation revoked\"}")
+}
@@ -2,7 +2,6 @@ package webapi
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -10,15 +9,16 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/boltdb/bolt"
 	jwt "github.com/dgrijalva/jwt-go"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/gorilla/mux"
 	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
@@ -30,13 +30,20 @@ import (
 	"github.com/codingpeasant/blocace/pool"
 )
 
+// The timeouts of a synchronous write
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
 // HTTPHandler encapsulates the essential objects to serve http requests
 type HTTPHandler struct {
-	bf      *p2p.BlockchainForest
-	r       *pool.Receiver
-	p2p     *p2p.P2P
-	secret  string
-	version string
+	bf            *p2p.BlockchainForest
+	r             *pool.Receiver
+	p2p           *p2p.P2P
+	secret        string
+	version       string
+	subscriptions *subscriptionHub
 }
 
 // BlockchainInfo has current status information about the whole blockchain
@@ -47,6 +54,31 @@ type BlockchainInfo struct {
 	TotalTransactions int64  `json:"totalTransactions"`
 }
 
+// CollectionStats has the index statistics of a collection and how far it is behind the blockchains
+type CollectionStats struct {
+	Collection     string                     `json:"collection"`
+	State          string                     `json:"state"`
+	DocumentCount  uint64                     `json:"documentCount"`
+	DiskSizeBytes  interface{}                `json:"diskSizeBytes"`
+	DiskFiles      interface{}                `json:"diskFiles"`
+	MemorySegments interface{}                `json:"memorySegments"`
+	FileSegments   interface{}                `json:"fileSegments"`
+	ItemsToPersist interface{}                `json:"itemsToPersist"`
+	IndexErrors    interface{}                `json:"indexErrors"`
+	Searches       interface{}                `json:"searches"`
+	Blockchains    []CollectionBlockchainStat `json:"blockchains"`
+	IsHealthy      bool                       `json:"isHealthy"`
+}
+
+// CollectionBlockchainStat is the last block of a blockchain indexed to a collection and the lag behind the blockchain tip
+type CollectionBlockchainStat struct {
+	BlockchainId       string  `json:"blockchainId"`
+	TipHeight          int     `json:"tipHeight"`
+	LastIndexedBlockId string  `json:"lastIndexedBlockId,omitempty"`
+	LastIndexedHeight  *uint64 `json:"lastIndexedHeight,omitempty"` // of the last block with documents of the collection. Absent if none has been indexed since the collection was created
+	IndexingLag        *int    `json:"indexingLag,omitempty"`       // the blocks up to the tip which are not indexed
+}
+
 // BlockInfo has information about a certain block
 type BlockInfo struct {
 	BlockchainId      string `json:"blockchainId"`
@@ -62,38 +94,141 @@ type SearchResponse struct {
 	Status     *bleve.SearchStatus   `json:"status"`
 	Total      uint64                `json:"total_hits"`
 	Hits       []blockchain.Document `json:"hits"`
+	Facets     search.FacetResults   `json:"facets,omitempty"`
+}
+
+// MultiSearchRequest defines the data for HTTP clients should provide to search across collections and blockchains
+type MultiSearchRequest struct {
+	Collections   []string             `json:"collections"`
+	BlockchainIds []string             `json:"blockchainIds"`
+	AsOfHeight    string               `json:"asOfHeight"` // <blockchainId>:<height>,<height>
+	AsOfTime      string               `json:"asOfTime"`   // <blockchainId>:<RFC3339 time>,<RFC3339 time>
+	Search        *bleve.SearchRequest `json:"search"`
 }
 
-// TransactionPayload defines the data for HTTP clients should provide to add a document to the blockchain
+// MultiSearchResponse determines the data in the HTTP response of a search across collections and blockchains
+type MultiSearchResponse struct {
+	Collections   []string              `json:"collections"`
+	BlockchainIds []string              `json:"blockchainIds"`
+	Status        *bleve.SearchStatus   `json:"status"`
+	Total         uint64                `json:"total_hits"`
+	Hits          []blockchain.Document `json:"hits"`
+	Facets        search.FacetResults   `json:"facets,omitempty"`
+}
+
+// SimilarSearchRequest defines the data for HTTP clients should provide to find the documents similar to a transaction or a raw document
+type SimilarSearchRequest struct {
+	blockchain.MoreLikeThis
+	BlockchainId string          `json:"blockchainId"`
+	BlockId      string          `json:"blockId"`
+	TxId         string          `json:"txId"`
+	Document     json.RawMessage `json:"document"`
+	Size         int             `json:"size"`
+	From         int             `json:"from"`
+}
+
+// TransactionPayload defines the data for HTTP clients should provide to add a document to the blockchain. The signature covers the blockchain.Envelope
+// of the document with the nonce or the expiry, see blockchain.Envelope.SigningMessage
 type TransactionPayload struct {
 	RawDocument        string   `json:"rawDocument"`
 	Signature          string   `json:"signature"`
 	Address            string   `json:"address"`
 	PermittedAddresses []string `json:"permittedAddresses"`
+	Nonce              uint64   `json:"nonce"`           // greater than the last nonce of the account
+	ExpiresAt          int64    `json:"expiresAt"`       // unix time in milliseconds
+	Canonical          bool     `json:"canonical"`       // the signature covers the canonical form (RFC 8785) of the document, which is stored instead
+	SignatureScheme    string   `json:"signatureScheme"` // empty (keccak256), eip191 or eip712, see blockchain.SignatureSchemeKeccak256
+	Principal          string   `json:"principal"`       // the account a delegate signs the document on behalf of, see blockchain.Delegation. The permitted addresses then include the delegate
+}
+
+// document returns the document to verify the signature of and to store: the canonical form of the raw document if the payload asks for it
+func (tp TransactionPayload) document() ([]byte, error) {
+	if !tp.Canonical {
+		return []byte(tp.RawDocument), nil
+	}
+
+	canonical, err := blockchain.Canonicalize([]byte(tp.RawDocument))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't canonicalize the document: %s", err)
+	}
+
+	return canonical, nil
 }
 
 // TransactionCreationResponse has the validation information from the server to the HTTP clients
 type TransactionCreationResponse struct {
-	Status            string            `json:"status"`
-	FieldErrorMapping map[string]string `json:"fieldErrors"`
-	IsValidSignature  bool              `json:"isValidSignature"`
-	TransactionID     string            `json:"transactionID"`
+	Status            string              `json:"status"`
+	FieldErrorMapping map[string]string   `json:"fieldErrors"`
+	IsValidSignature  bool                `json:"isValidSignature"`
+	TransactionID     string              `json:"transactionID"`
+	Receipt           *blockchain.Receipt `json:"receipt,omitempty"` // the proof of acceptance signed by the node
+	Commit            *TransactionStatus  `json:"commit,omitempty"`  // the block and merkle path of the transaction if the request waits for it
+}
+
+// waitOptions tells HandleTransaction to respond only after the transaction is committed (wait=commit), or its block is also acknowledged by minPeers peers (wait=replicated)
+type waitOptions struct {
+	wait     string
+	minPeers int
+	timeout  time.Duration
+}
+
+// TransactionStatus tells if a transaction is pending or committed, with the proof of its inclusion and how far its block has been replicated
+type TransactionStatus struct {
+	TransactionID    string              `json:"transactionId"`
+	Status           string              `json:"status"` // pending or committed
+	Collection       string              `json:"collection"`
+	BlockchainId     string              `json:"blockchainId"`
+	BlockId          string              `json:"blockId,omitempty"`
+	BlockHeight      *uint64             `json:"blockHeight,omitempty"`
+	VerificationPath map[int]string      `json:"verificationPath,omitempty"`
+	Replicas         *int                `json:"replicas,omitempty"` // the peers which have acknowledged the block. Only known for the local blockchain
+	ReplicatedBy     []string            `json:"replicatedBy,omitempty"`
+	Receipt          *blockchain.Receipt `json:"receipt,omitempty"`
+}
+
+// BulkTransactionPayload defines the documents for HTTP clients to add in bulk. Either each document has its own signature,
+// or the batch has one signature over the merkle root of the documents and the documents have the raw documents only
+type BulkTransactionPayload struct {
+	Documents          []TransactionPayload `json:"documents"`
+	BatchSignature     string               `json:"batchSignature"`
+	PermittedAddresses []string             `json:"permittedAddresses"` // of all the documents in a signed batch
+	Nonce              uint64               `json:"nonce"`              // of a signed batch
+	ExpiresAt          int64                `json:"expiresAt"`          // of a signed batch
+	SignatureScheme    string               `json:"signatureScheme"`    // of a signed batch
 }
 
 // TransactionBulkCreationResponse has the validation and count information from the server to the HTTP clients
 type TransactionBulkCreationResponse struct {
-	Status            string            `json:"status"`
-	Total             int               `json:"total"`
-	Accepted          int               `json:"accepted"`
-	Dropped           int               `json:"dropped"`
-	FieldErrorMapping map[string]string `json:"fieldErrors"`
+	Status     string               `json:"status"`
+	Total      int                  `json:"total"`
+	Accepted   int                  `json:"accepted"`
+	Dropped    int                  `json:"dropped"`
+	MerkleRoot string               `json:"merkleRoot,omitempty"` // of a signed batch
+	Results    []BulkDocumentResult `json:"results"`
+}
+
+// BulkDocumentResult tells if a document in bulk is accepted or why not
+type BulkDocumentResult struct {
+	Index             int               `json:"index"`
+	Status            string            `json:"status"` // ok or the reason of the rejection
+	IsValidSignature  bool              `json:"isValidSignature"`
+	TransactionID     string            `json:"transactionID,omitempty"`
+	FieldErrorMapping map[string]string `json:"fieldErrors,omitempty"`
+}
+
+// KeyRotationPayload is the new key of an account
+type KeyRotationPayload struct {
+	PublicKey string `json:"publicKey"`
+	KeyType   string `json:"keyType"`   // empty (secp256k1) or ed25519
+	Signature string `json:"signature"` // of blockchain.Account.KeyRotationMessage by the current key, unless an admin forces the rotation
 }
 
 // AuthPayload defines the data for HTTP clients should provide to obtain a JWT
 type AuthPayload struct {
-	Signature     string `json:"signature"`
-	Address       string `json:"address"`
-	ChallengeWord string `json:"challengeWord"`
+	Signature       string `json:"signature"`
+	Address         string `json:"address"`
+	ChallengeWord   string `json:"challengeWord"`
+	SignatureScheme string `json:"signatureScheme"` // empty (keccak256), eip191 or eip712, see blockchain.ChallengeDigest
 }
 
 func (h HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -118,19 +253,15 @@ func (h *HTTPHandler) CollectionMappingCreation(w http.ResponseWriter, r *http.R
 	}
 
 	newIndex, err := h.bf.Local.Search.CreateMappingByJson(mappingBody)
-	var documentMapping blockchain.DocumentMapping
-
-	if err := json.Unmarshal(mappingBody, &documentMapping); err == nil {
-		mappingToBroadcast := make(map[string]blockchain.DocumentMapping)
-		mappingToBroadcast[documentMapping.Collection] = documentMapping
-		h.p2p.BroadcastObject(p2p.MappingsP2P{Mappings: mappingToBroadcast})
-	}
-
 	if err != nil {
 		http.Error(w, "{\"message\": \"could not create the collection: "+err.Error()+"\"}", 400)
 		return
 	}
 
+	if documentMapping, err := h.bf.Local.Search.GetDocumentMapping(newIndex.Name()); err == nil {
+		h.broadcastMapping(*documentMapping)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "{\"message\": \"collection %s created\"}", newIndex.Name())
@@ -146,44 +277,18 @@ func (h HTTPHandler) CollectionMappingGet(w http.ResponseWriter, r *http.Request
 
 	// find the index to operate on
 	vars := mux.Vars(r)
-	indexName := vars["name"]
+	indexName := h.bf.Local.Search.ResolveCollection(vars["name"])
 
-	if nil == h.bf.Local.Search.BlockchainIndices[indexName] {
+	indexMapping, err := h.bf.Local.Search.GetDocumentMapping(indexName)
+	if err != nil || indexMapping.State == blockchain.CollectionDropped {
+		log.WithFields(log.Fields{
+			"route":   "HandleCollectionMappingGet",
+			"address": r.Header.Get("address"),
+		}).Warn("collection doesn't exist")
 		http.Error(w, "{\"message\": \"the collection "+indexName+" doesn't exist\"}", 404)
 		return
 	}
 
-	var indexMapping *blockchain.DocumentMapping
-	err = h.bf.Local.Db.View(func(dbtx *bolt.Tx) error {
-		b := dbtx.Bucket([]byte(blockchain.CollectionsBucket))
-
-		if b == nil {
-			log.WithFields(log.Fields{
-				"route":   "HandleCollectionMappingGet",
-				"address": r.Header.Get("address"),
-			}).Warn("bucket doesn't exist")
-			return errors.New("bucket doesn't exist")
-		}
-
-		encodedIndexMapping := b.Get([]byte(indexName))
-
-		if encodedIndexMapping == nil {
-			log.WithFields(log.Fields{
-				"route":   "HandleCollectionMappingGet",
-				"address": r.Header.Get("address"),
-			}).Error("collection doesn't exist")
-			return errors.New("collection doesn't exist")
-		}
-		indexMapping = blockchain.DeserializeDocumentMapping(encodedIndexMapping)
-
-		return nil
-	})
-
-	if err != nil {
-		http.Error(w, "{\"message\": \"could not find the collection\"}", 404)
-		return
-	}
-
 	rv := struct {
 		Message string                     `json:"message"`
 		Mapping blockchain.DocumentMapping `json:"mapping"`
@@ -209,16 +314,188 @@ func (h HTTPHandler) CollectionList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rv := struct {
-		Message string   `json:"message"`
-		Indexes []string `json:"collections"`
+		Message  string            `json:"message"`
+		Indexes  []string          `json:"collections"`
+		Archived []string          `json:"archived,omitempty"`
+		Aliases  map[string]string `json:"aliases,omitempty"`
 	}{
-		Message: "ok",
-		Indexes: indexNames,
+		Message:  "ok",
+		Indexes:  indexNames,
+		Archived: h.bf.Local.Search.GetArchivedCollections(),
+		Aliases:  h.bf.Local.Search.GetAliases(),
 	}
 
 	mustEncode(w, rv)
 }
 
+// CollectionStatsGet returns the document count, disk usage, segments and the indexing lag of a collection
+func (h HTTPHandler) CollectionStatsGet(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	indexName := h.bf.Local.Search.ResolveCollection(vars["name"])
+
+	index, state, err := h.bf.Local.Search.GetCollectionIndex(indexName)
+	if err != nil {
+		http.Error(w, "{\"message\": \"the collection "+indexName+" doesn't exist\"}", 404)
+		return
+	}
+
+	documentCount, err := index.DocCount()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "CollectionStatsGet",
+			"address": r.Header.Get("address"),
+		}).Error(err)
+		http.Error(w, "{\"message\": \"could not read the collection stats: "+err.Error()+"\"}", 500)
+		return
+	}
+
+	statsMap := index.StatsMap()
+	indexStats, _ := statsMap["index"].(map[string]interface{})
+	collectionStats := CollectionStats{
+		Collection:     indexName,
+		State:          "active",
+		DocumentCount:  documentCount,
+		DiskSizeBytes:  indexStats["num_bytes_used_disk"],
+		DiskFiles:      indexStats["num_files_on_disk"],
+		MemorySegments: indexStats["num_root_memorysegments"],
+		FileSegments:   indexStats["num_root_filesegments"],
+		ItemsToPersist: indexStats["num_recs_to_persist"],
+		IndexErrors:    indexStats["errors"],
+		Searches:       statsMap["searches"],
+		IsHealthy:      true,
+	}
+	if state == blockchain.CollectionArchived {
+		collectionStats.State = state
+	}
+
+	blockchains := []*blockchain.Blockchain{h.bf.Local}
+	for _, peerChain := range h.bf.Peers {
+		blockchains = append(blockchains, peerChain)
+	}
+
+	for _, bc := range blockchains {
+		blockchainInfo := getBlockchainInfo(bc)
+		blockchainStat := CollectionBlockchainStat{BlockchainId: blockchainInfo.BlockchainId, TipHeight: blockchainInfo.LastHeight}
+
+		lastIndexedBlock, err := h.bf.Local.Search.GetLastIndexedBlock(index, bc.PeerId)
+		if err == nil && lastIndexedBlock != nil {
+			blockchainStat.LastIndexedBlockId = fmt.Sprintf("%x", lastIndexedBlock.Hash)
+			blockchainStat.LastIndexedHeight = &lastIndexedBlock.Height
+		}
+
+		// the blocks missing anywhere in the blockchain, e.g. of a peer blockchain synced from the tip backwards
+		var blocksNotIndexed uint64
+		if err == nil {
+			blocksNotIndexed, err = h.bf.Local.Search.GetIndexingLag(bc.PeerId, uint64(blockchainInfo.LastHeight))
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"route":   "CollectionStatsGet",
+				"address": r.Header.Get("address"),
+			}).Error(err)
+			collectionStats.IsHealthy = false
+		} else {
+			indexingLag := int(blocksNotIndexed)
+			blockchainStat.IndexingLag = &indexingLag
+			if indexingLag != 0 {
+				collectionStats.IsHealthy = false
+			}
+		}
+
+		collectionStats.Blockchains = append(collectionStats.Blockchains, blockchainStat)
+	}
+
+	mustEncode(w, collectionStats)
+}
+
+// CollectionDrop deletes the index of a collection or removes an alias. The documents stay on chain
+func (h *HTTPHandler) CollectionDrop(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, true, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	h.changeCollection(w, r, "dropped", func() (*blockchain.DocumentMapping, error) {
+		return h.bf.Local.Search.DropCollection(vars["name"])
+	})
+}
+
+// CollectionArchive makes a collection read-only and unsearchable
+func (h *HTTPHandler) CollectionArchive(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, true, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	h.changeCollection(w, r, "archived", func() (*blockchain.DocumentMapping, error) {
+		return h.bf.Local.Search.ArchiveCollection(vars["name"])
+	})
+}
+
+// CollectionRestore makes an archived collection writable and searchable again
+func (h *HTTPHandler) CollectionRestore(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, true, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	h.changeCollection(w, r, "restored", func() (*blockchain.DocumentMapping, error) {
+		return h.bf.Local.Search.RestoreCollection(vars["name"])
+	})
+}
+
+// CollectionAliasCreation creates another name for a collection, which can be used to write and search it. e.g. to rename a collection
+func (h *HTTPHandler) CollectionAliasCreation(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, true, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	h.changeCollection(w, r, "aliased", func() (*blockchain.DocumentMapping, error) {
+		return h.bf.Local.Search.CreateAlias(vars["alias"], vars["name"])
+	})
+}
+
+// changeCollection applies a change to a collection and syncs it to the peers
+func (h *HTTPHandler) changeCollection(w http.ResponseWriter, r *http.Request, action string, change func() (*blockchain.DocumentMapping, error)) {
+	documentMapping, err := change()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "changeCollection",
+			"address": r.Header.Get("address"),
+		}).Error(err)
+		http.Error(w, "{\"message\": \"could not change the collection: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	h.broadcastMapping(*documentMapping)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"message\": \"collection %s %s\"}", documentMapping.Collection, action)
+}
+
+// broadcastMapping syncs a created or changed collection mapping to the peers
+func (h *HTTPHandler) broadcastMapping(documentMapping blockchain.DocumentMapping) {
+	mappingToBroadcast := make(map[string]blockchain.DocumentMapping)
+	mappingToBroadcast[documentMapping.Collection] = documentMapping
+	h.p2p.BroadcastObject(p2p.MappingsP2P{Mappings: mappingToBroadcast})
+}
+
 // HandleInfo returns the basic information of the blockchain
 func (h HTTPHandler) HandleInfo(w http.ResponseWriter, r *http.Request) {
 	err := processJWT(r, false, h.secret)
@@ -248,6 +525,17 @@ func (h HTTPHandler) HandleInfo(w http.ResponseWriter, r *http.Request) {
 	w.Write(blockchainInfoJSON)
 }
 
+// HandlePoolStats returns the depth of the transaction queue, its limits and the transactions rejected by the limits
+func (h HTTPHandler) HandlePoolStats(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, true, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	mustEncode(w, h.r.Stats())
+}
+
 // HandlePeers returns all the alive peers of the current node
 func (h HTTPHandler) HandlePeers(w http.ResponseWriter, r *http.Request) {
 	err := processJWT(r, false, h.secret)
@@ -266,11 +554,14 @@ func (h HTTPHandler) HandlePeers(w http.ResponseWriter, r *http.Request) {
 	w.Write(peers)
 }
 
-// HandleTransaction put and index new transaction
+// HandleTransaction put and index new transaction. The signature commits to the collection the document is stored in, the nonce or expiry and the permitted addresses. With ?wait=commit the response waits for the block of the transaction to be persisted and indexed,
+// with ?wait=replicated&minPeers=N also for N peers to acknowledge the block. Both wait for ?timeout milliseconds at most. A recoverable signature of 65 bytes
+// must recover to the address of the JWT; the stored public key of the account verifies a signature of 64 bytes
 // {
 //     "rawDocument": "{\"id\":\"10001\",\"message\":\"Send 10000 BTC to Ivan\"}",
 //     "signature": "8e0063b76c2aed4982e1b62c713b0a7cf74f2b548b8c032659da65404c3d0b9777b8f8613f3e87e43680ec638949e263658ef5608bad7359e1075e285f49dd8d",
-//     "permittedAddresses" : ["0x07322C5A59047c09e87C284503F64f7FdDD17aBd", "0x931D387731bBbC988B312206c74F77D004D6B84b"]
+//     "permittedAddresses" : ["0x07322C5A59047c09e87C284503F64f7FdDD17aBd", "0x931D387731bBbC988B312206c74F77D004D6B84b"],
+//     "nonce": 1
 // }
 func (h *HTTPHandler) HandleTransaction(w http.ResponseWriter, r *http.Request) {
 	err := processJWT(r, false, h.secret)
@@ -281,13 +572,19 @@ func (h *HTTPHandler) HandleTransaction(w http.ResponseWriter, r *http.Request)
 
 	// find the index to operate on
 	vars := mux.Vars(r)
-	indexName := vars["collection"]
+	indexName := h.bf.Local.Search.ResolveCollection(vars["collection"])
 
 	if nil == h.bf.Local.Search.BlockchainIndices[indexName] {
 		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
 		return
 	}
 
+	waitOptions, err := getWaitOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
 	// check writing permission
 	address := r.Header.Get("address")
 	var account *blockchain.Account
@@ -314,15 +611,6 @@ func (h *HTTPHandler) HandleTransaction(w http.ResponseWriter, r *http.Request)
 		return nil
 	})
 
-	if !funk.ContainsString(account.CollectionsWrite, indexName) {
-		log.WithFields(log.Fields{
-			"route":   "HandleTransaction",
-			"address": address,
-		}).Info("insufficient permission to write to collection: ", indexName)
-		http.Error(w, "{\"message\": \"insufficient permission to write to collection: "+indexName+"\"}", 401)
-		return
-	}
-
 	// read the request body
 	transactionBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -338,6 +626,31 @@ func (h *HTTPHandler) HandleTransaction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// a delegate writes on behalf of the principal, with the permissions of the principal
+	principal, writer := address, account
+	var delegation *blockchain.Delegation
+	if transactionPayload.Principal != "" && transactionPayload.Principal != address {
+		principal = transactionPayload.Principal
+		if delegation = h.bf.Local.GetDelegation(principal, address); delegation == nil {
+			http.Error(w, "{\"message\": \"no delegation from "+principal+"\"}", 401)
+			return
+		}
+		principal = delegation.Principal
+		if writer, err = getAccountFromDb(h.bf.Local.Db, principal, "HandleTransaction"); err != nil {
+			http.Error(w, "{\"message\": \"account doesn't exist: "+principal+"\"}", 404)
+			return
+		}
+	}
+
+	if !funk.ContainsString(writer.CollectionsWrite, indexName) {
+		log.WithFields(log.Fields{
+			"route":   "HandleTransaction",
+			"address": address,
+		}).Info("insufficient permission to write to collection: ", indexName)
+		http.Error(w, "{\"message\": \"insufficient permission to write to collection: "+indexName+"\"}", 401)
+		return
+	}
+
 	var publicKey []byte
 	publicKey, err = hex.DecodeString(account.PublicKey)
 	if err != nil {
@@ -358,9 +671,37 @@ func (h *HTTPHandler) HandleTransaction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	transactionPayload.PermittedAddresses = append(transactionPayload.PermittedAddresses, r.Header.Get("address")) // add self
-	isValidSig, fieldErrorMapping, txID, err := h.r.Put([]byte(transactionPayload.RawDocument), indexName, publicKey, signatureBytes, transactionPayload.PermittedAddresses)
+	rawDocument, err := transactionPayload.document()
 	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
+	if err = blockchain.CheckSignatureScheme(transactionPayload.SignatureScheme); err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
+	transactionPayload.PermittedAddresses = append(transactionPayload.PermittedAddresses, r.Header.Get("address")) // add self
+	if delegation != nil {
+		transactionPayload.PermittedAddresses = append(transactionPayload.PermittedAddresses, principal)
+	}
+	envelope := blockchain.Envelope{Collection: indexName, Nonce: transactionPayload.Nonce, ExpiresAt: transactionPayload.ExpiresAt, PermittedAddresses: transactionPayload.PermittedAddresses}
+	isValidSig, fieldErrorMapping, tx, err := h.r.Put(rawDocument, envelope, principal, account.KeyType, publicKey, signatureBytes, transactionPayload.SignatureScheme, delegation)
+	if _, ok := err.(*blockchain.EnvelopeError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		mustEncode(w, TransactionCreationResponse{Status: err.Error(), IsValidSignature: true})
+		return
+	} else if _, ok := err.(*blockchain.DelegationError); ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		mustEncode(w, TransactionCreationResponse{Status: err.Error(), IsValidSignature: true})
+		return
+	} else if admissionErr, ok := err.(*pool.AdmissionError); ok {
+		setRetryAfter(w, admissionErr)
+		w.WriteHeader(http.StatusTooManyRequests)
+		mustEncode(w, TransactionCreationResponse{Status: err.Error(), IsValidSignature: true})
+		return
+	} else if err != nil {
 		log.WithFields(log.Fields{
 			"route":   "HandleTransaction",
 			"address": r.Header.Get("address"),
@@ -382,90 +723,309 @@ func (h *HTTPHandler) HandleTransaction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	mustEncode(w, TransactionCreationResponse{Status: "ok", IsValidSignature: true, TransactionID: fmt.Sprintf("%x", txID)})
-}
-
-// HandleTransactionBulk put and index new transactions in bulk. Transactions payload don't need signatures.
-// [
-//     {
-//         "id": "10001",
-//         "message": "Send 1 BTC to Ivan"
-//     },
-//     {
-//         "id": "10002",
-//         "message": "Send 2 BTC to Ivan"
-//     },
-//     {
-//         "id": "10003",
-//         "message": "Send 3 BTC to Ivan"
-//     },
-//     {
-//         "id": "10004",
-//         "message": "Send 4 BTC to Ivan"
-//     }
-// ]
-// WARNING: this makes the document unverifiable
-func (h HTTPHandler) HandleTransactionBulk(w http.ResponseWriter, r *http.Request) {
-	// find the index to operate on
-	vars := mux.Vars(r)
-	indexName := vars["collection"]
+	receipt, err := h.bf.Local.SignReceipt(tx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleTransaction",
+			"address": r.Header.Get("address"),
+		}).Error("couldn't sign the receipt: " + err.Error())
+	}
 
-	if nil == h.bf.Local.Search.BlockchainIndices[indexName] {
-		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
+	transactionCreationResponse := TransactionCreationResponse{Status: "ok", IsValidSignature: true, TransactionID: fmt.Sprintf("%x", tx.ID), Receipt: receipt}
+	if waitOptions.wait == "" {
+		mustEncode(w, transactionCreationResponse)
 		return
 	}
 
-	// read the request body
-	transactionBody, err := ioutil.ReadAll(r.Body)
+	// the transaction is accepted anyway. The clients may check its status later if the wait times out
+	deadline := time.Now().Add(waitOptions.timeout)
+	blockHash, err := h.r.WaitForCommit(tx.ID, waitOptions.timeout)
+	if err == pool.ErrWaitTimeout {
+		transactionCreationResponse.Status = "commit timeout"
+		w.WriteHeader(http.StatusAccepted)
+		mustEncode(w, transactionCreationResponse)
+		return
+	} else if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleTransaction",
+			"address": r.Header.Get("address"),
+		}).Error(err)
+		http.Error(w, "{\"message\": \"error waiting for the transaction: "+err.Error()+"\"}", 500)
+		return
+	}
+
+	if waitOptions.wait == "replicated" {
+		if _, err = h.r.WaitForReplication(blockHash, waitOptions.minPeers, time.Until(deadline)); err == pool.ErrWaitTimeout {
+			transactionCreationResponse.Status = "replication timeout"
+		}
+	}
+
+	committedTx, block, err := h.bf.Local.FindTransaction(tx.ID)
+	if err != nil || committedTx == nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleTransaction",
+			"address": r.Header.Get("address"),
+		}).Errorf("cannot find the committed transaction %x: %v", tx.ID, err)
+		http.Error(w, "{\"message\": \"error reading the committed transaction\"}", 500)
+		return
+	}
+
+	commit := getCommittedStatus(h.bf.Local, true, committedTx, block)
+	transactionCreationResponse.Commit = &commit
+	if transactionCreationResponse.Status != "ok" {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	mustEncode(w, transactionCreationResponse)
+}
+
+// HandleTransactionStatus returns if a transaction is pending or committed. A committed transaction comes with its block, merkle path and the peers which have replicated the block
+func (h HTTPHandler) HandleTransactionStatus(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
 	if err != nil {
-		http.Error(w, "{\"message\": \"error reading the request body: "+err.Error()+"\"}", 400)
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
 		return
 	}
 
-	// parse the request
-	var jsonDocs []map[string]interface{}
-	err = json.Unmarshal(transactionBody, &jsonDocs)
+	vars := mux.Vars(r)
+	txID, err := hex.DecodeString(vars["txId"])
 	if err != nil {
-		http.Error(w, "{\"message\": \"error parsing the payload: "+err.Error()+"\"}", 400)
+		http.Error(w, "{\"message\": \"invalid transaction ID\"}", 400)
 		return
 	}
 
-	accepted := 0
-	for _, transaction := range jsonDocs {
-		jsonBytes, err := json.Marshal(transaction)
+	address := r.Header.Get("address")
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "HandleTransactionStatus")
+	if err != nil {
+		http.Error(w, "{\"message\": \"error reading the transaction: "+err.Error()+"\"}", 400)
+		return
+	}
 
-		if err != nil {
-			log.WithFields(log.Fields{
-				"route":   "HandleTransaction",
-				"address": r.Header.Get("address"),
-			}).Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			mustEncode(w, TransactionBulkCreationResponse{Status: "cannot parse json payload", Total: len(jsonDocs), Accepted: accepted, Dropped: (len(jsonDocs) - accepted)})
+	// a transaction is removed from the pending ones only after its block is persisted
+	if tx := h.r.GetPendingTransaction(txID); tx != nil {
+		if !isReadable(account, tx, address) {
+			http.Error(w, "{\"message\": \"transaction doesn't exist\"}", 404)
 			return
 		}
 
-		fieldErrorMapping, err := h.r.PutWithoutSignature(jsonBytes, indexName, nil)
+		receipt, _ := h.bf.Local.SignReceipt(tx)
+		mustEncode(w, TransactionStatus{TransactionID: vars["txId"], Status: "pending", Collection: tx.Collection, BlockchainId: fmt.Sprintf("%x", h.bf.Local.PeerId), Receipt: receipt})
+		return
+	}
 
+	for _, blockchainPeer := range append([]*blockchain.Blockchain{h.bf.Local}, funk.Values(h.bf.Peers).([]*blockchain.Blockchain)...) {
+		tx, block, err := blockchainPeer.FindTransaction(txID)
 		if err != nil {
 			log.WithFields(log.Fields{
-				"route":   "HandleTransaction",
-				"address": r.Header.Get("address"),
+				"route":   "HandleTransactionStatus",
+				"address": address,
 			}).Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			mustEncode(w, TransactionBulkCreationResponse{Status: err.Error(), Total: len(jsonDocs), Accepted: accepted, Dropped: (len(jsonDocs) - accepted)})
+			http.Error(w, "{\"message\": \"error reading the transaction: "+err.Error()+"\"}", 500)
+			return
+		}
+		if tx == nil {
+			continue
+		}
+		if !isReadable(account, tx, address) {
+			break
+		}
+
+		mustEncode(w, getCommittedStatus(blockchainPeer, blockchainPeer == h.bf.Local, tx, block))
+		return
+	}
+
+	http.Error(w, "{\"message\": \"transaction doesn't exist\"}", 404)
+}
+
+// HandleTransactionBulk put and index new transactions in bulk. Either each document is signed like in HandleTransaction, or the batch has one signature over the merkle root
+// of the documents with the nonce or expiry and the permitted addresses of the batch, see blockchain.Envelope.BatchSigningMessage. Each document is accepted or rejected on its own
+// {
+//     "documents": [
+//         {"rawDocument": "{\"id\":\"10001\",\"message\":\"Send 1 BTC to Ivan\"}"},
+//         {"rawDocument": "{\"id\":\"10002\",\"message\":\"Send 2 BTC to Ivan\"}"}
+//     ],
+//     "batchSignature": "8e0063b76c2aed4982e1b62c713b0a7cf74f2b548b8c032659da65404c3d0b9777b8f8613f3e87e43680ec638949e263658ef5608bad7359e1075e285f49dd8d",
+//     "permittedAddresses" : ["0x07322C5A59047c09e87C284503F64f7FdDD17aBd"],
+//     "nonce": 2
+// }
+func (h HTTPHandler) HandleTransactionBulk(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	// find the index to operate on
+	vars := mux.Vars(r)
+	indexName := h.bf.Local.Search.ResolveCollection(vars["collection"])
+
+	if nil == h.bf.Local.Search.BlockchainIndices[indexName] {
+		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
+		return
+	}
+
+	// check writing permission
+	address := r.Header.Get("address")
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "HandleTransactionBulk")
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	if !funk.ContainsString(account.CollectionsWrite, indexName) {
+		log.WithFields(log.Fields{
+			"route":   "HandleTransactionBulk",
+			"address": address,
+		}).Info("insufficient permission to write to collection: ", indexName)
+		http.Error(w, "{\"message\": \"insufficient permission to write to collection: "+indexName+"\"}", 401)
+		return
+	}
+
+	// read the request body
+	transactionBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "{\"message\": \"error reading the request body: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	// parse the request
+	var bulkPayload BulkTransactionPayload
+	err = json.Unmarshal(transactionBody, &bulkPayload)
+	if err != nil {
+		http.Error(w, "{\"message\": \"error parsing the payload: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	if len(bulkPayload.Documents) == 0 {
+		http.Error(w, "{\"message\": \"no documents in the payload\"}", 400)
+		return
+	}
+
+	publicKey, err := hex.DecodeString(account.PublicKey)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleTransactionBulk",
+			"address": address,
+		}).Error("hex.DecodeString publicKey: " + err.Error())
+		http.Error(w, "{\"message\": \"couldn't recognize the publicKey: "+err.Error()+"\"}", 500)
+		return
+	}
+
+	bulkResponse := TransactionBulkCreationResponse{Total: len(bulkPayload.Documents), Results: make([]BulkDocumentResult, len(bulkPayload.Documents))}
+	var admissionErr *pool.AdmissionError
+
+	if !funk.IsEmpty(bulkPayload.BatchSignature) {
+		rawDocuments := make([][]byte, len(bulkPayload.Documents))
+		for i, document := range bulkPayload.Documents {
+			if !funk.IsEmpty(document.Signature) || !funk.IsEmpty(document.SignatureScheme) || document.Nonce > 0 || document.ExpiresAt > 0 || len(document.PermittedAddresses) > 0 {
+				http.Error(w, "{\"message\": \"the documents of a signed batch have no signatures, signature schemes, nonces, expiries or permitted addresses of their own\"}", 400)
+				return
+			}
+			if rawDocuments[i], err = document.document(); err != nil {
+				http.Error(w, "{\"message\": \"document "+strconv.Itoa(i)+": "+err.Error()+"\"}", 400)
+				return
+			}
+		}
+
+		signatureBytes, err := hex.DecodeString(bulkPayload.BatchSignature)
+		if err != nil {
+			http.Error(w, "{\"message\": \"couldn't recognize the batch signature: "+err.Error()+"\"}", 400)
+			return
+		}
+
+		if err = blockchain.CheckSignatureScheme(bulkPayload.SignatureScheme); err != nil {
+			http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
 			return
 		}
 
-		if fieldErrorMapping != nil {
+		envelope := blockchain.Envelope{Collection: indexName, Nonce: bulkPayload.Nonce, ExpiresAt: bulkPayload.ExpiresAt, PermittedAddresses: append(bulkPayload.PermittedAddresses, address)}
+		isValidSig, merkleRoot, batchResults, err := h.r.PutBatch(rawDocuments, envelope, address, account.KeyType, publicKey, signatureBytes, bulkPayload.SignatureScheme)
+		bulkResponse.MerkleRoot = fmt.Sprintf("%x", merkleRoot)
+		if !isValidSig {
+			w.WriteHeader(http.StatusBadRequest)
+			bulkResponse.Status = "bad signature"
+			bulkResponse.Dropped = bulkResponse.Total
+			bulkResponse.Results = nil
+			mustEncode(w, bulkResponse)
+			return
+		} else if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			mustEncode(w, TransactionBulkCreationResponse{Status: "field validation failed", Total: len(jsonDocs), Accepted: accepted, Dropped: (len(jsonDocs) - accepted), FieldErrorMapping: fieldErrorMapping})
+			bulkResponse.Status = err.Error()
+			bulkResponse.Dropped = bulkResponse.Total
+			bulkResponse.Results = nil
+			mustEncode(w, bulkResponse)
 			return
 		}
-		accepted++
+
+		for i, batchResult := range batchResults {
+			bulkResponse.Results[i] = BulkDocumentResult{Index: i, IsValidSignature: true, FieldErrorMapping: batchResult.FieldErrorMapping}
+			if batchResult.Transaction != nil {
+				bulkResponse.Results[i].TransactionID = fmt.Sprintf("%x", batchResult.Transaction.ID)
+			}
+			bulkResponse.Results[i].Status, admissionErr = bulkDocumentStatus(batchResult.FieldErrorMapping, batchResult.Err, admissionErr)
+		}
+	} else {
+		for i, document := range bulkPayload.Documents {
+			bulkResponse.Results[i] = BulkDocumentResult{Index: i}
+
+			rawDocument, err := document.document()
+			if err != nil {
+				bulkResponse.Results[i].Status = err.Error()
+				continue
+			}
+
+			if err = blockchain.CheckSignatureScheme(document.SignatureScheme); err != nil {
+				bulkResponse.Results[i].Status = err.Error()
+				continue
+			}
+
+			signatureBytes, err := hex.DecodeString(document.Signature)
+			if err != nil || len(signatureBytes) == 0 {
+				bulkResponse.Results[i].Status = "bad signature"
+				continue
+			}
+
+			envelope := blockchain.Envelope{Collection: indexName, Nonce: document.Nonce, ExpiresAt: document.ExpiresAt, PermittedAddresses: append(document.PermittedAddresses, address)}
+			isValidSig, fieldErrorMapping, tx, err := h.r.Put(rawDocument, envelope, address, account.KeyType, publicKey, signatureBytes, document.SignatureScheme, nil)
+			if !isValidSig {
+				bulkResponse.Results[i].Status = "bad signature"
+				continue
+			}
+
+			bulkResponse.Results[i].IsValidSignature = true
+			bulkResponse.Results[i].FieldErrorMapping = fieldErrorMapping
+			if tx != nil {
+				bulkResponse.Results[i].TransactionID = fmt.Sprintf("%x", tx.ID)
+			}
+			bulkResponse.Results[i].Status, admissionErr = bulkDocumentStatus(fieldErrorMapping, err, admissionErr)
+		}
+	}
+
+	for _, result := range bulkResponse.Results {
+		if result.Status == "ok" {
+			bulkResponse.Accepted++
+		}
+	}
+	bulkResponse.Dropped = bulkResponse.Total - bulkResponse.Accepted
+
+	if admissionErr != nil {
+		setRetryAfter(w, admissionErr)
+	}
+
+	if bulkResponse.Dropped == 0 {
+		bulkResponse.Status = "ok"
+		w.WriteHeader(http.StatusAccepted)
+	} else if bulkResponse.Accepted > 0 {
+		bulkResponse.Status = "partially accepted"
+		w.WriteHeader(http.StatusMultiStatus)
+	} else if admissionErr != nil {
+		bulkResponse.Status = "too many pending transactions"
+		w.WriteHeader(http.StatusTooManyRequests)
+	} else {
+		bulkResponse.Status = "no document accepted"
+		w.WriteHeader(http.StatusBadRequest)
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	mustEncode(w, TransactionBulkCreationResponse{Status: "ok", Total: len(jsonDocs), Accepted: accepted, Dropped: (len(jsonDocs) - accepted)})
+	mustEncode(w, bulkResponse)
 }
 
 // AccountRegistration register the account information
@@ -480,6 +1040,7 @@ func (h HTTPHandler) HandleTransactionBulk(w http.ResponseWriter, r *http.Reques
 //     "address": "699 Canton Court, Mulino, South Dakota, 9647",
 //     "publicKey":"e4a15344314a15c70a47e18fadc8117939a6dc5ed863ced84a898694b241d10fa129eff3989ec98393c52bac6d86d0d72534061538eb1e513aaae4def5f83fbb"
 // }
+// An Ed25519 account has "keyType": "ed25519" and the 32-byte public key in hex. Its address is derived from the keccak256 of the key as the secp256k1 ones
 func (h *HTTPHandler) AccountRegistration(w http.ResponseWriter, r *http.Request) {
 	// read the request body
 	requestBody, err := ioutil.ReadAll(r.Body)
@@ -500,10 +1061,13 @@ func (h *HTTPHandler) AccountRegistration(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	account.PublicKey = "04" + account.PublicKey // appending 04 to be compatible with ecdsa.PublicKey uncompressed form
-	account.Role.Name = "user"                   // user only registration
-	account.Role.CollectionsWrite = nil          // don't allow setting permissions
+	if account.KeyType == blockchain.KeyTypeSecp256k1 {
+		account.PublicKey = "04" + account.PublicKey // appending 04 to be compatible with ecdsa.PublicKey uncompressed form
+	}
+	account.Role.Name = "user"          // user only registration
+	account.Role.CollectionsWrite = nil // don't allow setting permissions
 	account.Role.CollectionsReadOverride = nil
+	account.Role.SensitiveFieldsRead = nil
 	account.LastModified = time.Now().UnixNano() / 1000000
 	publicKeyBytes, err := hex.DecodeString(account.PublicKey)
 	if err != nil {
@@ -512,7 +1076,7 @@ func (h *HTTPHandler) AccountRegistration(w http.ResponseWriter, r *http.Request
 	}
 
 	var address string
-	if address, err = blockchain.PublicKeyToAddress(publicKeyBytes); err != nil {
+	if address, err = blockchain.KeyAddress(account.KeyType, publicKeyBytes); err != nil {
 		http.Error(w, "{\"message\": \"error parsing public key: "+err.Error()+"\"}", 400)
 		return
 	}
@@ -636,6 +1200,9 @@ func (h *HTTPHandler) AccountUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	account.PublicKey = oldAccount.PublicKey
+	account.KeyType = oldAccount.KeyType
+	account.KeySince = oldAccount.KeySince
+	account.KeyHistory = oldAccount.KeyHistory
 	account.Role = oldAccount.Role
 	account.LastModified = time.Now().UnixNano() / 1000000
 
@@ -654,6 +1221,103 @@ func (h *HTTPHandler) AccountUpdate(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "{\"message\": \"account updated\", \"address\": \"%s\"}", address)
 }
 
+// AccountKeyRotation replaces the key of an account, keeping its address and role. The current key signs blockchain.Account.KeyRotationMessage,
+// or an admin forces the rotation of a lost or compromised key without a signature. The rotated key stays in the key history to verify the documents it signed
+// {
+//     "publicKey": "e4a15344314a15c70a47e18fadc8117939a6dc5ed863ced84a898694b241d10fa129eff3989ec98393c52bac6d86d0d72534061538eb1e513aaae4def5f83fbb",
+//     "signature": "8e0063b76c2aed4982e1b62c713b0a7cf74f2b548b8c032659da65404c3d0b9777b8f8613f3e87e43680ec638949e263658ef5608bad7359e1075e285f49dd8d"
+// }
+func (h *HTTPHandler) AccountKeyRotation(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	address := vars["address"]
+	isForced := address != r.Header.Get("address")
+
+	if isForced && r.Header.Get("role") != "admin" {
+		http.Error(w, "{\"message\": \"you can only rotate the key of your own account\"}", 401)
+		return
+	}
+
+	// read the request body
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "{\"message\": \"could not process the request body payload: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	var keyRotationPayload KeyRotationPayload
+	if err = json.Unmarshal(requestBody, &keyRotationPayload); err != nil {
+		http.Error(w, "{\"message\": \"error parsing the json payload: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "AccountKeyRotation")
+	if err != nil {
+		http.Error(w, "{\"message\": \"account doesn't exist\"}", 404)
+		return
+	}
+
+	newPublicKey := keyRotationPayload.PublicKey
+	if keyRotationPayload.KeyType == blockchain.KeyTypeSecp256k1 {
+		newPublicKey = "04" + newPublicKey // appending 04 to be compatible with ecdsa.PublicKey uncompressed form
+	}
+	newPublicKeyBytes, err := hex.DecodeString(newPublicKey)
+	if err != nil {
+		http.Error(w, "{\"message\": \"error parsing public key: "+err.Error()+"\"}", 400)
+		return
+	}
+	if err = blockchain.CheckPublicKey(keyRotationPayload.KeyType, newPublicKeyBytes); err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+	if strings.EqualFold(newPublicKey, account.PublicKey) {
+		http.Error(w, "{\"message\": \"the new key is the current key\"}", 400)
+		return
+	}
+
+	// an admin forcing the rotation doesn't have the current key
+	if !isForced {
+		publicKey, err := hex.DecodeString(account.PublicKey)
+		if err != nil {
+			http.Error(w, "{\"message\": \"couldn't recognize the publicKey: "+err.Error()+"\"}", 500)
+			return
+		}
+		signatureBytes, err := hex.DecodeString(keyRotationPayload.Signature)
+		if err != nil || !blockchain.IsValidSig(account.KeyType, account.KeyRotationMessage(address, keyRotationPayload.KeyType, keyRotationPayload.PublicKey), publicKey, signatureBytes) {
+			http.Error(w, "{\"message\": \"bad signature\"}", 401)
+			return
+		}
+	}
+
+	now := time.Now().UnixNano() / 1000000
+	account.RotateKey(keyRotationPayload.KeyType, newPublicKey, now)
+	account.LastModified = now
+
+	if err = h.bf.Local.RegisterAccount([]byte(address), *account); err != nil {
+		http.Error(w, "{\"message\": \"error adding the account: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"route":   "AccountKeyRotation",
+		"address": r.Header.Get("address"),
+	}).Info("rotated the key of account: ", address)
+
+	// broadcast to peers
+	accountMap := make(map[string]blockchain.Account)
+	accountMap[address] = *account
+	h.p2p.BroadcastObject(p2p.AccountsP2P{Accounts: accountMap})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"message\": \"key rotated\", \"address\": \"%s\", \"rotation\": %d}", address, len(account.KeyHistory))
+}
+
 // AccountGet returns an account's information for a given address
 func (h HTTPHandler) AccountGet(w http.ResponseWriter, r *http.Request) {
 	err := processJWT(r, false, h.secret)
@@ -702,7 +1366,9 @@ func (h HTTPHandler) AccountGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mustEncode(w, account.ToMap(r.Header.Get("role") == "admin"))
+	accountMap := account.ToMap(r.Header.Get("role") == "admin")
+	accountMap["nonce"] = h.bf.Local.GetNonce(address) // the next signed document needs a greater one
+	mustEncode(w, accountMap)
 }
 
 // SetAccountReadWrite set the user's collection-level read override and write permission
@@ -941,6 +1607,103 @@ func (h HTTPHandler) HandleMerklePath(w http.ResponseWriter, r *http.Request) {
 	mustEncode(w, rv)
 }
 
+// HandleDocumentGet returns a document with the sensitive fields the account may not read stripped or masked
+func (h HTTPHandler) HandleDocumentGet(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	document, account, err := h.readDocument(w, r, "HandleDocumentGet", vars["blockchainId"], vars["blockId"], vars["txId"])
+	if err != nil {
+		return
+	}
+
+	mustEncode(w, redactDocuments(h.bf.Local.Search, []blockchain.Document{*document}, account)[0])
+}
+
+// HandleDocumentOriginal returns a document as it was signed so that its signature can be verified. Only the issuer and the accounts which may read all its sensitive fields can download it
+func (h HTTPHandler) HandleDocumentOriginal(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	vars := mux.Vars(r)
+	document, account, err := h.readDocument(w, r, "HandleDocumentOriginal", vars["blockchainId"], vars["blockId"], vars["txId"])
+	if err != nil {
+		return
+	}
+
+	if document.Address != r.Header.Get("address") {
+		if redactDocuments(h.bf.Local.Search, []blockchain.Document{*document}, account)[0].Redacted {
+			http.Error(w, "{\"message\": \"not allowed to read the sensitive fields of the document\"}", 403)
+			return
+		}
+	}
+
+	mustEncode(w, document)
+}
+
+// readDocument looks up the document of a transaction and checks the account of the JWT can read it. The error response is written if it fails
+func (h HTTPHandler) readDocument(w http.ResponseWriter, r *http.Request, route string, blockchainId string, blockId string, txId string) (*blockchain.Document, *blockchain.Account, error) {
+	blockID, err := hex.DecodeString(blockId)
+	if err != nil {
+		http.Error(w, "{\"message\": \"invalid block ID\"}", 400)
+		return nil, nil, err
+	}
+	txID, err := hex.DecodeString(txId)
+	if err != nil {
+		http.Error(w, "{\"message\": \"invalid transaction ID\"}", 400)
+		return nil, nil, err
+	}
+
+	blockchainPeer, err := getBlockchainById(h.bf, blockchainId)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return nil, nil, err
+	}
+	if blockchainPeer == nil {
+		http.Error(w, "{\"message\": \"blockchain doesn't exist\"}", 404)
+		return nil, nil, errors.New("blockchain doesn't exist")
+	}
+
+	address := r.Header.Get("address")
+	account, err := getAccountFromDb(h.bf.Local.Db, address, route)
+	if err != nil {
+		http.Error(w, "{\"message\": \"error reading the document: "+err.Error()+"\"}", 400)
+		return nil, nil, err
+	}
+
+	var tx *blockchain.Transaction
+	blockchainPeer.Db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(blockchain.TransactionsBucket))
+		if b == nil {
+			return nil
+		}
+
+		if encodedTx := b.Get(append(append(blockID, []byte("_")...), txID...)); encodedTx != nil {
+			tx = blockchain.DeserializeTransaction(encodedTx)
+		}
+		return nil
+	})
+
+	if tx == nil || !isReadable(account, tx, address) {
+		log.WithFields(log.Fields{
+			"route":   route,
+			"address": address,
+		}).Warn("document doesn't exist")
+		http.Error(w, "{\"message\": \"document doesn't exist\"}", 404)
+		return nil, nil, errors.New("document doesn't exist")
+	}
+
+	document := getDocument(tx, address)
+	return &document, account, nil
+}
+
 // HandleSearch handles the search queries against the search engine
 // {
 // 	"size": 10,
@@ -954,6 +1717,8 @@ func (h HTTPHandler) HandleMerklePath(w http.ResponseWriter, r *http.Request) {
 // 		"fuzziness": 0
 // 	}
 // }
+// or GET with the query string syntax, e.g. /search/collection1?q=country:Canada +age:>30&size=10&from=0&sort=-age,_id&fields=country&facet=country:5
+// asOfHeight=<blockchainId>:<height> and asOfTime=<blockchainId>:<RFC3339 time> search the collection as it was at a point of each blockchain, e.g. ?asOfHeight=100&asOfTime=2020-01-01T00:00:00Z
 func (h HTTPHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 	err := processJWT(r, false, h.secret)
 	if err != nil {
@@ -963,25 +1728,39 @@ func (h HTTPHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 
 	// find the index to operate on
 	vars := mux.Vars(r)
-	indexName := vars["collection"]
+	indexName := h.bf.Local.Search.ResolveCollection(vars["collection"])
 
 	if nil == h.bf.Local.Search.BlockchainIndices[indexName] {
 		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
 		return
 	}
 
-	// read the request body
-	requestBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "{\"message\": \"err reading the request body: "+err.Error()+"\"}", 400)
-		return
+	var searchRequest *bleve.SearchRequest
+	if r.Method == "GET" && !funk.IsEmpty(r.URL.Query().Get("q")) {
+		// parse the query string syntax from the url
+		searchRequest, err = getSearchRequestFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, "{\"message\": \"error parsing the query: "+err.Error()+"\"}", 400)
+			return
+		}
+	} else {
+		// read the request body
+		requestBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "{\"message\": \"err reading the request body: "+err.Error()+"\"}", 400)
+			return
+		}
+
+		// parse the request
+		err = json.Unmarshal(requestBody, &searchRequest)
+		if err != nil {
+			http.Error(w, "{\"message\": \"error parsing the query: "+err.Error()+"\"}", 400)
+			return
+		}
 	}
 
-	// parse the request
-	var searchRequest bleve.SearchRequest
-	err = json.Unmarshal(requestBody, &searchRequest)
-	if err != nil {
-		http.Error(w, "{\"message\": \"error parsing the query: "+err.Error()+"\"}", 400)
+	if searchRequest == nil || searchRequest.Query == nil {
+		http.Error(w, "{\"message\": \"query is required\"}", 400)
 		return
 	}
 
@@ -1016,6 +1795,11 @@ func (h HTTPHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = checkSensitiveSearch(h.bf.Local.Search, searchRequest, account, []string{indexName}); err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
 	if !funk.ContainsString(account.CollectionsReadOverride, indexName) {
 		// only addresses in _permittedAddresses can access
 		permittedAddressesQuery := query.NewMatchQuery(r.Header.Get("address"))
@@ -1025,10 +1809,21 @@ func (h HTTPHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 		searchRequest.Query = conjunctQuery
 	}
 
-	// validate the query
-	if srqv, ok := searchRequest.Query.(query.ValidatableQuery); ok {
-		err = srqv.Validate()
-		if err != nil {
+	// the point in time of each blockchain to search as of
+	if err = checkBlockHeights(h.bf.Local.Search, r.URL.Query().Get("asOfHeight"), "asOfHeight", []string{indexName}); err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+	searchRequest.Query, err = asOfQuery(searchRequest.Query, r.URL.Query().Get("asOfHeight"), r.URL.Query().Get("asOfTime"))
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
+	// validate the query
+	if srqv, ok := searchRequest.Query.(query.ValidatableQuery); ok {
+		err = srqv.Validate()
+		if err != nil {
 			log.WithFields(log.Fields{
 				"route":   "HandleSearch",
 				"address": r.Header.Get("address"),
@@ -1039,8 +1834,9 @@ func (h HTTPHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	searchRequest.Explain = false
+	searchRequest.Fields = append(searchRequest.Fields, "_peerId") // route the hits to their blockchain dbs
 	// execute the query
-	searchResponse, err := h.bf.Local.Search.BlockchainIndices[indexName].Search(&searchRequest)
+	searchResponse, err := h.bf.Local.Search.BlockchainIndices[indexName].Search(searchRequest)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"route":   "HandleSearch",
@@ -1050,26 +1846,264 @@ func (h HTTPHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hits := []blockchain.Document{}
-	for _, hit := range searchResponse.Hits {
-		// first search the local blockchain db
-		hitDoc := getTransactionFromDb(h.bf.Local.Db, hit.ID, r.Header.Get("address"))
+	hits := redactDocuments(h.bf.Local.Search, getDocuments(h.bf, searchResponse.Hits, r.Header.Get("address")), account)
+	facets := redactFacets(h.bf.Local.Search, searchResponse.Facets, account, []string{indexName})
 
-		if !funk.IsEmpty(hitDoc) {
-			hits = append(hits, hitDoc)
+	mustEncode(w, SearchResponse{Collection: indexName, Status: searchResponse.Status, Total: searchResponse.Total, Hits: hits, Facets: facets})
+}
+
+// HandleSimilarSearch finds the documents similar to a transaction or a raw document in a collection ("more like this")
+// {
+// 	"blockchainId": "b6a7f4f3c49ab8d1b1b2ec4e7a58ee4e0bd2f1d3c8c0b2b5b3b0ee8c2e4e3f0a",
+// 	"blockId": "f7d5f0e1c4b2a3d6e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1",
+// 	"txId": "0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d",
+// 	"fields": ["title", "description"],
+// 	"maxQueryTerms": 25,
+// 	"size": 10
+// }
+// or "document": {"title": "...", "description": "..."} instead of the transaction
+func (h HTTPHandler) HandleSimilarSearch(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	// find the index to operate on
+	vars := mux.Vars(r)
+	indexName := h.bf.Local.Search.ResolveCollection(vars["collection"])
+
+	index := h.bf.Local.Search.BlockchainIndices[indexName]
+	if nil == index {
+		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
+		return
+	}
+
+	// read the request body
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "{\"message\": \"err reading the request body: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	// parse the request
+	var similarSearchRequest SimilarSearchRequest
+	err = json.Unmarshal(requestBody, &similarSearchRequest)
+	if err != nil {
+		http.Error(w, "{\"message\": \"error parsing the query: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	isTransaction := !funk.IsEmpty(similarSearchRequest.TxId)
+	if isTransaction == (len(similarSearchRequest.Document) > 0) {
+		http.Error(w, "{\"message\": \"either txId or document is required\"}", 400)
+		return
+	}
+
+	address := r.Header.Get("address")
+	var account *blockchain.Account
+	var rawData []byte
+	var mustNotQuery query.Query
+	if isTransaction {
+		document, documentAccount, err := h.readDocument(w, r, "HandleSimilarSearch", similarSearchRequest.BlockchainId, similarSearchRequest.BlockId, similarSearchRequest.TxId)
+		if err != nil {
+			return
 		}
-		// search the peer blockchain dbs
-		for _, peer := range h.bf.Peers {
-			hitDoc := getTransactionFromDb(peer.Db, hit.ID, r.Header.Get("address"))
-			if !funk.IsEmpty(hitDoc) {
-				hits = append(hits, hitDoc)
-			}
+		if document.Collection != indexName {
+			http.Error(w, "{\"message\": \"the document is not in collection: "+indexName+"\"}", 400)
+			return
+		}
+
+		account = documentAccount
+		rawData = []byte(document.Source)
+
+		// the document itself is the most similar
+		blockHash, _ := hex.DecodeString(document.BlockID)
+		txID, _ := hex.DecodeString(document.ID)
+		mustNotQuery = query.NewDocIDQuery([]string{string(append(append(blockHash, []byte("_")...), txID...))})
+	} else {
+		account, err = getAccountFromDb(h.bf.Local.Db, address, "HandleSimilarSearch")
+		if err != nil {
+			http.Error(w, "{\"message\": \"error running query: "+err.Error()+"\"}", 400)
+			return
 		}
+		rawData = similarSearchRequest.Document
+	}
+
+	documentMapping, err := h.bf.Local.Search.GetDocumentMapping(indexName)
+	if err != nil {
+		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
+		return
+	}
+
+	similarityQuery, err := similarSearchRequest.SimilarityQuery(index, *documentMapping, rawData, account.Role)
+	if err != nil {
+		http.Error(w, "{\"message\": \"error building the query: "+err.Error()+"\"}", 400)
+		return
 	}
 
+	var q query.Query = similarityQuery
+	if mustNotQuery != nil {
+		q = query.NewBooleanQuery([]query.Query{similarityQuery}, nil, []query.Query{mustNotQuery})
+	}
+
+	if similarSearchRequest.Size <= 0 {
+		similarSearchRequest.Size = 10
+	}
+
+	searchRequest := bleve.NewSearchRequestOptions(permittedQuery(q, account, address, []string{indexName}), similarSearchRequest.Size, similarSearchRequest.From, false)
+	searchRequest.Fields = []string{"_peerId"} // route the hits to their blockchain dbs
+	// execute the query
+	searchResponse, err := index.Search(searchRequest)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleSimilarSearch",
+			"address": address,
+		}).Error("error executing query: " + err.Error())
+		http.Error(w, "error executing query: "+err.Error(), 500)
+		return
+	}
+
+	hits := redactDocuments(h.bf.Local.Search, getDocuments(h.bf, searchResponse.Hits, address), account)
+
 	mustEncode(w, SearchResponse{Collection: indexName, Status: searchResponse.Status, Total: searchResponse.Total, Hits: hits})
 }
 
+// HandleMultiSearch handles the search queries against multiple collections, optionally scoped to some blockchains
+// {
+// 	"collections": ["invoices", "receipts"],
+// 	"blockchainIds": ["b6a7f4f3c49ab8d1b1b2ec4e7a58ee4e0bd2f1d3c8c0b2b5b3b0ee8c2e4e3f0a"],
+// 	"asOfHeight": "b6a7f4f3c49ab8d1b1b2ec4e7a58ee4e0bd2f1d3c8c0b2b5b3b0ee8c2e4e3f0a:100",
+// 	"search": {
+// 		"size": 10,
+// 		"query": {
+// 			"match": "Canada",
+// 			"field": "country"
+// 		}
+// 	}
+// }
+func (h HTTPHandler) HandleMultiSearch(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	// read the request body
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "{\"message\": \"err reading the request body: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	// parse the request
+	var multiSearchRequest MultiSearchRequest
+	err = json.Unmarshal(requestBody, &multiSearchRequest)
+	if err != nil {
+		http.Error(w, "{\"message\": \"error parsing the query: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	if len(multiSearchRequest.Collections) == 0 || multiSearchRequest.Search == nil || multiSearchRequest.Search.Query == nil {
+		http.Error(w, "{\"message\": \"collections and search are required\"}", 400)
+		return
+	}
+
+	// the alias merges the hits of all the collections
+	var indices []bleve.Index
+	for i, collection := range multiSearchRequest.Collections {
+		collection = h.bf.Local.Search.ResolveCollection(collection)
+		multiSearchRequest.Collections[i] = collection
+
+		index := h.bf.Local.Search.BlockchainIndices[collection]
+		if nil == index {
+			http.Error(w, "{\"message\": \"no such collection: "+collection+"\"}", 404)
+			return
+		}
+		indices = append(indices, index)
+	}
+	indexAlias := bleve.NewIndexAlias(indices...)
+
+	// only the documents from the given blockchains. all known blockchains if not specified
+	if len(multiSearchRequest.BlockchainIds) > 0 {
+		var peerIdQueries []query.Query
+		for _, blockchainId := range multiSearchRequest.BlockchainIds {
+			blockchainPeer, err := getBlockchainById(h.bf, blockchainId)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if blockchainPeer == nil {
+				http.Error(w, "{\"message\": \"blockchain doesn't exist: "+blockchainId+"\"}", 404)
+				return
+			}
+
+			peerIdQuery := query.NewMatchQuery(fmt.Sprintf("%x", blockchainPeer.PeerId))
+			peerIdQuery.SetField("_peerId")
+			peerIdQueries = append(peerIdQueries, peerIdQuery)
+		}
+
+		multiSearchRequest.Search.Query = query.NewConjunctionQuery([]query.Query{multiSearchRequest.Search.Query, query.NewDisjunctionQuery(peerIdQueries)})
+	}
+
+	// check read overriding permission
+	address := r.Header.Get("address")
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "HandleMultiSearch")
+	if err != nil {
+		http.Error(w, "{\"message\": \"error running query: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	if err = checkSensitiveSearch(h.bf.Local.Search, multiSearchRequest.Search, account, multiSearchRequest.Collections); err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
+	multiSearchRequest.Search.Query = permittedQuery(multiSearchRequest.Search.Query, account, address, multiSearchRequest.Collections)
+
+	// the point in time of each blockchain to search as of
+	if err = checkBlockHeights(h.bf.Local.Search, multiSearchRequest.AsOfHeight, "asOfHeight", multiSearchRequest.Collections); err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+	multiSearchRequest.Search.Query, err = asOfQuery(multiSearchRequest.Search.Query, multiSearchRequest.AsOfHeight, multiSearchRequest.AsOfTime)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
+	// validate the query
+	if srqv, ok := multiSearchRequest.Search.Query.(query.ValidatableQuery); ok {
+		err = srqv.Validate()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"route":   "HandleMultiSearch",
+				"address": address,
+			}).Errorf("error validating the query: %s. The query: %s", err.Error(), multiSearchRequest.Search.Query)
+			http.Error(w, "{\"message\": \"error validating the query: "+err.Error()+"\"}", 400)
+			return
+		}
+	}
+
+	multiSearchRequest.Search.Explain = false
+	multiSearchRequest.Search.Fields = append(multiSearchRequest.Search.Fields, "_peerId") // route the hits to their blockchain dbs
+	// execute the query
+	searchResponse, err := indexAlias.Search(multiSearchRequest.Search)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleMultiSearch",
+			"address": address,
+		}).Error("error executing query: " + err.Error())
+		http.Error(w, "error executing query: "+err.Error(), 500)
+		return
+	}
+
+	hits := redactDocuments(h.bf.Local.Search, getDocuments(h.bf, searchResponse.Hits, address), account)
+	facets := redactFacets(h.bf.Local.Search, searchResponse.Facets, account, multiSearchRequest.Collections)
+
+	mustEncode(w, MultiSearchResponse{Collections: multiSearchRequest.Collections, BlockchainIds: multiSearchRequest.BlockchainIds, Status: searchResponse.Status, Total: searchResponse.Total, Hits: hits, Facets: facets})
+}
+
 // HandleJWT checks the credentials and return corresponding JWT
 // {
 // 	"address": "0x07322C5A59047c09e87C284503F64f7FdDD17aBd",
@@ -1117,7 +2151,13 @@ func (h HTTPHandler) HandleJWT(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isValidSig := blockchain.IsValidSig([]byte(authPayload.ChallengeWord), publicKey, signatureBytes)
+	challengeDigest, err := blockchain.ChallengeDigest(authPayload.SignatureScheme, authPayload.ChallengeWord)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
+	isValidSig := blockchain.IsValidKeySig(account.KeyType, challengeDigest, publicKey, signatureBytes)
 	if !isValidSig {
 		http.Error(w, "{\"message\": \"signature invalid\"}", 400)
 		return
@@ -1210,42 +2250,452 @@ func processJWT(r *http.Request, requireAdmin bool, secret string) error {
 	return nil
 }
 
-func getTransactionFromDb(db *bolt.DB, hitId string, address string) blockchain.Document {
-	var hitDoc blockchain.Document
+// getWaitOptionsFromQuery reads the url query parameters of a synchronous write: wait (commit or replicated), minPeers and timeout in milliseconds
+func getWaitOptionsFromQuery(values url.Values) (*waitOptions, error) {
+	options := waitOptions{wait: values.Get("wait"), minPeers: 1, timeout: defaultWaitTimeout}
+	var err error
+
+	if options.wait != "" && options.wait != "commit" && options.wait != "replicated" {
+		return nil, fmt.Errorf("invalid wait: %s. Must be commit or replicated", options.wait)
+	}
+
+	if !funk.IsEmpty(values.Get("minPeers")) {
+		if options.wait != "replicated" {
+			return nil, errors.New("minPeers only applies to wait=replicated")
+		} else if options.minPeers, err = strconv.Atoi(values.Get("minPeers")); err != nil || options.minPeers <= 0 {
+			return nil, fmt.Errorf("invalid minPeers: %s", values.Get("minPeers"))
+		}
+	}
+
+	if !funk.IsEmpty(values.Get("timeout")) {
+		timeout, err := strconv.Atoi(values.Get("timeout"))
+		if err != nil || timeout <= 0 || time.Duration(timeout)*time.Millisecond > maxWaitTimeout {
+			return nil, fmt.Errorf("invalid timeout: %s. Must be between 1 and %d milliseconds", values.Get("timeout"), maxWaitTimeout/time.Millisecond)
+		}
+		options.timeout = time.Duration(timeout) * time.Millisecond
+	}
+
+	return &options, nil
+}
+
+// getSearchRequestFromQuery builds a search request from the url query parameters: q, size, from, sort, fields and facet
+func getSearchRequestFromQuery(values url.Values) (*bleve.SearchRequest, error) {
+	size := 10
+	from := 0
+	var err error
+
+	if !funk.IsEmpty(values.Get("size")) {
+		if size, err = strconv.Atoi(values.Get("size")); err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size: %s", values.Get("size"))
+		}
+	}
+
+	if !funk.IsEmpty(values.Get("from")) {
+		if from, err = strconv.Atoi(values.Get("from")); err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid from: %s", values.Get("from"))
+		}
+	}
+
+	searchRequest := bleve.NewSearchRequestOptions(bleve.NewQueryStringQuery(values.Get("q")), size, from, false)
+
+	if !funk.IsEmpty(values.Get("sort")) {
+		searchRequest.SortBy(strings.Split(values.Get("sort"), ","))
+	}
+
+	if !funk.IsEmpty(values.Get("fields")) {
+		searchRequest.Fields = strings.Split(values.Get("fields"), ",")
+	}
+
+	// facet=field or facet=field:size, named after the field
+	for _, facet := range values["facet"] {
+		facetSize := 10
+		facetParts := strings.SplitN(facet, ":", 2)
+
+		if len(facetParts) == 2 {
+			if facetSize, err = strconv.Atoi(facetParts[1]); err != nil || facetSize <= 0 {
+				return nil, fmt.Errorf("invalid facet size: %s", facet)
+			}
+		}
+
+		searchRequest.AddFacet(facetParts[0], bleve.NewFacetRequest(facetParts[0], facetSize))
+	}
+
+	return searchRequest, nil
+}
+
+// asOfQuery limits a query to the documents in the blocks at or before asOfHeight and accepted at or before asOfTime, e.g.
+// asOfHeight=<blockchainId>:<height>,<blockchainId>:<height>&asOfTime=<blockchainId>:2020-01-01T00:00:00Z,2020-06-01T00:00:00Z
+// A point without blockchainId applies to the rest of the blockchains, and the blockchains without a point are not limited
+func asOfQuery(q query.Query, asOfHeight string, asOfTime string) (query.Query, error) {
+	asOfHeights, err := parseFromHeights(asOfHeight)
+	if err != nil {
+		return nil, fmt.Errorf("invalid asOfHeight: %s", asOfHeight)
+	}
+
+	asOfTimes, err := parseAsOfTimes(asOfTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(asOfHeights) == 0 && len(asOfTimes) == 0 {
+		return q, nil
+	}
+
+	// the limits of a blockchain, falling back to the ones without blockchainId
+	pointQueries := func(blockchainId string) []query.Query {
+		var queries []query.Query
+		inclusive := true
+
+		height, ok := asOfHeights[blockchainId]
+		if !ok {
+			height, ok = asOfHeights[""]
+		}
+		if ok {
+			max := float64(height)
+			heightQuery := query.NewNumericRangeInclusiveQuery(nil, &max, nil, &inclusive)
+			heightQuery.SetField("_blockHeight")
+			queries = append(queries, heightQuery)
+		}
+
+		asOf, ok := asOfTimes[blockchainId]
+		if !ok {
+			asOf, ok = asOfTimes[""]
+		}
+		if ok {
+			timeQuery := query.NewDateRangeInclusiveQuery(time.Time{}, asOf, nil, &inclusive)
+			timeQuery.SetField("_timestamp")
+			queries = append(queries, timeQuery)
+		}
+
+		return queries
+	}
+
+	var blockchainQueries []query.Query
+	var peerIdQueries []query.Query
+	for _, blockchainId := range funk.UniqString(append(funk.Keys(asOfHeights).([]string), funk.Keys(asOfTimes).([]string)...)) {
+		if funk.IsEmpty(blockchainId) {
+			continue
+		}
+		if _, err := hex.DecodeString(blockchainId); err != nil {
+			return nil, fmt.Errorf("invalid blockchain ID: %s", blockchainId)
+		}
+
+		peerIdQuery := query.NewMatchQuery(blockchainId)
+		peerIdQuery.SetField("_peerId")
+		peerIdQueries = append(peerIdQueries, peerIdQuery)
+
+		blockchainQueries = append(blockchainQueries, query.NewConjunctionQuery(append([]query.Query{peerIdQuery}, pointQueries(blockchainId)...)))
+	}
+
+	// the rest of the blockchains
+	restQueries := pointQueries("")
+	if len(restQueries) == 0 {
+		restQueries = []query.Query{query.NewMatchAllQuery()}
+	}
+	blockchainQueries = append(blockchainQueries, query.NewBooleanQuery(restQueries, nil, peerIdQueries))
+
+	return query.NewConjunctionQuery([]query.Query{q, query.NewDisjunctionQuery(blockchainQueries)}), nil
+}
+
+// checkBlockHeights rejects a parameter of block heights on the collections indexed without _blockHeight, which older versions didn't index
+func checkBlockHeights(s *blockchain.Search, heights string, parameter string, collections []string) error {
+	if funk.IsEmpty(heights) {
+		return nil
+	}
+
+	for _, collection := range collections {
+		if !s.IsFieldMapped(collection, "_blockHeight") {
+			return fmt.Errorf("%s is not supported on collection %s, which was indexed without block heights", parameter, collection)
+		}
+	}
+
+	return nil
+}
+
+// parseAsOfTimes parses asOfTime into blockchainId -> time. The key "" applies to all the blockchains
+func parseAsOfTimes(asOfTime string) (map[string]time.Time, error) {
+	asOfTimes := make(map[string]time.Time)
+	if funk.IsEmpty(asOfTime) {
+		return asOfTimes, nil
+	}
+
+	for _, blockchainTime := range strings.Split(asOfTime, ",") {
+		// the time has colons too
+		blockchainId, timeString := "", blockchainTime
+		if _, err := time.Parse(time.RFC3339, blockchainTime); err != nil {
+			if parts := strings.SplitN(blockchainTime, ":", 2); len(parts) == 2 {
+				blockchainId, timeString = parts[0], parts[1]
+			}
+		}
+
+		asOf, err := time.Parse(time.RFC3339, timeString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid asOfTime: %s", blockchainTime)
+		}
+		asOfTimes[blockchainId] = asOf
+	}
+
+	return asOfTimes, nil
+}
+
+// getDocuments hydrates the search hits from the blockchain dbs. A hit goes straight to the blockchain of its stored _peerId; hits indexed without it fall back to the first blockchain having the transaction
+func getDocuments(bf *p2p.BlockchainForest, hits search.DocumentMatchCollection, address string) []blockchain.Document {
+	documents := make([]blockchain.Document, len(hits))
+	hitsByBlockchain := make(map[*blockchain.Blockchain][]int)
+	var unrouted []int
+
+	for i, hit := range hits {
+		peerId, _ := hit.Fields["_peerId"].(string)
+		blockchainPeer, err := getBlockchainById(bf, peerId)
+
+		if funk.IsEmpty(peerId) || err != nil || blockchainPeer == nil {
+			unrouted = append(unrouted, i)
+		} else {
+			hitsByBlockchain[blockchainPeer] = append(hitsByBlockchain[blockchainPeer], i)
+		}
+	}
+
+	for blockchainPeer, hitIndices := range hitsByBlockchain {
+		unrouted = append(unrouted, getTransactionsFromDb(blockchainPeer.Db, hits, hitIndices, documents, address)...)
+	}
+
+	// first search the local blockchain db and then the peer blockchain dbs
+	if len(unrouted) > 0 {
+		unrouted = getTransactionsFromDb(bf.Local.Db, hits, unrouted, documents, address)
+	}
+	for _, peer := range bf.Peers {
+		if len(unrouted) == 0 {
+			break
+		}
+		unrouted = getTransactionsFromDb(peer.Db, hits, unrouted, documents, address)
+	}
+
+	hitDocs := []blockchain.Document{}
+	for _, hitDoc := range documents {
+		if !funk.IsEmpty(hitDoc) {
+			hitDocs = append(hitDocs, hitDoc)
+		}
+	}
+
+	return hitDocs
+}
+
+// getTransactionsFromDb looks up the hits at hitIndices in one db transaction and returns the indices of the hits not found in the db
+func getTransactionsFromDb(db *bolt.DB, hits search.DocumentMatchCollection, hitIndices []int, documents []blockchain.Document, address string) []int {
+	var notFound []int
 	db.View(func(dbtx *bolt.Tx) error {
 		// Assume bucket exists and has keys
 		b := dbtx.Bucket([]byte(blockchain.TransactionsBucket))
 
-		v := b.Get([]byte(hitId))
+		for _, i := range hitIndices {
+			v := b.Get([]byte(hits[i].ID))
 
-		if v == nil {
-			return nil
+			if v == nil {
+				notFound = append(notFound, i)
+				continue
+			}
+
+			documents[i] = getDocument(blockchain.DeserializeTransaction(v), address)
+			documents[i].Score = hits[i].Score
+		}
+
+		return nil
+	})
+
+	return notFound
+}
+
+// getDocument converts a transaction to the document in the search result
+func getDocument(tx *blockchain.Transaction, address string) blockchain.Document {
+	transactionAddress, err := blockchain.TransactionAddress(tx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleSearch",
+			"address": address,
+		}).Error("error getting the address of the transaction: ", err.Error())
+		return blockchain.Document{}
+	}
+
+	document := blockchain.Document{ID: fmt.Sprintf("%x", tx.ID), BlockID: fmt.Sprintf("%x", tx.BlockHash), BlockchainId: fmt.Sprintf("%x", tx.PeerId), Collection: tx.Collection, Source: fmt.Sprintf("%s", tx.RawData), Timestamp: time.Unix(0, tx.AcceptedTimestamp*int64(time.Millisecond)).Format(time.RFC3339Nano), Signature: fmt.Sprintf("%x", tx.Signature), Address: transactionAddress}
+	document.SignatureScheme = tx.SignatureScheme
+	if tx.Delegation != nil {
+		document.Principal = tx.Delegation.Principal
+		document.Delegate = tx.Delegation.Delegate
+	}
+	if blockchain.EnvelopeOf(tx).IsBound() {
+		document.Nonce = tx.Nonce
+		document.ExpiresAt = tx.ExpiresAt
+		document.PermittedAddresses = tx.PermittedAddresses
+	}
+
+	return document
+}
+
+// redactDocuments strips or masks the sensitive fields in the _source of the documents which the account may not read
+func redactDocuments(s *blockchain.Search, documents []blockchain.Document, account *blockchain.Account) []blockchain.Document {
+	documentMappings := make(map[string]*blockchain.DocumentMapping)
+
+	for i, document := range documents {
+		documentMapping, ok := documentMappings[document.Collection]
+		if !ok {
+			documentMapping, _ = s.GetDocumentMapping(document.Collection)
+			documentMappings[document.Collection] = documentMapping
 		}
 
-		tx := blockchain.DeserializeTransaction(v)
+		if documentMapping != nil {
+			source, isRedacted := documentMapping.Redact([]byte(document.Source), account.Role)
+			documents[i].Source = string(source)
+			documents[i].Redacted = isRedacted
+		}
+	}
 
-		var publicKey *ecdsa.PublicKey
-		var transactionAddress string
-		var err error
-		if tx.PubKey != nil {
-			if publicKey, err = crypto.UnmarshalPubkey(tx.PubKey); err != nil {
-				log.WithFields(log.Fields{
-					"route":   "HandleSearch",
-					"address": address,
-				}).Error("error unmarshal public key bytes: ", err.Error())
-				return err
+	return documents
+}
+
+// redactFacets removes the facets on the sensitive fields which the account may not read, which would reveal their values
+func redactFacets(s *blockchain.Search, facets search.FacetResults, account *blockchain.Account, collections []string) search.FacetResults {
+	for _, collection := range collections {
+		documentMapping, err := s.GetDocumentMapping(collection)
+		if err != nil {
+			continue
+		}
+
+		for field := range documentMapping.SensitiveFields() {
+			if account.Role.CanReadSensitiveField(collection, field) {
+				continue
 			}
-			transactionAddress = crypto.PubkeyToAddress(*publicKey).String()
-		} else {
-			transactionAddress = ""
+
+			for facetName, facet := range facets {
+				if facet.Field == field {
+					delete(facets, facetName)
+				}
+			}
+		}
+	}
+
+	return facets
+}
+
+// checkSensitiveSearch rejects a search which names a sensitive field of the collections the account may not read, see blockchain.DocumentMapping.CheckSensitiveSearch
+func checkSensitiveSearch(s *blockchain.Search, searchRequest *bleve.SearchRequest, account *blockchain.Account, collections []string) error {
+	for _, collection := range collections {
+		documentMapping, err := s.GetDocumentMapping(collection)
+		if err != nil {
+			return err
+		}
+
+		if err = documentMapping.CheckSensitiveSearch(s.BlockchainIndices[collection], searchRequest, account.Role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getAccountFromDb reads an account from the accounts bucket
+func getAccountFromDb(db *bolt.DB, address string, route string) (*blockchain.Account, error) {
+	var account *blockchain.Account
+	err := db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(blockchain.AccountsBucket))
+		if b == nil {
+			log.WithFields(log.Fields{
+				"route":   route,
+				"address": address,
+			}).Warn("bucket doesn't exist")
+			return errors.New("bucket doesn't exist")
 		}
 
-		hitDoc = blockchain.Document{ID: fmt.Sprintf("%x", tx.ID), BlockID: fmt.Sprintf("%x", tx.BlockHash), BlockchainId: fmt.Sprintf("%x", tx.PeerId), Source: fmt.Sprintf("%s", tx.RawData), Timestamp: time.Unix(0, tx.AcceptedTimestamp*int64(time.Millisecond)).Format(time.RFC3339Nano), Signature: fmt.Sprintf("%x", tx.Signature), Address: transactionAddress}
+		encodedAccount := b.Get([]byte(address))
+		if encodedAccount == nil {
+			log.WithFields(log.Fields{
+				"route":   route,
+				"address": address,
+			}).Warn("account doesn't exist")
+			return errors.New("account doesn't exist")
+		}
+		account = blockchain.DeserializeAccount(encodedAccount)
 
 		return nil
 	})
 
-	return hitDoc
+	return account, err
+}
+
+// isReadable tells if an account can read a transaction. The same as the search, with the read override of its collection or in _permittedAddresses
+func isReadable(account *blockchain.Account, tx *blockchain.Transaction, address string) bool {
+	return funk.ContainsString(account.CollectionsReadOverride, tx.Collection) || funk.ContainsString(tx.PermittedAddresses, address)
+}
+
+// permittedQuery limits a query to the documents an account can read. Each collection is matched by _type so that the read override of one collection doesn't leak into another
+func permittedQuery(q query.Query, account *blockchain.Account, address string, collections []string) query.Query {
+	var collectionQueries []query.Query
+	isOverridden := true
+
+	for _, collection := range collections {
+		typeQuery := query.NewMatchPhraseQuery(collection)
+		typeQuery.SetField("_type")
+
+		if funk.ContainsString(account.CollectionsReadOverride, collection) {
+			collectionQueries = append(collectionQueries, typeQuery)
+		} else {
+			// only addresses in _permittedAddresses can access
+			permittedAddressesQuery := query.NewMatchQuery(address)
+			permittedAddressesQuery.SetField("_permittedAddresses")
+
+			collectionQueries = append(collectionQueries, query.NewConjunctionQuery([]query.Query{typeQuery, permittedAddressesQuery}))
+			isOverridden = false
+		}
+	}
+
+	if isOverridden {
+		return q
+	}
+
+	return query.NewConjunctionQuery([]query.Query{q, query.NewDisjunctionQuery(collectionQueries)})
+}
+
+// bulkDocumentStatus describes why a document in bulk is rejected, "ok" if it's accepted. Keeps the first error of the admission limits to tell when to retry
+func bulkDocumentStatus(fieldErrorMapping map[string]string, err error, admissionErr *pool.AdmissionError) (string, *pool.AdmissionError) {
+	if documentAdmissionErr, ok := err.(*pool.AdmissionError); ok && admissionErr == nil {
+		admissionErr = documentAdmissionErr
+	}
+
+	if err != nil {
+		return err.Error(), admissionErr
+	} else if fieldErrorMapping != nil {
+		return "field validation failed", admissionErr
+	}
+
+	return "ok", admissionErr
+}
+
+// setRetryAfter tells the client when to retry a transaction rejected by a limit of the queue, in whole seconds
+func setRetryAfter(w http.ResponseWriter, admissionErr *pool.AdmissionError) {
+	seconds := int((admissionErr.RetryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// getCommittedStatus builds the status of a committed transaction with the merkle path of its block
+func getCommittedStatus(bc *blockchain.Blockchain, isLocal bool, tx *blockchain.Transaction, block *blockchain.Block) TransactionStatus {
+	transactionStatus := TransactionStatus{TransactionID: fmt.Sprintf("%x", tx.ID), Status: "committed", Collection: tx.Collection, BlockchainId: fmt.Sprintf("%x", bc.PeerId), BlockId: fmt.Sprintf("%x", block.Hash), BlockHeight: &block.Height}
+
+	transactionStatus.VerificationPath = make(map[int]string)
+	for index, hash := range block.GetMerkleTree().GetVerificationPath(tx.ID) {
+		transactionStatus.VerificationPath[index] = fmt.Sprintf("%x", hash)
+	}
+
+	// only the origin of a block receives the acknowledgements from the peers
+	if isLocal {
+		transactionStatus.ReplicatedBy = bc.GetBlockAcks(block.Hash)
+		replicas := len(transactionStatus.ReplicatedBy)
+		transactionStatus.Replicas = &replicas
+		transactionStatus.Receipt, _ = bc.SignReceipt(tx)
+	}
+
+	return transactionStatus
 }
 
 func getBlockchainInfo(peerChain *blockchain.Blockchain) BlockchainInfo {
@@ -1286,5 +2736,5 @@ func getBlockchainById(bf *p2p.BlockchainForest, blockchainId string) (*blockcha
 
 // NewHTTPHandler create a new instance of HTTPHandler
 func NewHTTPHandler(bf *p2p.BlockchainForest, r *pool.Receiver, p *p2p.P2P, secret string, version string) HTTPHandler {
-	return HTTPHandler{bf, r, p, secret, version}
+	return HTTPHandler{bf, r, p, secret, version, newSubscriptionHub(bf)}
 }
@@ -0,0 +1,348 @@
+package webapi
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/mux"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/p2p"
+	"github.com/codingpeasant/blocace/pool"
+)
+
+const testSecret = "secret"
+
+// newTestHandler serves a fresh blockchain with an admin account and no peers. The returned function removes it. The p2p node listens until the test exits
+func newTestHandler(t *testing.T, limits pool.AdmissionLimits) (*HTTPHandler, func()) {
+	dir, err := ioutil.TempDir("", "webapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc := blockchain.CreateBlockchain(filepath.Join(dir, "blockchain.db"), dir)
+	adminKey, _ := crypto.GenerateKey()
+	admin := blockchain.Account{PublicKey: hex.EncodeToString(crypto.FromECDSAPub(&adminKey.PublicKey)), Role: blockchain.Role{Name: "admin"}}
+	if err = bc.RegisterAccount([]byte(crypto.PubkeyToAddress(adminKey.PublicKey).String()), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	p := p2p.NewP2P(bc, "127.0.0.1", 0, "")
+	r := pool.NewReceiver(p, 100, 0, 60000, 60000, filepath.Join(dir, "pending.wal"), limits, false)
+	h := NewHTTPHandler(p.BlockchainForest, r, p, testSecret, "test")
+
+	return &h, func() {
+		bc.Db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestAccount registers an account with the permissions to write to the collections and returns its key and JWT
+func newTestAccount(t *testing.T, h *HTTPHandler, collectionsWrite []string) (*ecdsa.PrivateKey, string) {
+	privateKey, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).String()
+	account := blockchain.Account{PublicKey: hex.EncodeToString(crypto.FromECDSAPub(&privateKey.PublicKey)), Role: blockchain.Role{Name: "user", CollectionsWrite: collectionsWrite}}
+	if err := h.bf.Local.RegisterAccount([]byte(address), account); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := issueToken(address, account.Role, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return privateKey, token
+}
+
+// newLegacyCollection creates the collection legacy with an index created before _blockHeight was indexed
+func newLegacyCollection(t *testing.T, h *HTTPHandler) {
+	if _, err := h.bf.Local.Search.CreateMappingByJson([]byte(`{"collection": "legacy", "fields": {"a": {"type": "text"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	legacyMapping := bleve.NewIndexMapping()
+	legacyMapping.TypeField = "_type"
+	legacyMapping.AddDocumentMapping("legacy", bleve.NewDocumentMapping())
+	legacyIndex, err := bleve.NewMemOnly(legacyMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.bf.Local.Search.BlockchainIndices["legacy"] = legacyIndex
+}
+
+// serve calls a handler with the path variables of its route and returns the response
+func serve(handler http.HandlerFunc, method string, target string, token string, vars map[string]string, body []byte) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, mux.SetURLVars(request, vars))
+	return recorder
+}
+
+func TestAccountRegistrationDropsPermissions(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+
+	privateKey, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).String()
+	body, _ := json.Marshal(map[string]interface{}{
+		"dateOfBirth":  "2018-10-01",
+		"firstName":    "Hooper",
+		"lastName":     "Vincent",
+		"organization": "MITROC",
+		"position":     "VP of Marketing",
+		"email":        "hoopervincent@mitroc.com",
+		"phone":        "+1 (849) 503-2756",
+		"address":      "699 Canton Court, Mulino, South Dakota, 9647",
+		"publicKey":    hex.EncodeToString(crypto.FromECDSAPub(&privateKey.PublicKey)[1:]),
+		"role": map[string]interface{}{
+			"name":                    "admin",
+			"collectionsWrite":        []string{"default"},
+			"collectionsReadOverride": []string{"default"},
+			"sensitiveFieldsRead":     map[string][]string{"*": {"*"}},
+		},
+	})
+
+	if response := serve(h.AccountRegistration, "POST", "/account", "", nil, body); response.Code != http.StatusOK {
+		t.Fatalf("the account should be registered: %d %s", response.Code, response.Body)
+	}
+
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "TestAccountRegistrationDropsPermissions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Role.Name != "user" || account.Role.CollectionsWrite != nil || account.Role.CollectionsReadOverride != nil || account.Role.SensitiveFieldsRead != nil {
+		t.Errorf("a self-registered account should not grant itself any permission: %+v", account.Role)
+	}
+}
+
+func TestHandleSearchWithoutQuery(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+
+	_, token := newTestAccount(t, h, nil)
+	for _, body := range []string{"null", "{}"} {
+		if response := serve(h.HandleSearch, "POST", "/search/default", token, map[string]string{"collection": "default"}, []byte(body)); response.Code != http.StatusBadRequest {
+			t.Errorf("a search without query should be rejected: %s %d %s", body, response.Code, response.Body)
+		}
+	}
+}
+
+func TestParseAsOfTimes(t *testing.T) {
+	asOfTimes, err := parseAsOfTimes("2020-01-01T00:00:00Z,ab12:2020-06-01T00:00:00+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asOfTimes) != 2 || asOfTimes[""].Year() != 2020 || asOfTimes["ab12"].Month() != time.June {
+		t.Errorf("the times should be parsed by blockchain: %v", asOfTimes)
+	}
+
+	for _, asOfTime := range []string{"2020-01-01", "ab12:yesterday", "ab12:"} {
+		if _, err = parseAsOfTimes(asOfTime); err == nil {
+			t.Errorf("%s should be rejected", asOfTime)
+		}
+	}
+}
+
+func TestAsOfQuery(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+
+	// the documents at heights 1 and 2. The genesis block is not indexed
+	localId := fmt.Sprintf("%x", h.bf.Local.PeerId)
+	for _, acceptedTimestamp := range []string{"2000-01-01T00:00:00Z", "2010-01-01T00:00:00Z"} {
+		tx := blockchain.NewTransaction(h.bf.Local.PeerId, []byte(`{"id": 1}`), "default", nil, nil, nil)
+		accepted, _ := time.Parse(time.RFC3339, acceptedTimestamp)
+		tx.AcceptedTimestamp = accepted.UnixNano() / 1000000
+		h.bf.Local.AddBlock([]*blockchain.Transaction{tx})
+	}
+
+	cases := []struct {
+		asOfHeight string
+		asOfTime   string
+		total      uint64
+	}{
+		{"", "", 2},
+		{"1", "", 1},
+		{localId + ":0", "", 0},
+		{"ab12:0", "", 2}, // another blockchain
+		{"ab12:0,1", "", 1},
+		{"", "2005-01-01T00:00:00Z", 1},
+		{"2", localId + ":2005-01-01T00:00:00Z", 1},
+	}
+	for _, c := range cases {
+		q, err := asOfQuery(bleve.NewMatchAllQuery(), c.asOfHeight, c.asOfTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		searchResponse, err := h.bf.Local.Search.BlockchainIndices["default"].Search(bleve.NewSearchRequest(q))
+		if err != nil || searchResponse.Total != c.total {
+			t.Errorf("asOfHeight=%s asOfTime=%s expected %d documents, actual: %v %v", c.asOfHeight, c.asOfTime, c.total, searchResponse, err)
+		}
+	}
+
+	for _, asOfHeight := range []string{"-1", "tip", "xyz:1"} {
+		if _, err := asOfQuery(bleve.NewMatchAllQuery(), asOfHeight, ""); err == nil {
+			t.Errorf("asOfHeight=%s should be rejected", asOfHeight)
+		}
+	}
+}
+
+func TestHandleSearchAsOfHeightWithoutBlockHeights(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+
+	newLegacyCollection(t, h)
+	_, token := newTestAccount(t, h, nil)
+	for collection, code := range map[string]int{"legacy": http.StatusBadRequest, "default": http.StatusOK} {
+		if response := serve(h.HandleSearch, "GET", "/search/"+collection+"?q=*&asOfHeight=1", token, map[string]string{"collection": collection}, nil); response.Code != code {
+			t.Errorf("asOfHeight on %s expected %d, actual: %d %s", collection, code, response.Code, response.Body)
+		}
+	}
+}
+
+func TestHandleTransactionBulkStopsAtAdmissionLimits(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{MaxQueueLength: 1})
+	defer stop()
+	newTestCollection(t, h)
+	privateKey, token := newTestAccount(t, h, []string{"c"})
+
+	body, _ := json.Marshal(BulkTransactionPayload{Documents: signedDocuments(privateKey, 1, 2)})
+	response := serve(h.HandleTransactionBulk, "POST", "/bulk/c", token, map[string]string{"collection": "c"}, body)
+	if response.Code != http.StatusMultiStatus || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the second document rejected by the full queue with Retry-After: %d %s", response.Code, response.Body)
+	}
+
+	var bulkResponse TransactionBulkCreationResponse
+	json.Unmarshal(response.Body.Bytes(), &bulkResponse)
+	if bulkResponse.Accepted != 1 || bulkResponse.Results[0].Status != "ok" || bulkResponse.Results[1].Status == "ok" {
+		t.Errorf("expected only the first document accepted, actual: %+v", bulkResponse)
+	}
+
+	body, _ = json.Marshal(BulkTransactionPayload{Documents: signedDocuments(privateKey, 3)})
+	response = serve(h.HandleTransactionBulk, "POST", "/bulk/c", token, map[string]string{"collection": "c"}, body)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After: %d %s", response.Code, response.Body)
+	}
+}
+
+func TestHandleTransactionWithPrincipal(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+	newTestCollection(t, h)
+	principalKey, principalToken := newTestAccount(t, h, []string{"c"})
+	delegateKey, delegateToken := newTestAccount(t, h, nil)
+	principal := crypto.PubkeyToAddress(principalKey.PublicKey).String()
+	delegate := crypto.PubkeyToAddress(delegateKey.PublicKey).String()
+
+	// the delegate signs the document of the principal
+	rawDocument := `{"a": "delegated"}`
+	envelope := blockchain.Envelope{Collection: "c", Nonce: 1, PermittedAddresses: []string{delegate}}
+	signature := blockchain.Sign(*delegateKey, crypto.Keccak256(envelope.SigningMessage([]byte(rawDocument), principal)))
+	body, _ := json.Marshal(TransactionPayload{RawDocument: rawDocument, Signature: hex.EncodeToString(signature), Nonce: 1, Principal: principal})
+
+	response := serve(h.HandleTransaction, "POST", "/document/c", delegateToken, map[string]string{"collection": "c"}, body)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a delegation: %d %s", response.Code, response.Body)
+	}
+
+	now := time.Now().UnixNano() / 1000000
+	delegation := blockchain.Delegation{Principal: principal, Delegate: delegate, Collections: []string{"c"}, NotBefore: now - 1000, NotAfter: now + 60000, LastModified: now - 1000}
+	delegation.Signature = blockchain.Sign(*principalKey, crypto.Keccak256(delegation.SigningMessage()))
+	if _, err := h.bf.Local.MergeDelegation(delegation); err != nil {
+		t.Fatal(err)
+	}
+
+	response = serve(h.HandleTransaction, "POST", "/document/c", delegateToken, map[string]string{"collection": "c"}, body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected the document accepted on behalf of the principal: %d %s", response.Code, response.Body)
+	}
+
+	var transactionCreationResponse TransactionCreationResponse
+	json.Unmarshal(response.Body.Bytes(), &transactionCreationResponse)
+	transactionID, _ := hex.DecodeString(transactionCreationResponse.TransactionID)
+	if tx := h.r.GetPendingTransaction(transactionID); tx == nil || tx.Address != principal || tx.Delegation == nil || tx.Delegation.Delegate != delegate {
+		t.Errorf("expected the transaction of the principal through the delegation, actual: %+v", tx)
+	}
+
+	// revoked by the principal
+	revocation := RevocationPayload{RevokedAt: now, Signature: hex.EncodeToString(blockchain.Sign(*principalKey, crypto.Keccak256(delegation.RevocationSigningMessage(now))))}
+	revocationBody, _ := json.Marshal(revocation)
+	if response = serve(h.DelegationRevocation, "DELETE", "/account/"+principal+"/delegation/"+delegate, principalToken, map[string]string{"address": principal, "delegate": delegate}, revocationBody); response.Code != http.StatusOK {
+		t.Fatalf("expected the delegation revoked: %d %s", response.Code, response.Body)
+	}
+
+	envelope.Nonce = 2
+	signature = blockchain.Sign(*delegateKey, crypto.Keccak256(envelope.SigningMessage([]byte(rawDocument), principal)))
+	body, _ = json.Marshal(TransactionPayload{RawDocument: rawDocument, Signature: hex.EncodeToString(signature), Nonce: 2, Principal: principal})
+	if response = serve(h.HandleTransaction, "POST", "/document/c", delegateToken, map[string]string{"collection": "c"}, body); response.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after the revocation: %d %s", response.Code, response.Body)
+	}
+}
+
+func TestHandleTransactionWait(t *testing.T) {
+	// a block is cut as soon as a transaction is queued, under the pressure of the queue
+	h, stop := newTestHandler(t, pool.AdmissionLimits{MaxQueueLength: 2})
+	defer stop()
+	go h.r.Monitor()
+	newTestCollection(t, h)
+	privateKey, token := newTestAccount(t, h, []string{"c"})
+	payloads := signedDocuments(privateKey, 1, 2, 3)
+
+	// a peer acknowledges each local block
+	h.bf.Local.Search.AddIndexListener(func(block *blockchain.Block, peerId []byte) {
+		if bytes.Equal(peerId, h.bf.Local.PeerId) {
+			go h.bf.RecordBlockAck(block.Hash, []byte{1})
+		}
+	})
+
+	var transactionCreationResponse TransactionCreationResponse
+	cases := []struct {
+		query  string
+		code   int
+		status string
+	}{
+		{"wait=commit", http.StatusOK, "ok"},
+		{"wait=replicated&minPeers=1", http.StatusOK, "ok"},
+		{"wait=replicated&minPeers=2&timeout=300", http.StatusAccepted, "replication timeout"},
+	}
+	for i, c := range cases {
+		body, _ := json.Marshal(payloads[i])
+		response := serve(h.HandleTransaction, "POST", "/document/c?"+c.query, token, map[string]string{"collection": "c"}, body)
+
+		transactionCreationResponse = TransactionCreationResponse{}
+		json.Unmarshal(response.Body.Bytes(), &transactionCreationResponse)
+		if response.Code != c.code || transactionCreationResponse.Status != c.status || transactionCreationResponse.Commit == nil {
+			t.Errorf("%s expected %d %s with the commit, actual: %d %s", c.query, c.code, c.status, response.Code, response.Body)
+		}
+	}
+
+	// no block is cut in time
+	idle, stopIdle := newTestHandler(t, pool.AdmissionLimits{})
+	defer stopIdle()
+	newTestCollection(t, idle)
+	privateKey, token = newTestAccount(t, idle, []string{"c"})
+
+	body, _ := json.Marshal(signedDocuments(privateKey, 1)[0])
+	response := serve(idle.HandleTransaction, "POST", "/document/c?wait=commit&timeout=100", token, map[string]string{"collection": "c"}, body)
+	transactionCreationResponse = TransactionCreationResponse{}
+	json.Unmarshal(response.Body.Bytes(), &transactionCreationResponse)
+	if response.Code != http.StatusAccepted || transactionCreationResponse.Status != "commit timeout" || transactionCreationResponse.TransactionID == "" {
+		t.Errorf("expected 202 with the accepted transaction, actual: %d %s", response.Code, response.Body)
+	}
+}
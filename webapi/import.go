@@ -0,0 +1,322 @@
+package webapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/pool"
+)
+
+// The formats of the body to import
+const (
+	ImportFormatNDJSON = "ndjson"
+	ImportFormatCSV    = "csv"
+)
+
+// ImportLineResult tells if a line of an import is accepted or why not
+type ImportLineResult struct {
+	Line              int               `json:"line"`
+	Status            string            `json:"status"` // ok or the reason of the rejection
+	TransactionID     string            `json:"transactionID,omitempty"`
+	FieldErrorMapping map[string]string `json:"fieldErrors,omitempty"`
+}
+
+// ImportResponse has the result of each line of an import and where to resume it
+type ImportResponse struct {
+	Status   string             `json:"status"`
+	Total    int                `json:"total"`
+	Accepted int                `json:"accepted"`
+	Dropped  int                `json:"dropped"`
+	Offset   int                `json:"offset"`   // the last line read. Post the same body with ?offset= to resume after it
+	Complete bool               `json:"complete"` // false if the import stopped before the end of the body
+	Results  []ImportLineResult `json:"results"`
+}
+
+// importRecord is a line of an import as a signed document, or the error reading it
+type importRecord struct {
+	line    int
+	payload TransactionPayload
+	err     error
+}
+
+// importReader reads the body of an import line by line. next returns io.EOF at the end of the body
+type importReader interface {
+	next() (*importRecord, error)
+}
+
+// ndjsonReader reads each line as a payload of HandleTransaction. The empty lines are skipped
+type ndjsonReader struct {
+	reader *bufio.Reader
+	line   int
+	offset int
+}
+
+func (nr *ndjsonReader) next() (*importRecord, error) {
+	for {
+		content, err := nr.reader.ReadBytes('\n')
+		if err == io.EOF && len(content) == 0 {
+			return nil, io.EOF
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		nr.line++
+		content = bytes.TrimSpace(content)
+		if nr.line <= nr.offset || len(content) == 0 {
+			continue
+		}
+
+		record := importRecord{line: nr.line}
+		if err = json.Unmarshal(content, &record.payload); err != nil {
+			record.err = fmt.Errorf("error parsing the line: %s", err)
+		}
+		return &record, nil
+	}
+}
+
+// csvReader reads each record after the header as a document, mapping the columns to the fields of the collection. The header is line 1
+type csvReader struct {
+	reader          *csv.Reader
+	header          []string
+	mapping         blockchain.DocumentMapping
+	envelopeColumns map[string]int
+	line            int
+	offset          int
+}
+
+func newCSVReader(body io.Reader, mapping blockchain.DocumentMapping, offset int) (*csvReader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1 // the column count is checked by record
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the CSV header: %s", err)
+	}
+
+	envelopeColumns := make(map[string]int)
+	for i, column := range header {
+		if blockchain.IsCSVEnvelopeColumn(column) {
+			envelopeColumns[column] = i
+		}
+	}
+	if _, ok := envelopeColumns[blockchain.CSVSignatureColumn]; !ok {
+		return nil, fmt.Errorf("the CSV header has no %s column", blockchain.CSVSignatureColumn)
+	}
+
+	return &csvReader{reader: reader, header: header, mapping: mapping, envelopeColumns: envelopeColumns, line: 1, offset: offset}, nil
+}
+
+func (cr *csvReader) next() (*importRecord, error) {
+	for {
+		values, err := cr.reader.Read()
+		if _, isParseErr := err.(*csv.ParseError); err != nil && !isParseErr {
+			return nil, err
+		}
+
+		cr.line++
+		if cr.line <= cr.offset {
+			continue
+		}
+
+		record := importRecord{line: cr.line}
+		if err != nil {
+			record.err = err
+			return &record, nil
+		}
+
+		rawDocument, err := cr.mapping.CSVRecordToDocument(cr.header, values)
+		if err != nil {
+			record.err = err
+			return &record, nil
+		}
+		record.payload.RawDocument = string(rawDocument)
+		record.payload.Signature = values[cr.envelopeColumns[blockchain.CSVSignatureColumn]]
+
+		if i, ok := cr.envelopeColumns[blockchain.CSVNonceColumn]; ok && values[i] != "" {
+			if record.payload.Nonce, err = strconv.ParseUint(values[i], 10, 64); err != nil {
+				record.err = fmt.Errorf("invalid %s: %s", blockchain.CSVNonceColumn, values[i])
+			}
+		}
+		if i, ok := cr.envelopeColumns[blockchain.CSVExpiresAtColumn]; ok && values[i] != "" {
+			if record.payload.ExpiresAt, err = strconv.ParseInt(values[i], 10, 64); err != nil {
+				record.err = fmt.Errorf("invalid %s: %s", blockchain.CSVExpiresAtColumn, values[i])
+			}
+		}
+		if i, ok := cr.envelopeColumns[blockchain.CSVPermittedAddressesColumn]; ok {
+			record.payload.PermittedAddresses = strings.Fields(values[i])
+		}
+		if i, ok := cr.envelopeColumns[blockchain.CSVSignatureSchemeColumn]; ok {
+			record.payload.SignatureScheme = values[i]
+		}
+
+		return &record, nil
+	}
+}
+
+// HandleImport streams signed documents into a collection line by line, so that an import doesn't have to fit in memory and a bad line doesn't fail the others.
+// In NDJSON (?format=ndjson, the default) each line is a payload of HandleTransaction. In CSV (?format=csv or Content-Type: text/csv) the first line is the header,
+// the columns are mapped to the fields of the collection by type and the _signature, _signatureScheme, _nonce, _expiresAt and _permittedAddresses columns carry the signed envelope.
+// The signature of a CSV record covers the document of blockchain.DocumentMapping.CSVRecordToDocument. The import stops at the admission limits of the queue
+// and responds the offset to resume from with ?offset=
+// {"rawDocument": "{\"id\":\"10001\",\"message\":\"Send 1 BTC to Ivan\"}", "signature": "8e0063b7...", "nonce": 1}
+// {"rawDocument": "{\"id\":\"10002\",\"message\":\"Send 2 BTC to Ivan\"}", "signature": "5b3e1a84...", "nonce": 2}
+func (h HTTPHandler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	// find the index to operate on
+	vars := mux.Vars(r)
+	indexName := h.bf.Local.Search.ResolveCollection(vars["collection"])
+
+	documentMapping, err := h.bf.Local.Search.GetDocumentMapping(indexName)
+	if err != nil || nil == h.bf.Local.Search.BlockchainIndices[indexName] {
+		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
+		return
+	}
+
+	// check writing permission
+	address := r.Header.Get("address")
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "HandleImport")
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	if !funk.ContainsString(account.CollectionsWrite, indexName) {
+		log.WithFields(log.Fields{
+			"route":   "HandleImport",
+			"address": address,
+		}).Info("insufficient permission to write to collection: ", indexName)
+		http.Error(w, "{\"message\": \"insufficient permission to write to collection: "+indexName+"\"}", 401)
+		return
+	}
+
+	publicKey, err := hex.DecodeString(account.PublicKey)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleImport",
+			"address": address,
+		}).Error("hex.DecodeString publicKey: " + err.Error())
+		http.Error(w, "{\"message\": \"couldn't recognize the publicKey: "+err.Error()+"\"}", 500)
+		return
+	}
+
+	offset := 0
+	if !funk.IsEmpty(r.URL.Query().Get("offset")) {
+		if offset, err = strconv.Atoi(r.URL.Query().Get("offset")); err != nil || offset < 0 {
+			http.Error(w, "{\"message\": \"invalid offset: "+r.URL.Query().Get("offset")+"\"}", 400)
+			return
+		}
+	}
+
+	format := r.URL.Query().Get("format")
+	if funk.IsEmpty(format) && strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		format = ImportFormatCSV
+	}
+
+	var reader importReader
+	switch format {
+	case "", ImportFormatNDJSON:
+		reader = &ndjsonReader{reader: bufio.NewReader(r.Body), offset: offset}
+	case ImportFormatCSV:
+		if reader, err = newCSVReader(r.Body, *documentMapping, offset); err != nil {
+			http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+			return
+		}
+	default:
+		http.Error(w, "{\"message\": \"invalid format: "+format+". Must be ndjson or csv\"}", 400)
+		return
+	}
+
+	importResponse := ImportResponse{Offset: offset, Results: []ImportLineResult{}}
+	var admissionErr *pool.AdmissionError
+
+	for {
+		record, err := reader.next()
+		if err == io.EOF {
+			importResponse.Complete = true
+			break
+		} else if err != nil {
+			log.WithFields(log.Fields{
+				"route":   "HandleImport",
+				"address": address,
+			}).Warn("error reading the request body: " + err.Error())
+			break
+		}
+
+		result := ImportLineResult{Line: record.line}
+		if record.err != nil {
+			result.Status = record.err.Error()
+		} else if rawDocument, err := record.payload.document(); err != nil {
+			result.Status = err.Error()
+		} else if err = blockchain.CheckSignatureScheme(record.payload.SignatureScheme); err != nil {
+			result.Status = err.Error()
+		} else if signatureBytes, err := hex.DecodeString(record.payload.Signature); err != nil || len(signatureBytes) == 0 {
+			result.Status = "bad signature"
+		} else {
+			envelope := blockchain.Envelope{Collection: indexName, Nonce: record.payload.Nonce, ExpiresAt: record.payload.ExpiresAt, PermittedAddresses: append(record.payload.PermittedAddresses, address)}
+			isValidSig, fieldErrorMapping, tx, err := h.r.Put(rawDocument, envelope, address, account.KeyType, publicKey, signatureBytes, record.payload.SignatureScheme, nil)
+
+			// the queue is full: stop before this line for the client to resume later
+			if lineAdmissionErr, ok := err.(*pool.AdmissionError); ok {
+				admissionErr = lineAdmissionErr
+				break
+			}
+
+			if !isValidSig {
+				result.Status = "bad signature"
+			} else {
+				result.Status, _ = bulkDocumentStatus(fieldErrorMapping, err, nil)
+				result.FieldErrorMapping = fieldErrorMapping
+				if tx != nil {
+					result.TransactionID = fmt.Sprintf("%x", tx.ID)
+				}
+			}
+		}
+
+		importResponse.Total++
+		if result.Status == "ok" {
+			importResponse.Accepted++
+		} else {
+			importResponse.Dropped++
+		}
+		importResponse.Offset = record.line
+		importResponse.Results = append(importResponse.Results, result)
+	}
+
+	if admissionErr != nil {
+		setRetryAfter(w, admissionErr)
+	}
+
+	if !importResponse.Complete && importResponse.Accepted == 0 && admissionErr != nil {
+		importResponse.Status = "too many pending transactions"
+		w.WriteHeader(http.StatusTooManyRequests)
+	} else if importResponse.Complete && importResponse.Dropped == 0 {
+		importResponse.Status = "ok"
+		w.WriteHeader(http.StatusAccepted)
+	} else if importResponse.Accepted > 0 {
+		importResponse.Status = "partially accepted"
+		w.WriteHeader(http.StatusMultiStatus)
+	} else {
+		importResponse.Status = "no document accepted"
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	mustEncode(w, importResponse)
+}
@@ -0,0 +1,98 @@
+package webapi
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/pool"
+)
+
+// newTestCollection creates the collection c with a text field a
+func newTestCollection(t *testing.T, h *HTTPHandler) {
+	if _, err := h.bf.Local.Search.CreateMappingByJson([]byte(`{"collection": "c", "fields": {"a": {"type": "text"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signedDocuments signs a document of the collection c for each nonce
+func signedDocuments(privateKey *ecdsa.PrivateKey, nonces ...uint64) []TransactionPayload {
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).String()
+
+	var payloads []TransactionPayload
+	for _, nonce := range nonces {
+		rawDocument := fmt.Sprintf(`{"a": "document %d"}`, nonce)
+		envelope := blockchain.Envelope{Collection: "c", Nonce: nonce}
+		signature := blockchain.Sign(*privateKey, crypto.Keccak256(envelope.SigningMessage([]byte(rawDocument), address)))
+		payloads = append(payloads, TransactionPayload{RawDocument: rawDocument, Signature: hex.EncodeToString(signature), Nonce: nonce})
+	}
+
+	return payloads
+}
+
+// importBody writes the payloads as NDJSON
+func importBody(payloads []TransactionPayload) []byte {
+	var body bytes.Buffer
+	for _, payload := range payloads {
+		line, _ := json.Marshal(payload)
+		body.Write(append(line, '\n'))
+	}
+
+	return body.Bytes()
+}
+
+func TestHandleImportFromOffset(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+	newTestCollection(t, h)
+	privateKey, token := newTestAccount(t, h, []string{"c"})
+
+	response := serve(h.HandleImport, "POST", "/import/c?offset=1", token, map[string]string{"collection": "c"}, importBody(signedDocuments(privateKey, 1, 2, 3)))
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("expected the lines after the offset accepted: %d %s", response.Code, response.Body)
+	}
+
+	var importResponse ImportResponse
+	json.Unmarshal(response.Body.Bytes(), &importResponse)
+	if importResponse.Total != 2 || importResponse.Accepted != 2 || importResponse.Offset != 3 || !importResponse.Complete || importResponse.Results[0].Line != 2 {
+		t.Errorf("expected lines 2 and 3 imported, actual: %+v", importResponse)
+	}
+}
+
+func TestHandleImportStopsAtAdmissionLimits(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{MaxQueueLength: 2})
+	defer stop()
+	newTestCollection(t, h)
+	privateKey, token := newTestAccount(t, h, []string{"c"})
+	body := importBody(signedDocuments(privateKey, 1, 2, 3))
+
+	response := serve(h.HandleImport, "POST", "/import/c", token, map[string]string{"collection": "c"}, body)
+	if response.Code != http.StatusMultiStatus || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the import stopped at the full queue with Retry-After: %d %s", response.Code, response.Body)
+	}
+
+	var importResponse ImportResponse
+	json.Unmarshal(response.Body.Bytes(), &importResponse)
+	if importResponse.Accepted != 2 || importResponse.Offset != 2 || importResponse.Complete {
+		t.Errorf("expected the import to resume after line 2, actual: %+v", importResponse)
+	}
+
+	// resuming while the queue is still full
+	response = serve(h.HandleImport, "POST", "/import/c?offset=2", token, map[string]string{"collection": "c"}, body)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After: %d %s", response.Code, response.Body)
+	}
+
+	importResponse = ImportResponse{}
+	json.Unmarshal(response.Body.Bytes(), &importResponse)
+	if importResponse.Total != 0 || importResponse.Offset != 2 || importResponse.Complete {
+		t.Errorf("expected the offset unchanged, actual: %+v", importResponse)
+	}
+}
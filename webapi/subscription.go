@@ -0,0 +1,505 @@
+package webapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/p2p"
+)
+
+const subscriptionBufferSize = 256
+const subscriptionReplayPageSize = 100
+const subscriptionHeartbeatInterval = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true }, // same as the CORS policy
+}
+
+// SubscriptionEvent is a newly committed document matching a subscription. ResumeFrom is the fromHeight to reconnect with. It moves past a block
+// with the last matching document of the block, so a client reconnecting partway through a block receives the documents of the block again
+type SubscriptionEvent struct {
+	BlockHeight uint64              `json:"blockHeight"`
+	ResumeFrom  string              `json:"resumeFrom"`
+	Document    blockchain.Document `json:"document"`
+	isBlockEnd  bool                // the last matching document of the block
+}
+
+// subscription is a query registered by a client against a collection
+type subscription struct {
+	collection string
+	address    string
+	account    *blockchain.Account // the sensitive fields it may not read are redacted
+	query      query.Query         // with the read permission applied
+	events     chan SubscriptionEvent
+}
+
+// indexedBlock is a block that has just been indexed for the local or a peer blockchain
+type indexedBlock struct {
+	block  *blockchain.Block
+	peerId []byte
+}
+
+// subscriptionHub matches the documents of the newly indexed blocks against the live subscriptions
+type subscriptionHub struct {
+	sync.Mutex
+	bf            *p2p.BlockchainForest
+	subscriptions map[*subscription]bool
+	blocks        chan indexedBlock
+	isBehind      int32 // set when a block is dropped because the hub cannot keep up
+}
+
+// blockIndexed is the blockchain.IndexListener of the hub. It's called with the index locked so it never waits for the hub:
+// a block which doesn't fit in the queue is dropped and all the subscriptions are closed for their clients to replay it with fromHeight
+func (hub *subscriptionHub) blockIndexed(block *blockchain.Block, peerId []byte) {
+	select {
+	case hub.blocks <- indexedBlock{block: block, peerId: peerId}:
+	default:
+		atomic.StoreInt32(&hub.isBehind, 1)
+	}
+}
+
+func (hub *subscriptionHub) run() {
+	for indexed := range hub.blocks {
+		hub.Lock()
+		if atomic.CompareAndSwapInt32(&hub.isBehind, 1, 0) {
+			for sub := range hub.subscriptions {
+				hub.close(sub, "subscription queue is full, closing the subscription")
+			}
+		}
+
+		for sub := range hub.subscriptions {
+			if !hub.match(sub, indexed) {
+				// a slow client is dropped and supposed to reconnect with fromHeight
+				hub.close(sub, "subscription buffer is full, closing the subscription")
+			}
+		}
+		hub.Unlock()
+	}
+}
+
+// close ends a subscription. The hub must be locked
+func (hub *subscriptionHub) close(sub *subscription, reason string) {
+	log.WithFields(log.Fields{
+		"route":   "HandleSubscription",
+		"address": sub.address,
+	}).Warn(reason)
+	delete(hub.subscriptions, sub)
+	close(sub.events)
+}
+
+// match sends the documents of the block matching the subscription query. Returns false if the subscription cannot keep up
+func (hub *subscriptionHub) match(sub *subscription, indexed indexedBlock) bool {
+	var docIds []string
+	transactions := make(map[string]*blockchain.Transaction)
+
+	for _, tx := range indexed.block.Transactions {
+		if tx.Collection == sub.collection {
+			docId := string(append(append(indexed.block.Hash, []byte("_")...), tx.ID...))
+			docIds = append(docIds, docId)
+			transactions[docId] = tx
+		}
+	}
+
+	index := hub.bf.Local.Search.BlockchainIndices[sub.collection]
+	if len(docIds) == 0 || index == nil {
+		return true
+	}
+
+	searchRequest := bleve.NewSearchRequestOptions(query.NewConjunctionQuery([]query.Query{sub.query, query.NewDocIDQuery(docIds)}), len(docIds), 0, false)
+	searchResponse, err := index.Search(searchRequest)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleSubscription",
+			"address": sub.address,
+		}).Error("error executing query: " + err.Error())
+		return true
+	}
+
+	for i, hit := range searchResponse.Hits {
+		select {
+		case sub.events <- SubscriptionEvent{BlockHeight: indexed.block.Height, Document: redactDocuments(hub.bf.Local.Search, []blockchain.Document{getDocument(transactions[hit.ID], sub.address)}, sub.account)[0], isBlockEnd: i == len(searchResponse.Hits)-1}:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (hub *subscriptionHub) subscribe(collection string, account *blockchain.Account, address string, q query.Query) *subscription {
+	hub.Lock()
+	defer hub.Unlock()
+
+	sub := &subscription{collection: collection, address: address, account: account, query: q, events: make(chan SubscriptionEvent, subscriptionBufferSize)}
+	hub.subscriptions[sub] = true
+
+	return sub
+}
+
+func (hub *subscriptionHub) unsubscribe(sub *subscription) {
+	hub.Lock()
+	defer hub.Unlock()
+
+	if hub.subscriptions[sub] {
+		delete(hub.subscriptions, sub)
+		close(sub.events)
+	}
+}
+
+// replay sends the documents committed after fromHeights in the order of block heights. The key "" applies to all the blockchains
+func (hub *subscriptionHub) replay(sub *subscription, fromHeights map[string]uint64, send func(SubscriptionEvent) error) (map[string]bool, error) {
+	replayed := make(map[string]bool)
+	index := hub.bf.Local.Search.BlockchainIndices[sub.collection]
+	if len(fromHeights) == 0 || index == nil {
+		return replayed, nil
+	}
+
+	var heightQueries []query.Query
+	var peerIdQueries []query.Query
+	inclusive := false
+	for blockchainId, fromHeight := range fromHeights {
+		if funk.IsEmpty(blockchainId) {
+			continue
+		}
+
+		min := float64(fromHeight)
+		heightQuery := query.NewNumericRangeInclusiveQuery(&min, nil, &inclusive, nil)
+		heightQuery.SetField("_blockHeight")
+		peerIdQuery := query.NewMatchQuery(blockchainId)
+		peerIdQuery.SetField("_peerId")
+
+		heightQueries = append(heightQueries, query.NewConjunctionQuery([]query.Query{peerIdQuery, heightQuery}))
+		peerIdQueries = append(peerIdQueries, peerIdQuery)
+	}
+
+	// the height without blockchainId applies to the rest of the blockchains
+	if fromHeight, ok := fromHeights[""]; ok {
+		min := float64(fromHeight)
+		heightQuery := query.NewNumericRangeInclusiveQuery(&min, nil, &inclusive, nil)
+		heightQuery.SetField("_blockHeight")
+
+		heightQueries = append(heightQueries, query.NewBooleanQuery([]query.Query{heightQuery}, nil, peerIdQueries))
+	}
+
+	// an event is held until the next one tells if it's the last of its block
+	var held *SubscriptionEvent
+	var heldDocId string
+	sendHeld := func(next *SubscriptionEvent) error {
+		if held == nil {
+			return nil
+		}
+
+		held.isBlockEnd = next == nil || next.BlockHeight != held.BlockHeight || next.Document.BlockchainId != held.Document.BlockchainId
+		if err := send(*held); err != nil {
+			return err
+		}
+		replayed[heldDocId] = true
+
+		return nil
+	}
+
+	replayQuery := query.NewConjunctionQuery([]query.Query{sub.query, query.NewDisjunctionQuery(heightQueries)})
+	for from := 0; ; from += subscriptionReplayPageSize {
+		searchRequest := bleve.NewSearchRequestOptions(replayQuery, subscriptionReplayPageSize, from, false)
+		searchRequest.SortBy([]string{"_blockHeight", "_peerId", "_id"})
+		searchRequest.Fields = []string{"_peerId", "_blockHeight"}
+
+		searchResponse, err := index.Search(searchRequest)
+		if err != nil {
+			return replayed, err
+		}
+
+		blockHeights := make(map[string]uint64)
+		for _, hit := range searchResponse.Hits {
+			blockHeight, _ := hit.Fields["_blockHeight"].(float64)
+			blockHeights[hit.ID] = uint64(blockHeight)
+		}
+
+		for _, hitDoc := range redactDocuments(hub.bf.Local.Search, getDocuments(hub.bf, searchResponse.Hits, sub.address), sub.account) {
+			blockHash, _ := hex.DecodeString(hitDoc.BlockID)
+			txId, _ := hex.DecodeString(hitDoc.ID)
+			docId := string(append(append(blockHash, []byte("_")...), txId...))
+
+			event := SubscriptionEvent{BlockHeight: blockHeights[docId], Document: hitDoc}
+			if err = sendHeld(&event); err != nil {
+				return replayed, err
+			}
+			held, heldDocId = &event, docId
+		}
+
+		if len(searchResponse.Hits) < subscriptionReplayPageSize {
+			return replayed, sendHeld(nil)
+		}
+	}
+}
+
+// HandleSubscription streams the newly committed documents matching a query string to the client over WebSocket or Server-Sent Events
+// GET /subscribe/collection1?q=country:Canada&fromHeight=<blockchainId>:<height>,<blockchainId>:<height>
+// fromHeight (or Last-Event-ID for Server-Sent Events) replays the documents in the blocks after the heights first. A height without blockchainId applies to all the blockchains.
+// The JWT can be passed as the token parameter because browsers cannot set headers for WebSocket and EventSource
+func (h HTTPHandler) HandleSubscription(w http.ResponseWriter, r *http.Request) {
+	if funk.IsEmpty(r.Header.Get("Authorization")) && !funk.IsEmpty(r.URL.Query().Get("token")) {
+		r.Header.Set("Authorization", "Bearer "+r.URL.Query().Get("token"))
+	}
+
+	err := processJWT(r, false, h.secret)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 401)
+		return
+	}
+
+	// find the index to operate on
+	vars := mux.Vars(r)
+	indexName := h.bf.Local.Search.ResolveCollection(vars["collection"])
+
+	if nil == h.bf.Local.Search.BlockchainIndices[indexName] {
+		http.Error(w, "{\"message\": \"no such collection: "+indexName+"\"}", 404)
+		return
+	}
+
+	address := r.Header.Get("address")
+	account, err := getAccountFromDb(h.bf.Local.Db, address, "HandleSubscription")
+	if err != nil {
+		http.Error(w, "{\"message\": \"error subscribing: "+err.Error()+"\"}", 400)
+		return
+	}
+
+	var subscriptionQuery query.Query = query.NewMatchAllQuery()
+	if !funk.IsEmpty(r.URL.Query().Get("q")) {
+		queryStringQuery := bleve.NewQueryStringQuery(r.URL.Query().Get("q"))
+		if err = queryStringQuery.Validate(); err != nil {
+			http.Error(w, "{\"message\": \"error validating the query: "+err.Error()+"\"}", 400)
+			return
+		}
+		if err = checkSensitiveSearch(h.bf.Local.Search, bleve.NewSearchRequest(queryStringQuery), account, []string{indexName}); err != nil {
+			http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+			return
+		}
+		subscriptionQuery = queryStringQuery
+	}
+
+	resumeFrom := r.URL.Query().Get("fromHeight")
+	if funk.IsEmpty(resumeFrom) {
+		resumeFrom = r.Header.Get("Last-Event-ID")
+	}
+
+	fromHeights, err := parseFromHeights(resumeFrom)
+	if err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+	if err = checkBlockHeights(h.bf.Local.Search, resumeFrom, "fromHeight", []string{indexName}); err != nil {
+		http.Error(w, "{\"message\": \""+err.Error()+"\"}", 400)
+		return
+	}
+
+	// subscribe before replaying so that no block is missed in between
+	sub := h.subscriptions.subscribe(indexName, account, address, permittedQuery(subscriptionQuery, account, address, []string{indexName}))
+	defer h.subscriptions.unsubscribe(sub)
+
+	// without fromHeight, the resume point starts from the current heights and all the documents of the blockchains unknown yet
+	lastHeights := make(map[string]uint64)
+	if len(fromHeights) == 0 {
+		lastHeights[""] = 0
+		lastHeights[fmt.Sprintf("%x", h.bf.Local.PeerId)] = uint64(getBlockchainInfo(h.bf.Local).LastHeight)
+		for peerId, peer := range h.bf.Peers {
+			lastHeights[peerId] = uint64(getBlockchainInfo(peer).LastHeight)
+		}
+	} else {
+		for blockchainId, fromHeight := range fromHeights {
+			lastHeights[blockchainId] = fromHeight
+		}
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		h.streamWebSocket(w, r, sub, fromHeights, lastHeights)
+	} else {
+		h.streamEvents(w, r, sub, fromHeights, lastHeights)
+	}
+}
+
+// streamEvents sends the subscription events as Server-Sent Events
+func (h HTTPHandler) streamEvents(w http.ResponseWriter, r *http.Request, sub *subscription, fromHeights map[string]uint64, lastHeights map[string]uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "{\"message\": \"streaming is not supported\"}", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event SubscriptionEvent) error {
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		if _, err = fmt.Fprintf(w, "id: %s\nevent: document\ndata: %s\n\n", event.ResumeFrom, eventJSON); err != nil {
+			return err
+		}
+		flusher.Flush()
+
+		return nil
+	}
+
+	heartbeat := func() error {
+		_, err := fmt.Fprint(w, ": heartbeat\n\n")
+		flusher.Flush()
+		return err
+	}
+
+	streamSubscription(r, sub, fromHeights, lastHeights, h.subscriptions, send, heartbeat, r.Context().Done())
+}
+
+// streamWebSocket sends the subscription events as WebSocket JSON messages
+func (h HTTPHandler) streamWebSocket(w http.ResponseWriter, r *http.Request, sub *subscription, fromHeights map[string]uint64, lastHeights map[string]uint64) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleSubscription",
+			"address": sub.address,
+		}).Error("error upgrading to websocket: " + err.Error())
+		return
+	}
+	defer conn.Close()
+
+	// the client is not expected to send anything but reading is required to process the control messages
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event SubscriptionEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(subscriptionHeartbeatInterval))
+		return conn.WriteJSON(event)
+	}
+
+	heartbeat := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(subscriptionHeartbeatInterval))
+	}
+
+	streamSubscription(r, sub, fromHeights, lastHeights, h.subscriptions, send, heartbeat, done)
+}
+
+// streamSubscription replays the documents after fromHeights and then sends the live ones until the client goes away
+func streamSubscription(r *http.Request, sub *subscription, fromHeights map[string]uint64, lastHeights map[string]uint64, hub *subscriptionHub, send func(SubscriptionEvent) error, heartbeat func() error, done <-chan struct{}) {
+	sendAndTrack := func(event SubscriptionEvent) error {
+		if event.isBlockEnd && event.BlockHeight > lastHeights[event.Document.BlockchainId] {
+			lastHeights[event.Document.BlockchainId] = event.BlockHeight
+		}
+		event.ResumeFrom = formatFromHeights(lastHeights)
+
+		return send(event)
+	}
+
+	replayed, err := hub.replay(sub, fromHeights, sendAndTrack)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"route":   "HandleSubscription",
+			"address": r.Header.Get("address"),
+		}).Error("error replaying the subscription: " + err.Error())
+		return
+	}
+
+	ticker := time.NewTicker(subscriptionHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+
+			blockHash, _ := hex.DecodeString(event.Document.BlockID)
+			txId, _ := hex.DecodeString(event.Document.ID)
+			if replayed[string(append(append(blockHash, []byte("_")...), txId...))] {
+				continue
+			}
+
+			if err = sendAndTrack(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err = heartbeat(); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// parseFromHeights parses "<height>" or "<blockchainId>:<height>,<blockchainId>:<height>"
+func parseFromHeights(fromHeight string) (map[string]uint64, error) {
+	fromHeights := make(map[string]uint64)
+	if funk.IsEmpty(fromHeight) {
+		return fromHeights, nil
+	}
+
+	for _, blockchainHeight := range strings.Split(fromHeight, ",") {
+		var blockchainId, height string
+		if parts := strings.SplitN(blockchainHeight, ":", 2); len(parts) == 2 {
+			blockchainId, height = parts[0], parts[1]
+		} else {
+			height = parts[0]
+		}
+
+		heightInt, err := strconv.ParseUint(height, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fromHeight: %s", blockchainHeight)
+		}
+		fromHeights[blockchainId] = heightInt
+	}
+
+	return fromHeights, nil
+}
+
+// formatFromHeights formats the heights to the fromHeight parameter
+func formatFromHeights(heights map[string]uint64) string {
+	var blockchainHeights []string
+	for blockchainId, height := range heights {
+		if funk.IsEmpty(blockchainId) {
+			blockchainHeights = append(blockchainHeights, fmt.Sprintf("%d", height))
+		} else {
+			blockchainHeights = append(blockchainHeights, fmt.Sprintf("%s:%d", blockchainId, height))
+		}
+	}
+	sort.Strings(blockchainHeights)
+
+	return strings.Join(blockchainHeights, ",")
+}
+
+// newSubscriptionHub creates the hub and starts listening to the indexed blocks
+func newSubscriptionHub(bf *p2p.BlockchainForest) *subscriptionHub {
+	hub := &subscriptionHub{bf: bf, subscriptions: make(map[*subscription]bool), blocks: make(chan indexedBlock, subscriptionBufferSize)}
+	bf.Local.Search.AddIndexListener(hub.blockIndexed)
+	go hub.run()
+
+	return hub
+}
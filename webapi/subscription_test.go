@@ -0,0 +1,106 @@
+package webapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+
+	"github.com/codingpeasant/blocace/blockchain"
+	"github.com/codingpeasant/blocace/pool"
+)
+
+func TestBlockIndexedWhenHubIsBehind(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+
+	hub := &subscriptionHub{bf: h.bf, subscriptions: make(map[*subscription]bool), blocks: make(chan indexedBlock, 1)}
+	sub := hub.subscribe("default", &blockchain.Account{}, "address", bleve.NewMatchAllQuery())
+
+	indexed := make(chan bool)
+	go func() {
+		hub.blockIndexed(&blockchain.Block{Height: 1}, nil)
+		hub.blockIndexed(&blockchain.Block{Height: 2}, nil)
+		close(indexed)
+	}()
+	select {
+	case <-indexed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("indexing should not wait for a full subscription queue")
+	}
+
+	close(hub.blocks)
+	hub.run()
+	if _, isOpen := <-sub.events; isOpen || len(hub.subscriptions) != 0 {
+		t.Error("the subscriptions should be closed for their clients to replay the dropped block")
+	}
+}
+
+func TestHandleSubscriptionFromHeightWithoutBlockHeights(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+
+	newLegacyCollection(t, h)
+	_, token := newTestAccount(t, h, nil)
+	if response := serve(h.HandleSubscription, "GET", "/subscribe/legacy?fromHeight=1", token, map[string]string{"collection": "legacy"}, nil); response.Code != http.StatusBadRequest {
+		t.Errorf("fromHeight on a collection indexed without block heights should be rejected: %d %s", response.Code, response.Body)
+	}
+}
+
+func TestResumeSubscriptionPartwayThroughBlock(t *testing.T) {
+	h, stop := newTestHandler(t, pool.AdmissionLimits{})
+	defer stop()
+
+	// 3 documents at height 1 and 1 at height 2
+	for _, rawDocuments := range [][]string{{`{"id": 1}`, `{"id": 2}`, `{"id": 3}`}, {`{"id": 4}`}} {
+		var txs []*blockchain.Transaction
+		for _, rawDocument := range rawDocuments {
+			txs = append(txs, blockchain.NewTransaction(h.bf.Local.PeerId, []byte(rawDocument), "default", nil, nil, nil))
+		}
+		h.bf.Local.AddBlock(txs)
+	}
+
+	received := make(map[string]bool)
+	stream := func(resumeFrom string, maxEvents int) string {
+		fromHeights, err := parseFromHeights(resumeFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lastHeights := make(map[string]uint64)
+		for blockchainId, fromHeight := range fromHeights {
+			lastHeights[blockchainId] = fromHeight
+		}
+
+		sub := h.subscriptions.subscribe("default", &blockchain.Account{}, "address", bleve.NewMatchAllQuery())
+		defer h.subscriptions.unsubscribe(sub)
+
+		done := make(chan struct{})
+		close(done)
+		lastResumeFrom := resumeFrom
+		send := func(event SubscriptionEvent) error {
+			if len(received) == maxEvents {
+				return errors.New("disconnected")
+			}
+			received[event.Document.ID] = true
+			lastResumeFrom = event.ResumeFrom
+			return nil
+		}
+		streamSubscription(httptest.NewRequest("GET", "/subscribe/default", nil), sub, fromHeights, lastHeights, h.subscriptions, send, func() error { return nil }, done)
+
+		return lastResumeFrom
+	}
+
+	// the client goes away after the second document of block 1
+	resumeFrom := stream("0", 2)
+	if len(received) != 2 || resumeFrom != "0" {
+		t.Fatalf("the resume point should not move past a block sent partially: %d %s", len(received), resumeFrom)
+	}
+
+	stream(resumeFrom, -1)
+	if len(received) != 4 {
+		t.Errorf("resuming should receive all the documents without a gap: %d", len(received))
+	}
+}